@@ -1,2 +0,0 @@
-// Package api implements the BOSH API client functions
-package api
@@ -1,5 +0,0 @@
-package constants
-
-const (
-	Version = "0.1.0"
-)
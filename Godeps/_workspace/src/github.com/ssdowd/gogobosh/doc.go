@@ -1,2 +0,0 @@
-// Package gogobosh is a client library for applications wanting to talk to a Cloud Foundry BOSH/MicroBOSH or bosh-lite.
-package gogobosh
@@ -1,2 +0,0 @@
-// Package models contains the public repsentations of BOSH resources
-package models
@@ -1,4 +0,0 @@
-/*
-Package net includes helper libraries for performing HTTP requests & processing the responses
-*/
-package net
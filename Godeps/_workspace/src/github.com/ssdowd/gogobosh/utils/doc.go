@@ -1,4 +0,0 @@
-/*
-Package utils is a collection of utilities used within gogobosh
-*/
-package utils
@@ -0,0 +1,215 @@
+// Package ca is the broker's certificate authority: it issues the TLS
+// certificates of the Couchbase nodes it provisions.
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// keyBits is the size of the RSA keys the authority generates.  Older
+	// Couchbase releases only accept RSA node keys.
+	keyBits = 2048
+	// caValidity is how long a generated CA certificate is valid.
+	caValidity = 10 * 365 * 24 * time.Hour
+	// clockSkew backdates certificates so that nodes with slightly slow
+	// clocks accept them.
+	clockSkew = 5 * time.Minute
+)
+
+// An Authority signs certificates with the broker's CA key.
+type Authority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     *rsa.PrivateKey
+}
+
+// A Certificate is a node certificate issued by an Authority.
+type Certificate struct {
+	// ChainPEM is the certificate followed by the CA certificate,
+	// as Couchbase expects in chain.pem.
+	ChainPEM      []byte
+	PrivateKeyPEM []byte
+	SerialNumber  string
+	Hosts         []string
+	NotAfter      time.Time
+}
+
+// Generate creates a new self-signed CA named commonName.
+func Generate(commonName string) (*Authority, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:     key,
+	}, nil
+}
+
+// Load returns the Authority for a PEM encoded CA certificate and RSA key.
+func Load(certPEM, keyPEM []byte) (*Authority, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("ca: no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ca: invalid certificate: %v", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("ca: %q is not a CA certificate", cert.Subject.CommonName)
+	}
+
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("ca: no PEM private key found")
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || key.PublicKey.N.Cmp(public.N) != 0 {
+		return nil, errors.New("ca: the private key does not match the certificate")
+	}
+	return &Authority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		key:     key,
+	}, nil
+}
+
+func parsePrivateKey(block *pem.Block) (*rsa.PrivateKey, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ca: invalid private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("ca: the private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// CertificatePEM returns the PEM encoded CA certificate, which clients must
+// trust to connect to the nodes.
+func (a *Authority) CertificatePEM() []byte {
+	return a.certPEM
+}
+
+// PrivateKeyPEM returns the PEM encoded CA key, e.g. to save a generated CA.
+func (a *Authority) PrivateKeyPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(a.key)})
+}
+
+// NotAfter returns the expiry of the CA certificate.
+func (a *Authority) NotAfter() time.Time {
+	return a.cert.NotAfter
+}
+
+// Issue returns a server certificate for hosts (IP addresses or DNS names),
+// valid for validity.  The certificate never outlives the CA.
+func (a *Authority) Issue(commonName string, hosts []string, validity time.Duration) (*Certificate, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("ca: a certificate needs at least one host")
+	}
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(a.cert.NotAfter) {
+		notAfter = a.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, a.certPEM...)
+	return &Certificate{
+		ChainPEM:      chain,
+		PrivateKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		SerialNumber:  fmt.Sprintf("%x", serial),
+		Hosts:         hosts,
+		NotAfter:      notAfter,
+	}, nil
+}
+
+// CertPool returns a pool holding the CA certificate in caPEM.
+func CertPool(caPEM []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("ca: no PEM certificate found")
+	}
+	return pool, nil
+}
+
+// NeedsRotation reports whether a certificate expiring at notAfter is
+// within renewal of its expiry at now.
+func NeedsRotation(notAfter, now time.Time, renewal time.Duration) bool {
+	return !now.Add(renewal).Before(notAfter)
+}
+
+// serialNumber returns a random 128 bit certificate serial number.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
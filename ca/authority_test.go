@@ -0,0 +1,99 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestIssue(t *testing.T) {
+	authority, err := Generate("test CA")
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	// a saved CA loads back
+	authority, err = Load(authority.CertificatePEM(), authority.PrivateKeyPEM())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	cert, err := authority.Issue("instance-1", []string{"10.244.1.2", "node1.example.com"}, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	pair, err := tls.X509KeyPair(cert.ChainPEM, cert.PrivateKeyPEM)
+	if err != nil {
+		t.Fatalf("the chain and key do not make a key pair: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := CertPool(authority.CertificatePEM())
+	if err != nil {
+		t.Fatalf("CertPool: %v", err)
+	}
+	for _, host := range []string{"10.244.1.2", "node1.example.com"} {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool})
+		if err != nil {
+			t.Errorf("certificate not valid for %v: %v", host, err)
+		}
+	}
+	if _, err = leaf.Verify(x509.VerifyOptions{DNSName: "10.244.1.3", Roots: pool}); err == nil {
+		t.Errorf("certificate should not be valid for another host")
+	}
+	if !leaf.NotAfter.Equal(cert.NotAfter.Truncate(time.Second)) {
+		t.Errorf("NotAfter %v, certificate says %v", cert.NotAfter, leaf.NotAfter)
+	}
+
+	now := time.Now()
+	if NeedsRotation(cert.NotAfter, now, 7*24*time.Hour) {
+		t.Errorf("a 30 day certificate should not need rotating within 7 days")
+	}
+	if !NeedsRotation(cert.NotAfter, now, 31*24*time.Hour) {
+		t.Errorf("a 30 day certificate should need rotating within 31 days")
+	}
+
+	// certificates never outlive their CA
+	cert, err = authority.Issue("instance-1", []string{"10.244.1.2"}, 20*365*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if cert.NotAfter.After(authority.NotAfter()) {
+		t.Errorf("certificate expires %v, after its CA (%v)", cert.NotAfter, authority.NotAfter())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	first, err := Generate("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Generate("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := first.Issue("leaf", []string{"10.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafPEM := pem.EncodeToMemory(func() *pem.Block { b, _ := pem.Decode(leaf.ChainPEM); return b }())
+
+	invalid := []struct {
+		name         string
+		certPEM, key []byte
+	}{
+		{"no certificate", []byte("junk"), first.PrivateKeyPEM()},
+		{"no key", first.CertificatePEM(), []byte("junk")},
+		{"mismatched key", first.CertificatePEM(), second.PrivateKeyPEM()},
+		{"not a CA", leafPEM, leaf.PrivateKeyPEM},
+	}
+	for _, test := range invalid {
+		if _, err = Load(test.certPEM, test.key); err == nil {
+			t.Errorf("%v: expected an error", test.name)
+		}
+	}
+}
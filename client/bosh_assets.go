@@ -0,0 +1,227 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The templates pin the releases and stemcells of the deployments, e.g.
+// couchbase 0+dev.202 and stemcell 3147.  Whenever the catalog is loaded
+// (at startup and on GET /v2/catalog), the broker builds the manifest of
+// every plan and checks that the director has what it references, so that a
+// missing upload shows in a report instead of in failed provisions.  With
+// director_asset_check "plans" (the default), the affected plans are left
+// out of the catalog; with "strict", the catalog is rejected, and the broker
+// does not start.
+
+const (
+	assetCheckPlans  = "plans"
+	assetCheckStrict = "strict"
+	assetCheckOff    = "off"
+)
+
+// assetCheckDeployment names the manifests built to find the references of
+// the plans; they are never deployed.
+const assetCheckDeployment = "asset-check"
+
+// A directorAsset is a release or stemcell a manifest references.  v2
+// manifests name stemcells by OS.
+type directorAsset struct {
+	kind    string
+	name    string
+	os      string
+	version string
+}
+
+func (a directorAsset) String() string {
+	name := a.name
+	if name == "" {
+		name = a.os
+	}
+	return fmt.Sprintf("%v %v/%v", a.kind, name, a.version)
+}
+
+// manifestAssets returns the releases and stemcells manifestYAML references.
+func manifestAssets(manifestYAML []byte) ([]directorAsset, error) {
+	type nameVersion struct {
+		Name    string `yaml:"name"`
+		OS      string `yaml:"os"`
+		Version string `yaml:"version"`
+	}
+	var deployment struct {
+		Releases      []nameVersion `yaml:"releases"`
+		ResourcePools []struct {
+			Stemcell nameVersion `yaml:"stemcell"`
+		} `yaml:"resource_pools"`
+		Stemcells []nameVersion `yaml:"stemcells"`
+	}
+	err := yaml.Unmarshal(manifestYAML, &deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []directorAsset
+	seen := make(map[directorAsset]bool)
+	add := func(asset directorAsset) {
+		if !seen[asset] {
+			seen[asset] = true
+			assets = append(assets, asset)
+		}
+	}
+	for _, r := range deployment.Releases {
+		add(directorAsset{kind: "release", name: r.Name, version: r.Version})
+	}
+	for _, pool := range deployment.ResourcePools {
+		add(directorAsset{kind: "stemcell", name: pool.Stemcell.Name, version: pool.Stemcell.Version})
+	}
+	for _, s := range deployment.Stemcells {
+		add(directorAsset{kind: "stemcell", name: s.Name, os: s.OS, version: s.Version})
+	}
+	return assets, nil
+}
+
+// uploadedVersions returns the versions of asset the director has.
+func uploadedVersions(asset directorAsset, releases []directorRelease, stemcells []directorStemcell) []string {
+	var versions []string
+	if asset.kind == "release" {
+		for _, release := range releases {
+			if release.Name == asset.name {
+				for _, v := range release.Versions {
+					versions = append(versions, v.Version)
+				}
+			}
+		}
+		return versions
+	}
+	for _, stemcell := range stemcells {
+		if (asset.name != "" && stemcell.Name == asset.name) || (asset.os != "" && stemcell.OperatingSystem == asset.os) {
+			versions = append(versions, stemcell.Version)
+		}
+	}
+	return versions
+}
+
+// assetUploaded reports whether the director has the version of asset, or
+// any version for "latest".
+func assetUploaded(asset directorAsset, versions []string) bool {
+	if asset.version == "latest" {
+		return len(versions) > 0
+	}
+	return contains(versions, asset.version)
+}
+
+// checkDirectorAssets checks the releases and stemcells of the plans of
+// catalog against the director, and returns the catalog of the available
+// plans, or an error holding the report in strict mode.  If the director
+// cannot list them, the plans are left unchecked.
+func (c *BoshClient) checkDirectorAssets(catalog *model.Catalog) (*model.Catalog, error) {
+	mode := c.dProps.DirectorAssetCheck
+	switch mode {
+	case assetCheckOff:
+		return catalog, nil
+	case "", assetCheckPlans, assetCheckStrict:
+	default:
+		return nil, fmt.Errorf("director_asset_check %q must be %v, %v or %v", mode, assetCheckPlans, assetCheckStrict, assetCheckOff)
+	}
+
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		return nil, err
+	}
+	releases, err := boshclient.GetReleases()
+	if err != nil {
+		utils.Logger.Printf("client.bosh.checkDirectorAssets: could not list the releases of the director, the plans are not checked: %v\n", err)
+		return catalog, nil
+	}
+	stemcells, err := boshclient.GetStemcells()
+	if err != nil {
+		utils.Logger.Printf("client.bosh.checkDirectorAssets: could not list the stemcells of the director, the plans are not checked: %v\n", err)
+		return catalog, nil
+	}
+
+	// the missing assets, in the order found, and the plans needing them
+	var missing []directorAsset
+	neededBy := make(map[directorAsset][]string)
+	var problems []string
+	unavailable := make(map[string]bool)
+	for _, s := range catalog.Services {
+		for i := range s.Plans {
+			plan := &s.Plans[i]
+			assets, err := c.planAssets(plan)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("plan %v: %v", plan.Name, err))
+				unavailable[plan.ID] = true
+				continue
+			}
+			for _, asset := range assets {
+				if assetUploaded(asset, uploadedVersions(asset, releases, stemcells)) {
+					continue
+				}
+				if neededBy[asset] == nil {
+					missing = append(missing, asset)
+				}
+				neededBy[asset] = append(neededBy[asset], plan.Name)
+				unavailable[plan.ID] = true
+			}
+		}
+	}
+	if len(unavailable) == 0 {
+		return catalog, nil
+	}
+
+	for _, asset := range missing {
+		versions := uploadedVersions(asset, releases, stemcells)
+		sort.Strings(versions)
+		uploaded := strings.Join(versions, ", ")
+		if uploaded == "" {
+			uploaded = "none"
+		}
+		plans := "plan "
+		if len(neededBy[asset]) > 1 {
+			plans = "plans "
+		}
+		problems = append(problems, fmt.Sprintf("%v is not uploaded (uploaded: %v), needed by %v%v", asset, uploaded, plans, strings.Join(neededBy[asset], ", ")))
+	}
+	report := "the BOSH director lacks what the templates reference:\n  " + strings.Join(problems, "\n  ")
+	if mode == assetCheckStrict {
+		return nil, fmt.Errorf("%v", report)
+	}
+
+	available := &model.Catalog{}
+	var left []string
+	for _, s := range catalog.Services {
+		var plans []model.ServicePlan
+		for _, plan := range s.Plans {
+			if unavailable[plan.ID] {
+				left = append(left, plan.Name)
+			} else {
+				plans = append(plans, plan)
+			}
+		}
+		if len(plans) > 0 {
+			s.Plans = plans
+			available.Services = append(available.Services, s)
+		}
+	}
+	utils.Logger.Printf("client.bosh.checkDirectorAssets: %v\nplans left out of the catalog: %v\n", report, strings.Join(left, ", "))
+	return available, nil
+}
+
+// planAssets returns the releases and stemcells the manifests of plan
+// reference.
+func (c *BoshClient) planAssets(plan *model.ServicePlan) ([]directorAsset, error) {
+	cbProps, err := cbPlanProps(plan)
+	if err != nil {
+		return nil, err
+	}
+	manifestYAML, err := c.buildManifest(assetCheckDeployment, assetCheckDeployment, cbProps, cbProps.instances)
+	if err != nil {
+		return nil, err
+	}
+	return manifestAssets(manifestYAML)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestDirectorAssets(t *testing.T) {
+	listed := true
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !listed {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/releases":
+			fmt.Fprint(w, `[{"name": "couchbase", "release_versions": [{"version": "0+dev.201"}, {"version": "0+dev.202"}]}]`)
+		case "/stemcells":
+			fmt.Fprint(w, `[{"name": "bosh-warden-boshlite-ubuntu-trusty-go_agent", "operating_system": "ubuntu-trusty", "version": "3421"},
+				{"name": "bosh-vsphere-esxi-ubuntu-trusty-go_agent", "operating_system": "ubuntu-trusty", "version": "3147"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	templateDir, err := filepath.Abs(filepath.Join("..", "bosh-templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.BoshConfig{
+		TemplateDir: templateDir,
+		TemplateProfiles: map[string]*config.TemplateProfile{
+			"vsphere": {
+				Templates: []string{"base-cb-deploy.yml", "network-manual.yml", "resources-iaas.yml", "couchbase-job-defaults.yml"},
+				Vars: map[string]interface{}{
+					"network_name":                   "vm-network",
+					"network_range":                  "10.0.8.0/24",
+					"network_gateway":                "10.0.8.1",
+					"network_dns":                    []interface{}{"10.0.0.2"},
+					"network_reserved":               []interface{}{"10.0.8.2 - 10.0.8.9"},
+					"network_static":                 []interface{}{"10.0.8.10 - 10.0.8.40"},
+					"network_cloud_properties":       map[string]interface{}{},
+					"stemcell_name":                  "bosh-vsphere-esxi-ubuntu-trusty-go_agent",
+					"stemcell_version":               3147,
+					"resource_pool_cloud_properties": map[string]interface{}{},
+					"compilation_cloud_properties":   map[string]interface{}{},
+				},
+			},
+		},
+	}
+	c, stop := newFakeDirector(t, conf, handler)
+	defer stop()
+	newCatalog := func() *model.Catalog {
+		return &model.Catalog{Services: []model.Service{{Name: "couchbase", Plans: []model.ServicePlan{
+			{Name: "lite", ID: "1"},
+			{Name: "vsphere", ID: "2", Metadata: map[string]interface{}{"templateProfile": "vsphere"}},
+			{Name: "v2", ID: "3", Metadata: map[string]interface{}{
+				"cloudConfig": map[string]interface{}{"vmType": "medium", "network": "services", "azs": []string{"z1"}},
+			}},
+		}}}}
+	}
+
+	// the bosh-lite templates pin stemcell 3147, which the director lacks
+	err = c.SetCatalog(newCatalog())
+	if err != nil {
+		t.Fatalf("SetCatalog: %v", err)
+	}
+	var plans []string
+	for _, plan := range c.GetCatalog().Services[0].Plans {
+		plans = append(plans, plan.Name)
+	}
+	if strings.Join(plans, ",") != "vsphere,v2" {
+		t.Errorf("available plans: %v", plans)
+	}
+
+	conf.DirectorAssetCheck = "strict"
+	err = c.SetCatalog(newCatalog())
+	want := "stemcell bosh-warden-boshlite-ubuntu-trusty-go_agent/3147 is not uploaded (uploaded: 3421), needed by plan lite"
+	if err == nil || !strings.Contains(err.Error(), want) || strings.Contains(err.Error(), "release") {
+		t.Errorf("strict SetCatalog: got %v, want a report with %q", err, want)
+	}
+
+	// a director that cannot list its uploads leaves the plans unchecked
+	listed = false
+	if err = c.SetCatalog(newCatalog()); err != nil || len(c.GetCatalog().Services[0].Plans) != 3 {
+		t.Errorf("SetCatalog without listings: %v", err)
+	}
+	conf.DirectorAssetCheck = "sometimes"
+	if err = c.SetCatalog(newCatalog()); err == nil {
+		t.Errorf("an unknown director_asset_check was accepted")
+	}
+}
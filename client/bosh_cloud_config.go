@@ -0,0 +1,187 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Plans with a cloudConfig get a BOSH v2 manifest: instance groups placed in
+// AZs and referring to the vm_type, network and disk_type of the director's
+// cloud-config, which the broker checks before deploying.
+
+const (
+	defaultStemcellOS      = "ubuntu-trusty"
+	defaultStemcellVersion = "latest"
+	// stemcellAlias is the name the instance groups use for the stemcell.
+	stemcellAlias = "default"
+	// couchbaseRelease is the BOSH release holding the Couchbase job.
+	couchbaseRelease = "couchbase"
+)
+
+// validateCloudConfig checks the cloud-config names of a plan.
+func (props cbDefaultSettings) validateCloudConfig() error {
+	cc := props.cloudConfig
+	if cc == nil {
+		for _, group := range props.topology {
+			if group.VMType != "" || group.PersistentDiskType != "" {
+				return fmt.Errorf("topology group %q: vmType and persistentDiskType need a cloudConfig", group.Name)
+			}
+		}
+		return nil
+	}
+	if cc.VMType == "" {
+		for _, group := range props.topology {
+			if group.VMType == "" {
+				return fmt.Errorf("cloudConfig needs a vmType, or every topology group one")
+			}
+		}
+		if props.topology == nil {
+			return fmt.Errorf("cloudConfig needs a vmType")
+		}
+	}
+	if cc.Network == "" {
+		return fmt.Errorf("cloudConfig needs a network")
+	}
+	if len(cc.AZs) == 0 {
+		return fmt.Errorf("cloudConfig needs at least one AZ")
+	}
+	for i, az := range cc.AZs {
+		if az == "" {
+			return fmt.Errorf("cloudConfig has an empty AZ name")
+		}
+		if contains(cc.AZs[:i], az) {
+			return fmt.Errorf("cloudConfig lists AZ %q twice", az)
+		}
+	}
+	return nil
+}
+
+// stemcellOS returns the OS of the stemcell of a v2 manifest.
+func (props cbDefaultSettings) stemcellOS() string {
+	if props.cloudConfig.StemcellOS != "" {
+		return props.cloudConfig.StemcellOS
+	}
+	return defaultStemcellOS
+}
+
+// stemcellVersion returns the version of the stemcell of a v2 manifest.
+func (props cbDefaultSettings) stemcellVersion() string {
+	if props.cloudConfig.StemcellVersion != "" {
+		return props.cloudConfig.StemcellVersion
+	}
+	return defaultStemcellVersion
+}
+
+// checkCloudConfig makes sure the director's cloud-config defines everything
+// a v2 manifest refers to, so that a mistake in a plan fails before the
+// director starts a task.
+func (c *BoshClient) checkCloudConfig(manifestYAML []byte) error {
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		return err
+	}
+	cloudConfig, err := boshclient.GetCloudConfig()
+	if err != nil {
+		return fmt.Errorf("Could not fetch the BOSH cloud-config: %v", err)
+	}
+	return checkManifestCloudConfig(manifestYAML, cloudConfig)
+}
+
+// v2Manifest holds what a v2 manifest takes from the cloud-config.
+type v2Manifest struct {
+	InstanceGroups []struct {
+		Name               string
+		AZs                []string `yaml:"azs"`
+		VMType             string   `yaml:"vm_type"`
+		PersistentDiskType string   `yaml:"persistent_disk_type"`
+		Networks           []struct {
+			Name string
+		}
+	} `yaml:"instance_groups"`
+}
+
+// checkManifestCloudConfig returns an error listing every name the instance
+// groups of manifestYAML use that cloudConfig does not define.
+func checkManifestCloudConfig(manifestYAML []byte, cloudConfig directorCloudConfig) error {
+	var manifest v2Manifest
+	err := yaml.Unmarshal(manifestYAML, &manifest)
+	if err != nil {
+		return err
+	}
+	if len(manifest.InstanceGroups) == 0 {
+		return errors.New("the manifest has no instance groups")
+	}
+
+	azs := itemNames(cloudConfig.AZs)
+	vmTypes := itemNames(cloudConfig.VMTypes)
+	diskTypes := itemNames(cloudConfig.DiskTypes)
+	networks := make(map[string]*cloudConfigNetwork)
+	var networkNames []string
+	for _, network := range cloudConfig.Networks {
+		networks[network.Name] = network
+		networkNames = append(networkNames, network.Name)
+	}
+
+	var problems []string
+	missing := func(group, kind, name string, defined []string) {
+		problems = append(problems, fmt.Sprintf("instance group %v: %v %q is not defined (the cloud-config has %v)",
+			group, kind, name, strings.Join(defined, ", ")))
+	}
+	for _, group := range manifest.InstanceGroups {
+		if !contains(vmTypes, group.VMType) {
+			missing(group.Name, "vm_type", group.VMType, vmTypes)
+		}
+		if group.PersistentDiskType != "" && !contains(diskTypes, group.PersistentDiskType) {
+			missing(group.Name, "disk_type", group.PersistentDiskType, diskTypes)
+		}
+		for _, az := range group.AZs {
+			if !contains(azs, az) {
+				missing(group.Name, "az", az, azs)
+			}
+		}
+		for _, n := range group.Networks {
+			network, ok := networks[n.Name]
+			if !ok {
+				missing(group.Name, "network", n.Name, networkNames)
+				continue
+			}
+			subnetAZs := networkAZs(network)
+			if len(subnetAZs) == 0 {
+				// e.g. a dynamic network, available everywhere
+				continue
+			}
+			for _, az := range group.AZs {
+				if !contains(subnetAZs, az) {
+					problems = append(problems, fmt.Sprintf("instance group %v: network %q has no subnet in az %q", group.Name, n.Name, az))
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the manifest does not fit the director's cloud-config: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func itemNames(items []*cloudConfigItem) []string {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.Name
+	}
+	return names
+}
+
+// networkAZs returns the AZs the subnets of network are in.
+func networkAZs(network *cloudConfigNetwork) []string {
+	var azs []string
+	for _, subnet := range network.Subnets {
+		if subnet.AZ != "" {
+			azs = append(azs, subnet.AZ)
+		}
+		azs = append(azs, subnet.AZs...)
+	}
+	return azs
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/manifest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestCloudConfig(t *testing.T) {
+	plan := &model.ServicePlan{
+		Name: "v2",
+		Metadata: map[string]interface{}{
+			"topology": []map[string]interface{}{
+				{"name": "data", "services": []string{"kv"}, "instances": 3},
+				{"name": "query", "services": []string{"index", "n1ql"}, "instances": 2, "vmType": "large"},
+			},
+			"cloudConfig": map[string]interface{}{
+				"vmType":             "medium",
+				"network":            "services",
+				"persistentDiskType": "10GB",
+				"azs":                []string{"z1", "z2"},
+			},
+		},
+	}
+	props, err := cbPlanProps(plan)
+	if err != nil {
+		t.Fatalf("cbPlanProps: %v", err)
+	}
+	stub, err := manifestStub("cb-1", "uuid", props, 0)
+	if err != nil {
+		t.Fatalf("manifestStub: %v", err)
+	}
+	var paths []string
+	for _, template := range manifestTemplates(builtinProfiles["cloud-config"], props) {
+		paths = append(paths, filepath.Join("..", "bosh-templates", template))
+	}
+	sources, err := manifest.ReadFiles(paths...)
+	if err != nil {
+		t.Fatalf("ReadFiles: %v", err)
+	}
+	manifestYAML, err := manifest.Merge(append(sources, manifest.Source{Name: "stub", YAML: stub}), "couchbase")
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	for _, want := range []string{"instance_groups:", "name: couchbase4-query", "vm_type: large", "vm_type: medium", "persistent_disk_type: 10GB", "- z2", "alias: default"} {
+		if !strings.Contains(string(manifestYAML), want) {
+			t.Errorf("manifest lacks %q:\n%s", want, manifestYAML)
+		}
+	}
+	if strings.Contains(string(manifestYAML), "resource_pool") {
+		t.Errorf("v2 manifest refers to a resource pool:\n%s", manifestYAML)
+	}
+
+	cloudConfig := directorCloudConfig{
+		AZs:       []*cloudConfigItem{{Name: "z1"}, {Name: "z2"}},
+		VMTypes:   []*cloudConfigItem{{Name: "medium"}, {Name: "large"}},
+		DiskTypes: []*cloudConfigItem{{Name: "10GB"}},
+		Networks: []*cloudConfigNetwork{{
+			Name:    "services",
+			Subnets: []*cloudConfigSubnet{{AZ: "z1"}, {AZs: []string{"z2"}}},
+		}},
+	}
+	if err = checkManifestCloudConfig(manifestYAML, cloudConfig); err != nil {
+		t.Errorf("checkManifestCloudConfig: %v", err)
+	}
+	cloudConfig.VMTypes = cloudConfig.VMTypes[:1]
+	cloudConfig.Networks[0].Subnets = cloudConfig.Networks[0].Subnets[:1]
+	err = checkManifestCloudConfig(manifestYAML, cloudConfig)
+	if err == nil || !strings.Contains(err.Error(), `vm_type "large"`) || !strings.Contains(err.Error(), `no subnet in az "z2"`) {
+		t.Errorf("expected the missing vm_type and subnet to be reported, got %v", err)
+	}
+
+	invalid := []map[string]interface{}{
+		{"cloudConfig": map[string]interface{}{"network": "services", "azs": []string{"z1"}}},
+		{"cloudConfig": map[string]interface{}{"vmType": "medium", "azs": []string{"z1"}}},
+		{"cloudConfig": map[string]interface{}{"vmType": "medium", "network": "services"}},
+		{"cloudConfig": map[string]interface{}{"vmType": "medium", "network": "services", "azs": []string{"z1", "z1"}}},
+		{"topology": []map[string]interface{}{{"name": "data", "services": []string{"kv"}, "instances": 1, "vmType": "large"}}},
+	}
+	for _, metadata := range invalid {
+		plan.Metadata = metadata
+		if _, err = cbPlanProps(plan); err == nil {
+			t.Errorf("expected plan metadata %v to be rejected", metadata)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	config "github.com/ssdowd/couchbasebroker/config"
+	uaa "github.com/ssdowd/couchbasebroker/uaa"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// A directorTransport authenticates every request to the BOSH director: with
+// a UAA token when a director client is configured, else with the director
+// user's basic auth.
+type directorTransport struct {
+	base   http.RoundTripper
+	config *config.BoshConfig
+
+	mutex sync.Mutex
+	// tokens gets the tokens of the client and secret it was created
+	// with; it is replaced when they are reloaded.
+	tokens       *uaa.TokenSource
+	client       string
+	clientSecret string
+}
+
+// Limits of the connections to the director.  Its requests are short:
+// deploys and deletes return a task to poll.
+const (
+	directorDialTimeout    = 30 * time.Second
+	directorTLSTimeout     = 10 * time.Second
+	directorIdleTimeout    = 90 * time.Second
+	directorRequestTimeout = 2 * time.Minute
+	directorIdleConns      = 4
+)
+
+// newDirectorTransport returns the transport of all the requests to the
+// director, verifying its certificate as configured.  It keeps its
+// connections open for reuse.
+func newDirectorTransport(conf *config.BoshConfig) (*directorTransport, error) {
+	tlsConfig, err := directorTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &directorTransport{
+		base: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   directorDialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   directorTLSTimeout,
+			IdleConnTimeout:       directorIdleTimeout,
+			MaxIdleConnsPerHost:   directorIdleConns,
+			ExpectContinueTimeout: time.Second,
+		},
+		config: conf,
+	}, nil
+}
+
+// directorTLSConfig returns the TLS settings of the connections to the
+// director: trusting director_ca_cert if set, the system roots otherwise.
+func directorTLSConfig(conf *config.BoshConfig) (*tls.Config, error) {
+	if conf.DirectorInsecureSkipVerify {
+		if conf.DirectorCACert != "" {
+			return nil, errors.New("director_ca_cert and director_insecure_skip_verify are mutually exclusive")
+		}
+		utils.Logger.Printf("client.bosh: WARNING: the certificate of the director at %v is not verified (director_insecure_skip_verify)\n", conf.DirectorURL)
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	if conf.DirectorCACert == "" {
+		return &tls.Config{}, nil
+	}
+	caPEM := []byte(conf.DirectorCACert)
+	if !strings.Contains(conf.DirectorCACert, "-----BEGIN") {
+		var err error
+		caPEM, err = utils.ReadFile(conf.DirectorCACert)
+		if err != nil {
+			return nil, fmt.Errorf("director_ca_cert: %v", err)
+		}
+	}
+	pool, err := ca.CertPool(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("director_ca_cert: %v", err)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// RoundTrip sends req with the director credentials.  With UAA, a request
+// the director rejects as unauthorized is retried once with a new token, in
+// case the cached one was revoked.
+func (t *directorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.config.UsesUAA() {
+		authed := req.Clone(req.Context())
+		authed.SetBasicAuth(t.config.DirectorCredentials())
+		return t.base.RoundTrip(authed)
+	}
+
+	tokens, err := t.tokenSource()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTripWithToken(req, tokens)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// the body was consumed and cannot be sent again
+		return resp, nil
+	}
+	resp.Body.Close()
+	utils.Logger.Printf("client.bosh: the director rejected the UAA token, getting a new one\n")
+	tokens.Invalidate()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.roundTripWithToken(retry, tokens)
+}
+
+func (t *directorTransport) roundTripWithToken(req *http.Request, tokens *uaa.TokenSource) (*http.Response, error) {
+	token, err := tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("could not get a UAA token for the director: %v", err)
+	}
+	authed := req.Clone(req.Context())
+	authed.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(authed)
+}
+
+// tokenSource returns the source of the director tokens, created for the
+// current client credentials.
+func (t *directorTransport) tokenSource() (*uaa.TokenSource, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	client, clientSecret := t.config.DirectorClientCredentials()
+	if t.tokens != nil && client == t.client && clientSecret == t.clientSecret {
+		return t.tokens, nil
+	}
+
+	uaaURL := t.config.DirectorUAAURL
+	if uaaURL == "" {
+		var err error
+		uaaURL, err = t.discoverUAA()
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.tokens = uaa.NewTokenSource(uaaURL, client, clientSecret, &http.Client{Transport: t.base, Timeout: directorRequestTimeout})
+	t.client = client
+	t.clientSecret = clientSecret
+	return t.tokens, nil
+}
+
+type directorInfo struct {
+	Name               string `json:"name"`
+	UUID               string `json:"uuid"`
+	UserAuthentication struct {
+		Type    string `json:"type"`
+		Options struct {
+			URL string `json:"url"`
+		} `json:"options"`
+	} `json:"user_authentication"`
+}
+
+// discoverUAA returns the URL of the UAA the director advertises in its
+// (unauthenticated) /info.
+func (t *directorTransport) discoverUAA() (string, error) {
+	httpClient := &http.Client{Transport: t.base, Timeout: directorRequestTimeout}
+	resp, err := httpClient.Get(strings.TrimRight(t.config.DirectorURL, "/") + "/info")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not fetch the director info: %v", resp.Status)
+	}
+	var info directorInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return "", err
+	}
+	if info.UserAuthentication.Type != "uaa" || info.UserAuthentication.Options.URL == "" {
+		return "", errors.New("director_client is set but the director does not use UAA")
+	}
+	utils.Logger.Printf("client.bosh: the director uses the UAA at %v\n", info.UserAuthentication.Options.URL)
+	return info.UserAuthentication.Options.URL, nil
+}
+
+// directorClient returns an HTTP client for the director, sharing the
+// client's transport.  checkRedirect is the redirect policy.
+func (c *BoshClient) directorClient(checkRedirect func(*http.Request, []*http.Request) error) *http.Client {
+	return &http.Client{
+		Transport:     c.transport,
+		CheckRedirect: checkRedirect,
+		Timeout:       directorRequestTimeout,
+	}
+}
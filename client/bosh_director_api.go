@@ -0,0 +1,333 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	utils "github.com/ssdowd/couchbasebroker/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The broker calls the director's REST API itself, over the directorTransport
+// of the BoshClient, which authenticates the requests and verifies the
+// director's certificate.  The types below hold what the broker uses of the
+// director's resources.
+
+// A boshDirector makes requests to the director.  It does not follow
+// redirects: the director answers the requests that start a task with a
+// redirect to it.
+type boshDirector struct {
+	url        string
+	httpClient *http.Client
+}
+
+// createBoshClient returns a boshDirector for the configured director.
+func (c *BoshClient) createBoshClient() (*boshDirector, error) {
+	return &boshDirector{
+		url: strings.TrimRight(c.dProps.DirectorURL, "/"),
+		httpClient: c.directorClient(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}),
+	}, nil
+}
+
+// directorPollInterval is how often the director is polled for the tasks
+// the broker waits for in a single call, e.g. listing the VMs.
+var directorPollInterval = time.Second
+
+// A directorTask is the state of a task of the director.
+type directorTask struct {
+	ID          int    `json:"id"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Result      string `json:"result"`
+}
+
+// A directorVM is a VM of a deployment.
+type directorVM struct {
+	JobName  string   `json:"job_name"`
+	Index    int      `json:"index"`
+	JobState string   `json:"job_state"`
+	VMCid    string   `json:"vm_cid"`
+	IPs      []string `json:"ips"`
+	AZ       string   `json:"az"`
+}
+
+// A directorRelease is a release uploaded to the director, with its
+// versions.
+type directorRelease struct {
+	Name     string `json:"name"`
+	Versions []struct {
+		Version string `json:"version"`
+	} `json:"release_versions"`
+}
+
+// A directorStemcell is a stemcell uploaded to the director.
+type directorStemcell struct {
+	Name            string `json:"name"`
+	OperatingSystem string `json:"operating_system"`
+	Version         string `json:"version"`
+}
+
+// A directorCloudConfig lists the names the director's cloud-config defines,
+// which v2 manifests refer to.
+type directorCloudConfig struct {
+	AZs          []*cloudConfigItem    `yaml:"azs"`
+	VMTypes      []*cloudConfigItem    `yaml:"vm_types"`
+	VMExtensions []*cloudConfigItem    `yaml:"vm_extensions"`
+	DiskTypes    []*cloudConfigItem    `yaml:"disk_types"`
+	Networks     []*cloudConfigNetwork `yaml:"networks"`
+}
+
+// A cloudConfigItem is a named entry of the cloud-config.
+type cloudConfigItem struct {
+	Name string `yaml:"name"`
+}
+
+// A cloudConfigNetwork is a network of the cloud-config, and the AZs of its
+// subnets.
+type cloudConfigNetwork struct {
+	Name    string               `yaml:"name"`
+	Type    string               `yaml:"type"`
+	Subnets []*cloudConfigSubnet `yaml:"subnets"`
+}
+
+// A cloudConfigSubnet is a subnet of a cloud-config network.
+type cloudConfigSubnet struct {
+	AZ  string   `yaml:"az"`
+	AZs []string `yaml:"azs"`
+}
+
+// A directorError is an error status the director answered a request with.
+type directorError struct {
+	method      string
+	path        string
+	status      string
+	statusCode  int
+	description string
+}
+
+func (e *directorError) Error() string {
+	if e.description == "" {
+		return fmt.Sprintf("%v %v: %v", e.method, e.path, e.status)
+	}
+	return fmt.Sprintf("%v %v: %v: %v", e.method, e.path, e.status, e.description)
+}
+
+// do sends a request to the director, returning a directorError for the
+// statuses other than 2xx and redirects.
+func (d *boshDirector) do(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, d.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	utils.Logger.Printf("client.bosh: %v %v: %v\n", method, path, resp.Status)
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		var description struct {
+			Description string `json:"description"`
+		}
+		json.NewDecoder(resp.Body).Decode(&description)
+		return nil, &directorError{method, path, resp.Status, resp.StatusCode, description.Description}
+	}
+	return resp, nil
+}
+
+// get GETs a JSON resource into result.
+func (d *boshDirector) get(path string, result interface{}) error {
+	resp, err := d.do("GET", path, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v: %v", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// startTask sends a request that starts a task, returning its ID from the
+// redirect the director answers with.
+func (d *boshDirector) startTask(method, path string, body io.Reader, contentType string) (int, error) {
+	resp, err := d.do(method, path, body, contentType)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound && resp.StatusCode != http.StatusSeeOther {
+		return 0, fmt.Errorf("%v %v: %v, not a redirect to a task", method, path, resp.Status)
+	}
+	return taskIDFromURL(resp.Header.Get("Location"))
+}
+
+// taskIDFromURL returns the ID of the task at location, e.g. /tasks/42.
+func taskIDFromURL(location string) (int, error) {
+	taskURL, err := url.Parse(location)
+	if err != nil {
+		return 0, fmt.Errorf("invalid task URL %q: %v", location, err)
+	}
+	taskID, err := strconv.Atoi(path.Base(taskURL.Path))
+	if err != nil || path.Base(path.Dir(taskURL.Path)) != "tasks" {
+		return 0, fmt.Errorf("%q is not the URL of a task", location)
+	}
+	return taskID, nil
+}
+
+// waitForDone polls task taskID until it is done, returning an error if it
+// fails.
+func (d *boshDirector) waitForDone(taskID int) error {
+	for {
+		status, err := d.GetTaskStatus(taskID)
+		if err != nil {
+			return err
+		}
+		if status.State == "done" {
+			return nil
+		}
+		if taskFailed(status.State) {
+			return fmt.Errorf("BOSH task %d %v: %v", taskID, status.State, status.Result)
+		}
+		time.Sleep(directorPollInterval)
+	}
+}
+
+// GetInfo returns the director's /info.
+func (d *boshDirector) GetInfo() (directorInfo, error) {
+	var info directorInfo
+	err := d.get("/info", &info)
+	return info, err
+}
+
+// GetTaskStatus returns the state of a task.
+func (d *boshDirector) GetTaskStatus(taskID int) (directorTask, error) {
+	var status directorTask
+	err := d.get(fmt.Sprintf("/tasks/%d", taskID), &status)
+	return status, err
+}
+
+// GetTaskOutput returns the output of a task of the given type: "event" for
+// its events, "result" for its result or "debug" for its debug log.  The
+// event and result outputs have one JSON document per line.
+func (d *boshDirector) GetTaskOutput(taskID int, outputType string) ([]byte, error) {
+	resp, err := d.do("GET", fmt.Sprintf("/tasks/%d/output?type=%v", taskID, url.QueryEscape(outputType)), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// FetchVMsStatus returns the VMs of a deployment.  The director lists them
+// in the result of a task, one per line.
+func (d *boshDirector) FetchVMsStatus(deploymentName string) ([]directorVM, error) {
+	taskID, err := d.startTask("GET", fmt.Sprintf("/deployments/%v/vms?format=full", url.PathEscape(deploymentName)), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	err = d.waitForDone(taskID)
+	if err != nil {
+		return nil, err
+	}
+	output, err := d.GetTaskOutput(taskID, "result")
+	if err != nil {
+		return nil, err
+	}
+	var vms []directorVM
+	for _, line := range strings.Split(string(output), "\n") {
+		var vm directorVM
+		if json.Unmarshal([]byte(line), &vm) == nil {
+			vms = append(vms, vm)
+		}
+	}
+	return vms, nil
+}
+
+// DeleteDeployment deletes a deployment, waiting for its task.
+func (d *boshDirector) DeleteDeployment(deploymentName string) error {
+	taskID, err := d.startTask("DELETE", fmt.Sprintf("/deployments/%v?force=true", url.PathEscape(deploymentName)), nil, "")
+	if err != nil {
+		return err
+	}
+	return d.waitForDone(taskID)
+}
+
+// RunErrand asks the director to run an errand of a deployment, and returns
+// the ID of the task running it.  The result output of the task holds the
+// exit code and output of the errand on each instance.
+func (d *boshDirector) RunErrand(deploymentName, errandName string) (int, error) {
+	path := fmt.Sprintf("/deployments/%v/errands/%v/runs", url.PathEscape(deploymentName), url.PathEscape(errandName))
+	return d.startTask("POST", path, strings.NewReader(`{"keep-alive": false}`), "application/json")
+}
+
+// GetReleases returns the releases uploaded to the director.
+func (d *boshDirector) GetReleases() ([]directorRelease, error) {
+	var releases []directorRelease
+	err := d.get("/releases", &releases)
+	return releases, err
+}
+
+// GetStemcells returns the stemcells uploaded to the director.
+func (d *boshDirector) GetStemcells() ([]directorStemcell, error) {
+	var stemcells []directorStemcell
+	err := d.get("/stemcells", &stemcells)
+	return stemcells, err
+}
+
+// GetCloudConfig returns the current cloud-config of the director, all its
+// named cloud configs combined.  Directors older than the /configs API have
+// a single cloud-config, at /cloud_configs.
+func (d *boshDirector) GetCloudConfig() (directorCloudConfig, error) {
+	var cc directorCloudConfig
+	var contents []string
+	var configs []struct {
+		Content string `json:"content"`
+	}
+	err := d.get("/configs?type=cloud&latest=true", &configs)
+	if e, ok := err.(*directorError); ok && e.statusCode == http.StatusNotFound {
+		var legacy []struct {
+			Properties string `json:"properties"`
+		}
+		err = d.get("/cloud_configs?limit=1", &legacy)
+		if err != nil {
+			return cc, err
+		}
+		for _, config := range legacy {
+			contents = append(contents, config.Properties)
+		}
+	} else if err != nil {
+		return cc, err
+	} else {
+		for _, config := range configs {
+			contents = append(contents, config.Content)
+		}
+	}
+
+	for _, content := range contents {
+		var part directorCloudConfig
+		err := yaml.Unmarshal([]byte(content), &part)
+		if err != nil {
+			return cc, fmt.Errorf("invalid cloud-config: %v", err)
+		}
+		cc.AZs = append(cc.AZs, part.AZs...)
+		cc.VMTypes = append(cc.VMTypes, part.VMTypes...)
+		cc.VMExtensions = append(cc.VMExtensions, part.VMExtensions...)
+		cc.DiskTypes = append(cc.DiskTypes, part.DiskTypes...)
+		cc.Networks = append(cc.Networks, part.Networks...)
+	}
+	return cc, nil
+}
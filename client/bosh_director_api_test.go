@@ -0,0 +1,125 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+)
+
+func TestDirectorAPI(t *testing.T) {
+	configs := true
+	var deleted []string
+	c, stop := newFakeDirector(t, &config.BoshConfig{DirectorUser: "admin", DirectorPassword: "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "admin" || password != "admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /info":
+			fmt.Fprint(w, `{"name": "bosh-lite", "uuid": "director-uuid"}`)
+		case "GET /deployments/cb-1/vms":
+			if r.URL.Query().Get("format") != "full" {
+				http.NotFound(w, r)
+				return
+			}
+			http.Redirect(w, r, "/tasks/5", http.StatusFound)
+		case "DELETE /deployments/cb-1":
+			deleted = append(deleted, r.URL.Query().Get("force"))
+			http.Redirect(w, r, "/tasks/6", http.StatusFound)
+		case "DELETE /deployments/cb-2":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code": 70000, "description": "Deployment 'cb-2' doesn't exist"}`)
+		case "GET /tasks/5", "GET /tasks/6":
+			fmt.Fprintf(w, `{"id": %v, "state": "done"}`, strings.TrimPrefix(r.URL.Path, "/tasks/"))
+		case "GET /tasks/5/output":
+			fmt.Fprint(w, `{"job_name": "couchbase4", "index": 0, "job_state": "running", "ips": ["10.244.1.2"], "az": "z1"}
+{"job_name": "couchbase4", "index": 1, "job_state": "running", "ips": ["10.244.1.3"], "az": "z2"}
+`)
+		case "GET /configs":
+			if !configs {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `[{"name": "default", "type": "cloud", "content": "azs: [{name: z1}]\nvm_types: [{name: medium}]"},
+				{"name": "services", "type": "cloud", "content": "networks: [{name: services, subnets: [{az: z1}]}]"}]`)
+		case "GET /cloud_configs":
+			fmt.Fprint(w, `[{"properties": "azs: [{name: z2}]"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer stop()
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := boshclient.GetInfo()
+	if err != nil || info.UUID != "director-uuid" {
+		t.Errorf("GetInfo: %+v %v", info, err)
+	}
+
+	vms, err := boshclient.FetchVMsStatus("cb-1")
+	if err != nil || len(vms) != 2 || vms[1].IPs[0] != "10.244.1.3" || vms[1].AZ != "z2" || vms[1].Index != 1 {
+		t.Errorf("FetchVMsStatus: %+v %v", vms, err)
+	}
+
+	if err = boshclient.DeleteDeployment("cb-1"); err != nil || len(deleted) != 1 || deleted[0] != "true" {
+		t.Errorf("DeleteDeployment: %v %v", deleted, err)
+	}
+	err = boshclient.DeleteDeployment("cb-2")
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found: Deployment 'cb-2' doesn't exist") {
+		t.Errorf("DeleteDeployment of a missing deployment: %v", err)
+	}
+
+	// the named cloud configs are combined
+	cloudConfig, err := boshclient.GetCloudConfig()
+	if err != nil || len(cloudConfig.AZs) != 1 || len(cloudConfig.VMTypes) != 1 || len(cloudConfig.Networks) != 1 || cloudConfig.Networks[0].Subnets[0].AZ != "z1" {
+		t.Errorf("GetCloudConfig: %+v %v", cloudConfig, err)
+	}
+	// older directors have one
+	configs = false
+	cloudConfig, err = boshclient.GetCloudConfig()
+	if err != nil || len(cloudConfig.AZs) != 1 || cloudConfig.AZs[0].Name != "z2" {
+		t.Errorf("GetCloudConfig of an older director: %+v %v", cloudConfig, err)
+	}
+
+	for location, want := range map[string]int{"https://director:25555/tasks/42": 42, "/tasks/7": 7, "/deployments/cb-1": 0, "": 0} {
+		if got, err := taskIDFromURL(location); got != want || (want == 0) != (err != nil) {
+			t.Errorf("taskIDFromURL(%q): got %v %v, want %v", location, got, err, want)
+		}
+	}
+}
+
+// newFakeDirector starts a director answering with handler, and returns a
+// BoshClient for it, keeping its files in a temporary DataDir unless conf
+// has one.  The returned func stops the director and removes the DataDir.
+func newFakeDirector(t *testing.T, conf *config.BoshConfig, handler http.HandlerFunc) (*BoshClient, func()) {
+	director := httptest.NewServer(handler)
+	dir, err := ioutil.TempDir("", "director")
+	if err != nil {
+		director.Close()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		director.Close()
+		os.RemoveAll(dir)
+	}
+	conf.DirectorURL = director.URL
+	if conf.DataDir == "" {
+		conf.DataDir = dir
+	}
+	transport, err := newDirectorTransport(conf)
+	if err != nil {
+		cleanup()
+		t.Fatalf("newDirectorTransport: %v", err)
+	}
+	c := &BoshClient{dProps: conf, transport: transport, scheduler: newDirectorScheduler(0), tasks: make(map[string]int)}
+	return c, cleanup
+}
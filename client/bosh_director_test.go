@@ -0,0 +1,143 @@
+package client
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+)
+
+func TestDirectorAuth(t *testing.T) {
+	tokens := 0
+	uaaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, secret, _ := r.BasicAuth()
+		if r.URL.Path != "/oauth/token" || client != "broker" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tokens++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-" + strconv.Itoa(tokens),
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer uaaServer.Close()
+
+	// the director accepts only the latest token, or the basic auth user
+	var authorizations []string
+	director := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/info" && r.Header.Get("Authorization") == "" {
+			fmt.Fprintf(w, `{"user_authentication": {"type": "uaa", "options": {"url": %q}}}`, uaaServer.URL)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		authorizations = append(authorizations, auth)
+		user, password, _ := r.BasicAuth()
+		if auth != "Bearer token-"+strconv.Itoa(tokens) && (user != "admin" || password != "admin") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"name": "lite", "uuid": "director-uuid"}`)
+	}))
+	defer director.Close()
+
+	conf := &config.BoshConfig{DirectorURL: director.URL, DirectorUser: "admin", DirectorPassword: "admin"}
+	transport, err := newDirectorTransport(conf)
+	if err != nil {
+		t.Fatalf("newDirectorTransport: %v", err)
+	}
+	c := &BoshClient{dProps: conf, transport: transport}
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		t.Fatalf("createBoshClient: %v", err)
+	}
+	info, err := boshclient.GetInfo()
+	if err != nil || info.UUID != "director-uuid" {
+		t.Fatalf("GetInfo with basic auth: %+v %v", info, err)
+	}
+
+	// the UAA is discovered, and a token the director rejects is replaced
+	conf.DirectorClient = "broker"
+	conf.DirectorClientSecret = "s3cret"
+	authorizations = nil
+	info, err = boshclient.GetInfo()
+	if err != nil || info.UUID != "director-uuid" {
+		t.Fatalf("GetInfo with UAA: %+v %v", info, err)
+	}
+	tokens++
+	resp, err := c.directorClient(nil).Post(director.URL+"/deployments", "text/yaml", strings.NewReader("name: cb-1"))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST after the token was revoked: %v %v", resp, err)
+	}
+	want := []string{"Bearer token-1", "Bearer token-1", "Bearer token-3"}
+	if strings.Join(authorizations, ",") != strings.Join(want, ",") {
+		t.Errorf("director saw %v, want %v", authorizations, want)
+	}
+
+	conf.DirectorClientSecret = "wrong"
+	if _, err = boshclient.GetInfo(); err == nil {
+		t.Errorf("expected a bad client secret to fail")
+	}
+}
+
+func TestDirectorTLS(t *testing.T) {
+	director := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "lite", "uuid": "director-uuid"}`)
+	}))
+	defer director.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: director.Certificate().Raw})
+	dir, err := ioutil.TempDir("", "director-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "director.pem")
+	if err = ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		conf config.BoshConfig
+		ok   bool
+	}{
+		{config.BoshConfig{}, false},
+		{config.BoshConfig{DirectorCACert: string(caPEM)}, true},
+		{config.BoshConfig{DirectorCACert: caFile}, true},
+		{config.BoshConfig{DirectorInsecureSkipVerify: true}, true},
+	}
+	for i := range tests {
+		conf := &tests[i].conf
+		conf.DirectorURL = director.URL
+		transport, err := newDirectorTransport(conf)
+		if err != nil {
+			t.Fatalf("newDirectorTransport: %v", err)
+		}
+		c := &BoshClient{dProps: conf, transport: transport}
+		boshclient, _ := c.createBoshClient()
+		info, err := boshclient.GetInfo()
+		if ok := err == nil && info.UUID == "director-uuid"; ok != tests[i].ok {
+			t.Errorf("ca %.20q, insecure %v: got %v (%v), want %v", conf.DirectorCACert, conf.DirectorInsecureSkipVerify, ok, err, tests[i].ok)
+		}
+	}
+
+	invalid := []config.BoshConfig{
+		{DirectorCACert: "-----BEGIN CERTIFICATE-----\nnot a certificate\n-----END CERTIFICATE-----\n"},
+		{DirectorCACert: filepath.Join(dir, "missing.pem")},
+		{DirectorCACert: string(caPEM), DirectorInsecureSkipVerify: true},
+	}
+	for i := range invalid {
+		if _, err = newDirectorTransport(&invalid[i]); err == nil {
+			t.Errorf("expected director_ca_cert %.30q to be rejected", invalid[i].DirectorCACert)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	"github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// Plans can declare errands, e.g. a smoke test or a cluster-init errand of
+// the Couchbase release.  Once the deploy task of a new instance is done,
+// the broker runs them one after the other, each as a director task, while
+// the instance stays in progress and holds its director slot.  Provisioning
+// fails if an errand task fails or the errand exits non-zero on an instance;
+// the output of each errand is kept in DataDir.
+
+// validErrandName matches the names of BOSH jobs.
+var validErrandName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validateErrands checks the errands of a plan.
+func (props cbDefaultSettings) validateErrands() error {
+	initializers := 0
+	for _, errand := range props.errands {
+		if !validErrandName.MatchString(errand.Name) {
+			return fmt.Errorf("errand name %q must be letters, digits, '.', '_' or '-'", errand.Name)
+		}
+		if errand.InitializesCluster {
+			initializers++
+		}
+	}
+	if initializers > 1 {
+		return errors.New("only one errand can initialize the cluster")
+	}
+	return nil
+}
+
+// clusterInitErrand reports whether an errand of the plan sets up the
+// cluster, instead of the broker.
+func (props cbDefaultSettings) clusterInitErrand() bool {
+	for _, errand := range props.errands {
+		if errand.InitializesCluster {
+			return true
+		}
+	}
+	return false
+}
+
+// takeOverCluster gives the cluster a cluster-init errand has set up the
+// admin credentials userID/passwd instead of those of the manifest, which
+// every instance of the plan shares, and returns clients of its nodes (at
+// nodeURLs) that use them.
+func takeOverCluster(nodeURLs []string, cbProps cbDefaultSettings, userID, passwd string) ([]*admin.Client, error) {
+	root := admin.NewClient(nodeURLs[0], cbProps.adminUser, cbProps.adminPass)
+	err := root.SetWebCredentials(userID, passwd, cbProps.port)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*admin.Client, len(nodeURLs))
+	for i, nodeURL := range nodeURLs {
+		nodes[i] = admin.NewClient(nodeURL, cbProps.adminUser, cbProps.adminPass).WithCredentials(userID, passwd)
+	}
+	return nodes, nil
+}
+
+// An errandResult is a line of the result output of an errand task: how the
+// errand went on one instance.
+type errandResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// An errandState is the errand phase of a new deployment: the errand running
+// (or about to), or the one that failed and why.
+type errandState struct {
+	name    string
+	taskID  int
+	failure string
+}
+
+// setErrandState records the errand phase of deploymentName; nil ends it.
+func (c *BoshClient) setErrandState(deploymentName string, state *errandState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if state == nil {
+		delete(c.errands, deploymentName)
+		return
+	}
+	if c.errands == nil {
+		c.errands = make(map[string]*errandState)
+	}
+	c.errands[deploymentName] = state
+}
+
+// errandState returns a copy of the errand phase of deploymentName, or nil.
+func (c *BoshClient) errandState(deploymentName string) *errandState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state, ok := c.errands[deploymentName]
+	if !ok {
+		return nil
+	}
+	s := *state
+	return &s
+}
+
+// runErrands runs the errands of the new deployment deploymentName, in
+// order, stopping at the first that fails.
+func (c *BoshClient) runErrands(deploymentName string, errands []model.ErrandSettings) error {
+	for _, errand := range errands {
+		c.setErrandState(deploymentName, &errandState{name: errand.Name})
+		err := c.runErrand(deploymentName, errand.Name)
+		if err != nil {
+			utils.Logger.Printf("client.bosh.runErrands: %v: %v\n", deploymentName, err)
+			c.setErrandState(deploymentName, &errandState{name: errand.Name, failure: err.Error()})
+			return err
+		}
+	}
+	c.setErrandState(deploymentName, nil)
+	return nil
+}
+
+// runErrand runs one errand and waits for it, returning an error explaining
+// how it failed.
+func (c *BoshClient) runErrand(deploymentName, errandName string) error {
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		return err
+	}
+	taskID, err := boshclient.RunErrand(deploymentName, errandName)
+	if err != nil {
+		return fmt.Errorf("errand %v could not be started: %v", errandName, err)
+	}
+	utils.Logger.Printf("client.bosh.runErrand: %v: errand %v runs as task %d\n", deploymentName, errandName, taskID)
+	c.setErrandState(deploymentName, &errandState{name: errandName, taskID: taskID})
+
+	err = c.waitForTask(deploymentName, taskID)
+	if err != nil {
+		return fmt.Errorf("errand %v: %v", errandName, err)
+	}
+	output, err := boshclient.GetTaskOutput(taskID, "result")
+	if err != nil {
+		return fmt.Errorf("errand %v: could not fetch its result: %v", errandName, err)
+	}
+	results := parseErrandResults(output)
+	c.storeErrandOutput(deploymentName, errandName, taskID, results)
+	return explainErrandResults(errandName, results)
+}
+
+// parseErrandResults returns the results in the result output of an errand
+// task, one per instance the errand ran on.
+func parseErrandResults(output []byte) []errandResult {
+	var results []errandResult
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		var result errandResult
+		if json.Unmarshal(line, &result) == nil {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// explainErrandResults returns an error holding the end of the output of the
+// first instance the errand failed on, or nil if it exited 0 everywhere.
+func explainErrandResults(errandName string, results []errandResult) error {
+	if len(results) == 0 {
+		return fmt.Errorf("errand %v: the director gave no result", errandName)
+	}
+	for _, result := range results {
+		if result.ExitCode == 0 {
+			continue
+		}
+		output := strings.TrimSpace(result.Stderr)
+		if output == "" {
+			output = strings.TrimSpace(result.Stdout)
+		}
+		if output == "" {
+			return fmt.Errorf("errand %v exited %d", errandName, result.ExitCode)
+		}
+		output = strings.Join(strings.Fields(output), " ")
+		if len(output) > maxFailureMessage {
+			output = "..." + output[len(output)-maxFailureMessage:]
+		}
+		return fmt.Errorf("errand %v exited %d: %v", errandName, result.ExitCode, output)
+	}
+	return nil
+}
+
+// storeErrandOutput keeps the output of an errand in DataDir for operators.
+func (c *BoshClient) storeErrandOutput(deploymentName, errandName string, taskID int, results []errandResult) {
+	var output bytes.Buffer
+	for i, result := range results {
+		fmt.Fprintf(&output, "=== instance %d: exit code %d\n--- stdout\n%v\n--- stderr\n%v\n", i, result.ExitCode, result.Stdout, result.Stderr)
+	}
+	fileName := c.dProps.DataDir + string(os.PathSeparator) + fmt.Sprintf("%v-errand-%v-%d.log", deploymentName, errandName, taskID)
+	utils.MkDir(c.dProps.DataDir)
+	err := utils.WriteFile(fileName, output.Bytes())
+	if err != nil {
+		utils.Logger.Printf("client.bosh.storeErrandOutput: could not store the output of errand %v of %v: %v\n", errandName, deploymentName, err)
+		return
+	}
+	utils.Logger.Printf("client.bosh.storeErrandOutput: the output of errand %v of %v is in %v\n", errandName, deploymentName, fileName)
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestErrands(t *testing.T) {
+	var runs []string
+	c, stop := newFakeDirector(t, &config.BoshConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/deployments/cb-1/errands/smoke-test/runs":
+			runs = append(runs, "smoke-test")
+			http.Redirect(w, r, "/tasks/43", http.StatusFound)
+		case "/deployments/cb-1/errands/cluster-init/runs":
+			runs = append(runs, "cluster-init")
+			http.Redirect(w, r, "/tasks/44", http.StatusFound)
+		case "/tasks/42", "/tasks/43", "/tasks/44":
+			fmt.Fprintf(w, `{"id": %v, "state": "done"}`, strings.TrimPrefix(r.URL.Path, "/tasks/"))
+		case "/tasks/43/output":
+			fmt.Fprint(w, `{"exit_code":0,"stdout":"ok\n","stderr":""}`+"\n")
+		case "/tasks/44/output":
+			fmt.Fprint(w, `{"exit_code":0,"stdout":"","stderr":""}`+"\n"+`{"exit_code":2,"stdout":"joining\n","stderr":"ERROR: node 10.244.1.3 unreachable\n"}`+"\n")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer stop()
+	c.tasks["cb-1"] = 42
+	errands := []model.ErrandSettings{{Name: "smoke-test"}, {Name: "cluster-init", InitializesCluster: true}, {Name: "never-run"}}
+	c.setErrandState("cb-1", &errandState{name: errands[0].Name})
+	if state, _ := c.GetInstanceState("cb-1"); state != "pending" {
+		t.Errorf("state before the errands: got %v, want pending", state)
+	}
+
+	released := false
+	c.finishDeploy("cb-1", 42, errands, func() { released = true })
+	if !released {
+		t.Errorf("the director slot was not released")
+	}
+	if strings.Join(runs, ",") != "smoke-test,cluster-init" {
+		t.Errorf("errands run: %v", runs)
+	}
+	if state, _ := c.GetInstanceState("cb-1"); state != "failed" {
+		t.Errorf("state: got %v, want failed", state)
+	}
+	want := "errand cluster-init exited 2: ERROR: node 10.244.1.3 unreachable"
+	if got := c.GetInstanceDescription("cb-1"); got != want {
+		t.Errorf("GetInstanceDescription: got %q, want %q", got, want)
+	}
+	stored, err := ioutil.ReadFile(filepath.Join(c.dProps.DataDir, "cb-1-errand-cluster-init-44.log"))
+	if err != nil || !strings.Contains(string(stored), "=== instance 1: exit code 2") {
+		t.Errorf("stored output: %q %v", stored, err)
+	}
+
+	// errands that pass end the phase
+	runs = nil
+	c.finishDeploy("cb-1", 42, errands[:1], func() {})
+	if state, _ := c.GetInstanceState("cb-1"); state != "succeeded" {
+		t.Errorf("state after passing errands: got %v, want succeeded", state)
+	}
+}
+
+func TestErrandCredentials(t *testing.T) {
+	c, stop := newFakeDirector(t, &config.BoshConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/42":
+			fmt.Fprint(w, `{"id": 42, "state": "done"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer stop()
+	c.tasks["cb-1"] = 42
+	plan := &model.ServicePlan{Name: "errands", ID: "1"}
+
+	// a slow errand keeps the deploy waiting
+	c.setErrandState("cb-1", &errandState{name: "smoke-test", taskID: 43})
+	creds, err := c.GetCredentials("cb-1", plan, nil, nil)
+	if creds != nil || err == nil || IsFatal(err) || !strings.Contains(err.Error(), "smoke-test") {
+		t.Errorf("GetCredentials while an errand runs: %v %v", creds, err)
+	}
+
+	// a failing errand ends it
+	c.setErrandState("cb-1", &errandState{name: "smoke-test", taskID: 43, failure: "errand smoke-test exited 1"})
+	creds, err = c.GetCredentials("cb-1", plan, nil, nil)
+	if creds != nil || !IsFatal(err) || err.Error() != "errand smoke-test exited 1" {
+		t.Errorf("GetCredentials after a failed errand: %v %v", creds, err)
+	}
+}
+
+func TestTakeOverCluster(t *testing.T) {
+	fakes := []*admintest.Server{admintest.NewServer(), admintest.NewServer()}
+	for _, fake := range fakes {
+		defer fake.Close()
+	}
+	cbProps := cbDefaultProps()
+	nodes, err := takeOverCluster([]string{fakes[0].URL, fakes[1].URL}, cbProps, "user1", "password1")
+	if err != nil {
+		t.Fatalf("takeOverCluster: %v", err)
+	}
+	if fakes[0].AdminUser != "user1" || fakes[0].AdminPassword != "password1" {
+		t.Errorf("the admin credentials of the manifest were kept: %v/%v", fakes[0].AdminUser, fakes[0].AdminPassword)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("nodes: %v", nodes)
+	}
+	// the cluster shares the credentials of its root node
+	fakes[1].AdminUser, fakes[1].AdminPassword = "user1", "password1"
+	for i, node := range nodes {
+		if _, err = node.GetPool(); admin.IsUnauthorized(err) {
+			t.Errorf("node %d does not use the new credentials: %v", i, err)
+		}
+	}
+
+	// the manifest credentials no longer work, so a retry fails
+	if _, err = takeOverCluster([]string{fakes[0].URL}, cbProps, "user2", "password2"); err == nil {
+		t.Errorf("takeOverCluster with the old credentials succeeded")
+	}
+}
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	utils "github.com/ssdowd/couchbasebroker/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The networks of v1 manifests come from the templates, so every deployment
+// of the broker declares the same static ranges.  The broker hands out their
+// IPs: each job gets static_ips no other deployment holds, recorded in
+// DataDir/static-ips.json, and a deployment keeps them until it is deleted.
+// v2 manifests leave the IPs to the director and its cloud-config.
+
+const staticIPsFile = "static-ips.json"
+
+// maxStaticIPs bounds the size of the static ranges of a network.
+const maxStaticIPs = 65536
+
+// ipAllocations are the static IPs allocated on each network: network name
+// -> deployment/job -> IPs, in the order of the job's instances.
+type ipAllocations map[string]map[string][]string
+
+// loadIPAllocations reads the allocations; there are none before the first.
+func (c *BoshClient) loadIPAllocations() (ipAllocations, error) {
+	allocations := ipAllocations{}
+	if !utils.Exists(c.dProps.DataDir + string(os.PathSeparator) + staticIPsFile) {
+		return allocations, nil
+	}
+	err := utils.ReadAndUnmarshal(&allocations, c.dProps.DataDir, staticIPsFile)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", staticIPsFile, err)
+	}
+	return allocations, nil
+}
+
+// allocateStaticIPs returns manifestYAML with static_ips for the jobs of
+// deploymentName on networks with static ranges, and records them.  A job
+// keeps the IPs it has, minus those of the instances it no longer has.  It
+// fails, allocating nothing, if a network has too few free IPs.
+func (c *BoshClient) allocateStaticIPs(deploymentName string, manifestYAML []byte) ([]byte, error) {
+	var deployment map[interface{}]interface{}
+	err := yaml.Unmarshal(manifestYAML, &deployment)
+	if err != nil {
+		return nil, err
+	}
+	pools := make(map[string][]string)
+	networks, _ := deployment["networks"].([]interface{})
+	for _, n := range networks {
+		network, _ := n.(map[interface{}]interface{})
+		name := fmt.Sprint(network["name"])
+		pools[name], err = staticIPs(network)
+		if err != nil {
+			return nil, fmt.Errorf("network %v: %v", name, err)
+		}
+	}
+
+	// the jobs that get static IPs
+	type staticJob struct {
+		key         string
+		networkName string
+		network     map[interface{}]interface{}
+		instances   int
+	}
+	var staticJobs []staticJob
+	wanted := make(map[string]bool)
+	jobs, _ := deployment["jobs"].([]interface{})
+	for _, j := range jobs {
+		job, _ := j.(map[interface{}]interface{})
+		jobNetworks, _ := job["networks"].([]interface{})
+		if len(jobNetworks) == 0 {
+			continue
+		}
+		jobNetwork, _ := jobNetworks[0].(map[interface{}]interface{})
+		networkName := fmt.Sprint(jobNetwork["name"])
+		if len(pools[networkName]) == 0 || jobNetwork["static_ips"] != nil {
+			// a dynamic network, or the templates chose the IPs
+			continue
+		}
+		instances, _ := job["instances"].(int)
+		key := deploymentName + "/" + fmt.Sprint(job["name"])
+		staticJobs = append(staticJobs, staticJob{key, networkName, jobNetwork, instances})
+		wanted[networkName+" "+key] = true
+	}
+
+	c.ipMutex.Lock()
+	defer c.ipMutex.Unlock()
+	allocations, err := c.loadIPAllocations()
+	if err != nil {
+		return nil, err
+	}
+	// jobs (or networks) the deployment no longer has
+	releaseDeployment(allocations, deploymentName, func(network, key string) bool {
+		return !wanted[network+" "+key]
+	})
+	held := func(network, key string) map[string]bool {
+		ips := make(map[string]bool)
+		for k, list := range allocations[network] {
+			if k != key {
+				for _, ip := range list {
+					ips[ip] = true
+				}
+			}
+		}
+		return ips
+	}
+
+	var problems []string
+	for _, job := range staticJobs {
+		networkName, key, instances := job.networkName, job.key, job.instances
+		pool := pools[networkName]
+		taken := held(networkName, key)
+		inPool := make(map[string]bool, len(pool))
+		for _, ip := range pool {
+			inPool[ip] = true
+		}
+		var ips []string
+		for _, ip := range allocations[networkName][key] {
+			if len(ips) < instances && inPool[ip] && !taken[ip] {
+				ips = append(ips, ip)
+			}
+		}
+		for _, ip := range pool {
+			if len(ips) == instances {
+				break
+			}
+			if !taken[ip] && !contains(ips, ip) {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) < instances {
+			problems = append(problems, fmt.Sprintf("job %v needs %d static IPs on network %v, but only %d of its %d are free",
+				strings.TrimPrefix(key, deploymentName+"/"), instances, networkName, len(ips), len(pool)))
+			continue
+		}
+
+		if allocations[networkName] == nil {
+			allocations[networkName] = make(map[string][]string)
+		}
+		allocations[networkName][key] = ips
+		if len(ips) == 0 {
+			delete(allocations[networkName], key)
+		}
+		static := make([]interface{}, len(ips))
+		for i, ip := range ips {
+			static[i] = ip
+		}
+		job.network["static_ips"] = static
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("no static IPs left for %v: %v; delete service instances or add static IPs to the network templates",
+			deploymentName, strings.Join(problems, "; "))
+	}
+
+	err = utils.MarshalAndRecord(allocations, c.dProps.DataDir, staticIPsFile)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(deployment)
+}
+
+// releaseStaticIPs frees the static IPs of deploymentName.
+func (c *BoshClient) releaseStaticIPs(deploymentName string) error {
+	c.ipMutex.Lock()
+	defer c.ipMutex.Unlock()
+	allocations, err := c.loadIPAllocations()
+	if err != nil {
+		return err
+	}
+	if !releaseDeployment(allocations, deploymentName, func(string, string) bool { return true }) {
+		return nil
+	}
+	utils.Logger.Printf("client.bosh.releaseStaticIPs: released the static IPs of %v\n", deploymentName)
+	return utils.MarshalAndRecord(allocations, c.dProps.DataDir, staticIPsFile)
+}
+
+// releaseDeployment removes the allocations of the jobs of deploymentName
+// that release says to, returning whether there were any.
+func releaseDeployment(allocations ipAllocations, deploymentName string, release func(network, key string) bool) bool {
+	released := false
+	for network, jobs := range allocations {
+		for key := range jobs {
+			if strings.HasPrefix(key, deploymentName+"/") && release(network, key) {
+				delete(jobs, key)
+				released = true
+			}
+		}
+		if len(jobs) == 0 {
+			delete(allocations, network)
+		}
+	}
+	return released
+}
+
+// staticIPs returns the IPs of the static ranges of the subnets of a manifest
+// network, in order.  A range is an IP or "first - last".
+func staticIPs(network map[interface{}]interface{}) ([]string, error) {
+	var ips []string
+	subnets, _ := network["subnets"].([]interface{})
+	for _, s := range subnets {
+		subnet, _ := s.(map[interface{}]interface{})
+		static, _ := subnet["static"].([]interface{})
+		for _, r := range static {
+			first, last, err := parseIPRange(fmt.Sprint(r))
+			if err != nil {
+				return nil, err
+			}
+			if last-first >= maxStaticIPs || len(ips)+int(last-first) >= maxStaticIPs {
+				return nil, fmt.Errorf("more than %d static IPs", maxStaticIPs)
+			}
+			for ip := first; ; ip++ {
+				ips = append(ips, uint32ToIP(ip))
+				if ip == last {
+					break
+				}
+			}
+		}
+	}
+	return ips, nil
+}
+
+// parseIPRange returns the first and last IPv4 addresses of a static range.
+func parseIPRange(r string) (uint32, uint32, error) {
+	parts := strings.SplitN(r, "-", 2)
+	first, err := parseIPv4(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	last := first
+	if len(parts) == 2 {
+		last, err = parseIPv4(parts[1])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if last < first {
+		return 0, 0, fmt.Errorf("invalid static range %q", r)
+	}
+	return first, last, nil
+}
+
+func parseIPv4(s string) (uint32, error) {
+	ip := net.ParseIP(strings.TrimSpace(s)).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("invalid static IP %q (only IPv4 is supported)", strings.TrimSpace(s))
+	}
+	return binary.BigEndian.Uint32(ip), nil
+}
+
+func uint32ToIP(n uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip.String()
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestStaticIPs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static-ips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &BoshClient{dProps: &config.BoshConfig{DataDir: dir}}
+	deployment := func(instances int) []byte {
+		return []byte(fmt.Sprintf(`networks:
+- name: services
+  subnets:
+  - range: 10.244.1.0/30
+    static: [10.244.1.2]
+  - range: 10.244.1.4/29
+    static: [10.244.1.6 - 10.244.1.8]
+- name: dynamic
+  type: dynamic
+jobs:
+- name: couchbase4
+  instances: %d
+  networks:
+  - name: services
+- name: other
+  instances: 1
+  networks:
+  - name: dynamic
+`, instances))
+	}
+	allocate := func(deploymentName string, instances int) ([]string, error) {
+		manifestYAML, err := c.allocateStaticIPs(deploymentName, deployment(instances))
+		if err != nil {
+			return nil, err
+		}
+		var manifest struct {
+			Jobs []struct {
+				Networks []struct {
+					StaticIPs []string `yaml:"static_ips"`
+				}
+			}
+		}
+		if err = yaml.Unmarshal(manifestYAML, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if manifest.Jobs[1].Networks[0].StaticIPs != nil {
+			t.Errorf("a job on a dynamic network got static IPs")
+		}
+		return manifest.Jobs[0].Networks[0].StaticIPs, nil
+	}
+	check := func(deploymentName string, instances int, want ...string) {
+		got, err := allocate(deploymentName, instances)
+		if err != nil || strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%v with %d instances: got %v %v, want %v", deploymentName, instances, got, err, want)
+		}
+	}
+
+	check("cb-1", 2, "10.244.1.2", "10.244.1.6")
+	check("cb-2", 1, "10.244.1.7")
+	// redeploys keep the IPs, scaling in drops those of the last instances
+	check("cb-1", 2, "10.244.1.2", "10.244.1.6")
+	check("cb-1", 1, "10.244.1.2")
+	check("cb-3", 2, "10.244.1.6", "10.244.1.8")
+	_, err = allocate("cb-4", 1)
+	if err == nil || !strings.Contains(err.Error(), "only 0 of its 4 are free") {
+		t.Errorf("expected the pool to be exhausted, got %v", err)
+	}
+	// nothing is allocated when the pool is short
+	_, err = allocate("cb-1", 3)
+	if err == nil {
+		t.Errorf("expected cb-1 not to get 3 IPs")
+	}
+	check("cb-1", 1, "10.244.1.2")
+
+	if err = c.releaseStaticIPs("cb-3"); err != nil {
+		t.Fatalf("releaseStaticIPs: %v", err)
+	}
+	check("cb-4", 1, "10.244.1.6")
+	// the allocations survive a restart
+	c = &BoshClient{dProps: c.dProps}
+	check("cb-5", 1, "10.244.1.8")
+}
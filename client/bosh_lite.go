@@ -1,26 +1,27 @@
 package client
 
 import (
-	"crypto/tls"
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	gonet "net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
-	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	// uuid "code.google.com/p/go-uuid/uuid"
 	uuid "github.com/pborman/uuid"
 	config "github.com/ssdowd/couchbasebroker/config"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
 	model "github.com/ssdowd/couchbasebroker/model"
 	utils "github.com/ssdowd/couchbasebroker/utils"
-
-	"github.com/ssdowd/gogobosh"
-	"github.com/ssdowd/gogobosh/api"
-	"github.com/ssdowd/gogobosh/net"
 )
 
 // A BoshClient manages aconnection to a BOSH director.
@@ -28,23 +29,30 @@ type BoshClient struct {
 	dProps     *config.BoshConfig
 	cbDefaults cbDefaultSettings
 	catalog    *model.Catalog
-	tasks      map[string]int
-}
-
-// spruce merge --prune Xname --prune couchbase base-cb-deploy.yml
-//   network-bosh-lite.yml resources-bosh-lite.yml  couchbase-job-defaults.yml  stub.yml
-//   <(echo "director_uuid: `bosh status --uuid`")
-//   <(echo "couchbase:\n instances: 3") some-other-job-defaults.yml
-var yamlList = []string{
-	"base-cb-deploy.yml",
-	"network-bosh-lite.yml",
-	"resources-bosh-lite.yml",
-	"couchbase-job-defaults.yml",
-	"stub.yml",
+	// transport authenticates all requests to the director
+	transport *directorTransport
+	// scheduler limits the deploys and deletes running on the director
+	scheduler *directorScheduler
+
+	// mutex guards tasks, the last director task of each deployment;
+	// failures, the explanations of the failed tasks, by task ID; unsent,
+	// the new deployments whose deploy waits for a slot; deployErrors,
+	// why queued deploys could not be sent to the director; and errands,
+	// the errand phase of new deployments (see bosh_errands.go).
+	mutex        sync.Mutex
+	tasks        map[string]int
+	failures     map[int]string
+	unsent       map[string]bool
+	deployErrors map[string]string
+	errands      map[string]*errandState
+
+	// ipMutex guards the static IP allocations (see bosh_ips.go)
+	ipMutex sync.Mutex
 }
 
-// NewBoshClient creates and returns a BoshClient for use in working with a BOSH director.
-func NewBoshClient(configFile string) *BoshClient {
+// NewBoshClient creates and returns a BoshClient for use in working with a
+// BOSH director.  It fails if the TLS settings of the director are invalid.
+func NewBoshClient(configFile string) (*BoshClient, error) {
 	// utils.Logger.Printf("NewBoshClient %v\n", configFile)
 	_, err := config.LoadBoshConfig(configFile)
 	if err != nil {
@@ -52,20 +60,77 @@ func NewBoshClient(configFile string) *BoshClient {
 	}
 
 	defaultProps := config.GetBoshConfig()
+	transport, err := newDirectorTransport(defaultProps)
+	if err != nil {
+		return nil, err
+	}
 	return &BoshClient{
-		dProps: defaultProps,
-		tasks:  make(map[string]int),
+		dProps:    defaultProps,
+		tasks:     make(map[string]int),
+		transport: transport,
+		scheduler: newDirectorScheduler(defaultProps.MaxDirectorTasks),
+	}, nil
+}
+
+// task returns the last director task of deploymentName, or 0.
+func (c *BoshClient) task(deploymentName string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.tasks[deploymentName]
+}
+
+func (c *BoshClient) setTask(deploymentName string, taskID int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tasks[deploymentName] = taskID
+}
+
+// setUnsent records whether the director has yet to get deploymentName.
+func (c *BoshClient) setUnsent(deploymentName string, unsent bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.unsent == nil {
+		c.unsent = make(map[string]bool)
+	}
+	if unsent {
+		c.unsent[deploymentName] = true
+	} else {
+		delete(c.unsent, deploymentName)
+	}
+}
+
+// deployError returns why the queued deploy of deploymentName could not be
+// sent to the director, or "".
+func (c *BoshClient) deployError(deploymentName string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.deployErrors[deploymentName]
+}
+
+func (c *BoshClient) setDeployError(deploymentName string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.deployErrors == nil {
+		c.deployErrors = make(map[string]string)
 	}
+	c.deployErrors[deploymentName] = err.Error()
 }
 
 // GetInstanceState returns a string indicating the state of the instance.
 // state == pending, running, succeeded, failed
 func (c *BoshClient) GetInstanceState(instanceID string) (string, error) {
 	// utils.Logger.Printf("client.bosh.GetInstanceState: catalog: %v\n", *c.catalog)
-	utils.Logger.Printf("client.bosh.GetInstanceState: instanceID: %v: task ID: %v\n", instanceID, c.tasks[instanceID])
+	taskID := c.task(instanceID)
+	utils.Logger.Printf("client.bosh.GetInstanceState: instanceID: %v: task ID: %v\n", instanceID, taskID)
 
+	if c.scheduler.position(instanceID) > 0 {
+		return "pending", nil
+	}
+	if c.deployError(instanceID) != "" {
+		return "failed", nil
+	}
 	// we don't have a task for it, assume it is good...
-	if c.tasks[instanceID] == 0 {
+	if taskID == 0 {
 		return "succeeded", nil
 	}
 
@@ -74,27 +139,63 @@ func (c *BoshClient) GetInstanceState(instanceID string) (string, error) {
 		utils.Logger.Printf("client.bosh.GetInstanceState: error creating bosh client: %v\n", err)
 		return "failed", err
 	}
-	taskStatus, apiResponse := boshclient.GetTaskStatus(c.tasks[instanceID])
-	if apiResponse.IsNotSuccessful() {
-		utils.Logger.Printf("client.bosh.GetInstanceState... gogo.GetTaskStatus error: %v\n", apiResponse)
+	taskStatus, err := boshclient.GetTaskStatus(taskID)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.GetInstanceState... GetTaskStatus error: %v\n", err)
 	}
 	utils.Logger.Printf("client.bosh.GetInstanceState... taskStatus: %v\n", taskStatus)
 
-	// map from gogobosh TaskStatus.State to the CF API states
+	// map from the director task state to the CF API states
 	switch taskStatus.State {
 	case "done":
+		if errand := c.errandState(instanceID); errand != nil {
+			if errand.failure != "" {
+				return "failed", nil
+			}
+			return "pending", nil
+		}
 		return "succeeded", nil
 	case "processing":
 		return "running", nil
 	case "queued":
 		return "pending", nil
-	case "error":
+	case "error", "failed", "cancelled", "timeout":
+		c.taskFailure(instanceID, taskStatus)
 		return "failed", nil
 	default:
 		return "failed", fmt.Errorf("Unknown bosh status: %v", taskStatus.State)
 	}
 }
 
+// GetInstanceDescription explains the state GetInstanceState returns: the
+// place of the instance's deploy in the director queue, the errand running,
+// or why it failed.
+func (c *BoshClient) GetInstanceDescription(instanceID string) string {
+	if position := c.scheduler.position(instanceID); position > 0 {
+		return fmt.Sprintf("queued (position %d)", position)
+	}
+	if deployError := c.deployError(instanceID); deployError != "" {
+		return deployError
+	}
+	taskID := c.task(instanceID)
+	c.mutex.Lock()
+	failure := c.failures[taskID]
+	c.mutex.Unlock()
+	if failure != "" {
+		return failure
+	}
+	if errand := c.errandState(instanceID); errand != nil {
+		if errand.failure != "" {
+			return errand.failure
+		}
+		if errand.taskID != 0 {
+			return fmt.Sprintf("running errand %v (BOSH task %d)", errand.name, errand.taskID)
+		}
+		return fmt.Sprintf("errand %v waits for the deployment", errand.name)
+	}
+	return ""
+}
+
 // IsValidPlan checks the given planName to ensure it appears in the catalog.
 func (c *BoshClient) IsValidPlan(planName string) bool {
 	if c.catalog == nil {
@@ -102,21 +203,19 @@ func (c *BoshClient) IsValidPlan(planName string) bool {
 		return false
 	}
 
-	// TODO: loop through services in the catalog, and look for a matching plan name
-	for _, s := range c.catalog.Services {
-		for _, p := range s.Plans {
-			if p.ID == planName {
-				return true
-			}
-		}
-	}
-	return false
+	return c.catalog.FindPlan(planName) != nil
 }
 
 // CreateInstance is the qquivalent of: bosh run -d --name=cb-test couchbase.
-func (c *BoshClient) CreateInstance(parameters interface{}) (string, error) {
+// The plan determines the number of VMs and their resource pool.  The
+// manifest is built at once, but the deploy waits for a director slot,
+// queued for orgID.
+func (c *BoshClient) CreateInstance(plan *model.ServicePlan, parameters interface{}, orgID string) (string, error) {
 	utils.Logger.Printf("client.bosh.CreateInstance parms: %v\n", parameters)
-	// for now we ignore any parameters...
+	cbProps, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		return "", err
+	}
 
 	// get a bosh client
 	boshclient, err := c.createBoshClient()
@@ -124,9 +223,9 @@ func (c *BoshClient) CreateInstance(parameters interface{}) (string, error) {
 		utils.Logger.Printf("client.bosh.CreateInstance: error creating Bosh client: %v\n", err)
 		return "", err
 	}
-	info, apiResponse := boshclient.GetInfo()
-	if apiResponse.IsNotSuccessful() {
-		utils.Logger.Printf("client.bosh.CreateInstance: Could not fetch BOSH info %v\n", apiResponse)
+	info, err := boshclient.GetInfo()
+	if err != nil {
+		utils.Logger.Printf("client.bosh.CreateInstance: Could not fetch BOSH info %v\n", err)
 		return "", errors.New("BOSH error")
 	}
 
@@ -136,121 +235,489 @@ func (c *BoshClient) CreateInstance(parameters interface{}) (string, error) {
 	utils.Logger.Printf("client.bosh.CreateInstance...BOSH Deployment name: %v\n", deploymentName)
 	utils.Logger.Printf("client.bosh.CreateInstance...BOSH Director UUID: %v\n", info.UUID)
 
-	// did they put an instance count in the params? - it appears to be a float...
-	instances := 1
-	switch parameters.(type) {
-	case map[string]interface{}:
-		param := parameters.(map[string]interface{})
-		if param["instances"] != nil {
-			instances = int(param["instances"].(float64))
+	manifestYAML, err := c.prepareManifest(deploymentName, info.UUID, cbProps, cbProps.instances)
+	if err != nil {
+		c.releaseStaticIPs(deploymentName)
+		return "", err
+	}
+	if len(cbProps.errands) > 0 {
+		// the instance is in progress until its errands are done
+		c.setErrandState(deploymentName, &errandState{name: cbProps.errands[0].Name})
+	}
+
+	slot := c.scheduler.queue(deploymentName, orgID)
+	select {
+	case release := <-slot:
+		taskID, err := c.postManifest(deploymentName, manifestYAML)
+		if err != nil {
+			c.abandonDeploy(deploymentName, release)
+			return "", err
 		}
+		c.setTask(deploymentName, taskID)
+		utils.Logger.Printf("client.bosh.CreateInstance: %v deploys as task %d\n", deploymentName, taskID)
+		go c.finishDeploy(deploymentName, taskID, cbProps.errands, release)
 	default:
-		utils.Logger.Printf("client.bosh.CreateInstance... unmatched parameter type\n")
+		utils.Logger.Printf("client.bosh.CreateInstance: %v is queued (position %d)\n", deploymentName, c.scheduler.position(deploymentName))
+		c.setUnsent(deploymentName, true)
+		go c.deployQueued(deploymentName, manifestYAML, cbProps.errands, slot)
 	}
+	// return the container ID for tracking
+	// the monitoring will be done by GetCredentials, called by the controller
+	return deploymentName, nil
+}
 
-	// Create a deployment.yml file by invoking spruce...
-	args := []string{"merge"}
-	templateDir := c.dProps.TemplateDir
-	if !strings.HasPrefix(templateDir, string(os.PathSeparator)) {
-		templateDir = utils.GetPath([]string{templateDir})
+// deployQueued posts the manifest of a new deployment once slot gives it a
+// director slot, then finishes the deploy.  If the manifest cannot be
+// posted, the error is kept for GetInstanceState.
+func (c *BoshClient) deployQueued(deploymentName string, manifestYAML []byte, errands []model.ErrandSettings, slot <-chan func()) {
+	release := <-slot
+	if release == nil {
+		// deleted while queued
+		return
+	}
+	c.setUnsent(deploymentName, false)
+	taskID, err := c.postManifest(deploymentName, manifestYAML)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.deployQueued: %v: %v\n", deploymentName, err)
+		c.setDeployError(deploymentName, err)
+		c.abandonDeploy(deploymentName, release)
+		return
 	}
+	c.setTask(deploymentName, taskID)
+	c.finishDeploy(deploymentName, taskID, errands, release)
+}
 
-	for _, val := range yamlList {
-		args = append(args, templateDir+string(os.PathSeparator)+val)
-	}
-	// write variable portion to a tempfile (name, director UUID, instance count)
-	f, err := ioutil.TempFile("", "bosh-deploy-tmp-")
+// abandonDeploy frees what a new deployment holds when its manifest could
+// not be posted: its director slot, its static IPs and its errands.
+func (c *BoshClient) abandonDeploy(deploymentName string, release func()) {
+	release()
+	err := c.releaseStaticIPs(deploymentName)
 	if err != nil {
-		panic(err)
+		utils.Logger.Printf("client.bosh.abandonDeploy: %v: could not release the static IPs: %v\n", deploymentName, err)
 	}
-	f.WriteString(fmt.Sprintf("name: %v\n", deploymentName))
-	f.WriteString(fmt.Sprintf("director_uuid: %v\n", info.UUID))
-	f.WriteString(fmt.Sprintf("couchbase:\n  instances: %v\n", instances))
-	f.Close()
-	args = append(args, f.Name())
-	utils.Logger.Printf("client.bosh.CreateInstance: command args: %v\n", args)
-	cmd := exec.Command("spruce", args...)
-	utils.Logger.Printf("client.bosh.CreateInstance: command: %v\n", cmd)
+	c.setErrandState(deploymentName, nil)
+}
 
-	// make sure the deployment file directory exists
-	err = os.MkdirAll(c.dProps.DataDir, 0750)
+// finishDeploy waits for the deploy task of a new deployment, runs its
+// errands, then frees the director slot.
+func (c *BoshClient) finishDeploy(deploymentName string, taskID int, errands []model.ErrandSettings, release func()) {
+	defer release()
+	err := c.waitForTask(deploymentName, taskID)
 	if err != nil {
-		panic(err)
+		utils.Logger.Printf("client.bosh.finishDeploy: %v: %v\n", deploymentName, err)
+		c.setErrandState(deploymentName, nil)
+		return
+	}
+	if len(errands) > 0 {
+		c.runErrands(deploymentName, errands)
 	}
+}
 
-	// create the output (deployment yml) file, attach it to the command execution (shell redirect)
-	fileName := c.dProps.DataDir + string(os.PathSeparator) + deploymentName + ".yml"
-	utils.Logger.Printf("client.bosh.CreateInstance: deployment file: '%v'\n", fileName)
-	outfile, err := os.Create(fileName)
+// deploy merges the manifest for deploymentName with the given number of
+// Couchbase instances and POSTs it to the director, returning the task ID.
+// Deploying an existing deployment updates it.  The caller holds a director
+// slot.
+func (c *BoshClient) deploy(deploymentName, directorUUID string, cbProps cbDefaultSettings, instances int) (int, error) {
+	manifestYAML, err := c.prepareManifest(deploymentName, directorUUID, cbProps, instances)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	defer outfile.Close()
-	cmd.Stdout = outfile
+	return c.postManifest(deploymentName, manifestYAML)
+}
 
-	err = cmd.Start()
+// prepareManifest returns the manifest for deploymentName, checked against
+// the cloud-config or given its static IPs, and keeps a copy in DataDir.
+func (c *BoshClient) prepareManifest(deploymentName, directorUUID string, cbProps cbDefaultSettings, instances int) ([]byte, error) {
+	manifestYAML, err := c.buildManifest(deploymentName, directorUUID, cbProps, instances)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.deploy: %v: %v\n", deploymentName, err)
+		return nil, err
+	}
+	if cbProps.cloudConfig != nil {
+		err = c.checkCloudConfig(manifestYAML)
+	} else {
+		manifestYAML, err = c.allocateStaticIPs(deploymentName, manifestYAML)
+	}
 	if err != nil {
-		panic(err)
+		utils.Logger.Printf("client.bosh.deploy: %v: %v\n", deploymentName, err)
+		return nil, err
 	}
-	cmd.Wait()
-	utils.Logger.Printf("client.bosh.CreateInstance: finished creating %v\n", fileName)
 
-	//==================================================================================================
-	// Now deploy that file using an HTTP POST
-	datReader, err := os.Open(fileName)
+	// keep the deployment file, for reference
+	err = os.MkdirAll(c.dProps.DataDir, 0750)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	fileName := c.dProps.DataDir + string(os.PathSeparator) + deploymentName + ".yml"
+	utils.Logger.Printf("client.bosh.deploy: deployment file: '%v'\n", fileName)
+	err = utils.WriteFile(fileName, manifestYAML)
+	if err != nil {
+		return nil, err
 	}
+	return manifestYAML, nil
+}
+
+// postManifest deploys manifestYAML, returning the director task.
+func (c *BoshClient) postManifest(deploymentName string, manifestYAML []byte) (int, error) {
+	//==================================================================================================
+	// Now deploy the manifest using an HTTP POST
+	datReader := bytes.NewReader(manifestYAML)
 	req, _ := http.NewRequest("POST", c.dProps.DirectorURL+"/deployments", datReader)
 	req.Header.Set("Content-Type", "text/yaml")
-	req.SetBasicAuth(c.dProps.DirectorUser, c.dProps.DirectorPassword)
-	utils.Logger.Printf("client.bosh.CreateInstance... request: \n%s\n\n", c.dumpRequest(req))
-	// be promiscuous about SSL, don't follow redirects (we expect a task URL)
-	client := &http.Client{
-		Transport:     tr,
-		CheckRedirect: noRedirect,
-	}
-	resp, err := client.Do(req)
+	utils.Logger.Printf("client.bosh.deploy... request: \n%s\n\n", c.dumpRequest(req))
+	// don't follow redirects (we expect a task URL)
+	resp, err := c.directorClient(noRedirect).Do(req)
 	if err != nil {
+		if resp == nil {
+			return 0, err
+		}
 		// TODO: check for something other than a redirect error
 		// we need the func to return an error, otherwise we fail.
 		utils.Logger.Printf("Ignoring 'error': %v\n", err)
 	}
-	utils.Logger.Printf("client.bosh.CreateInstance... response: \n%s\n\n", c.dumpResponse(resp))
+	defer resp.Body.Close()
+	utils.Logger.Printf("client.bosh.deploy... response: \n%s\n\n", c.dumpResponse(resp))
 	switch resp.StatusCode {
 	case http.StatusFound:
-		taskURL := resp.Header["Location"][0]
-		utils.Logger.Printf("client.bosh.CreateInstance taskURL: '%v'\n", taskURL)
+		taskURL := resp.Header.Get("Location")
+		utils.Logger.Printf("client.bosh.deploy taskURL: '%v'\n", taskURL)
+		if taskURL == "" {
+			return 0, errors.New("error POSTing deployment: the director did not redirect to a task")
+		}
 		chunks := strings.Split(taskURL, "/")
 		taskID, err := strconv.Atoi(chunks[len(chunks)-1])
 		if err != nil {
-			panic(err)
+			return 0, fmt.Errorf("error POSTing deployment: the director redirected to %q, not to a task", taskURL)
 		}
-		c.tasks[deploymentName] = taskID
-		// return the container ID for tracking
-		// the monitoring will be done by GetCredentials, called by the controller
-		utils.Logger.Printf("client.bosh.CreateInstance waitAndConfigure taskID: '%v'\n", taskID)
-		c.waitAndConfigure(taskID)
-
-		return deploymentName, nil
+		return taskID, nil
 	default:
 		// there is no body on this, but we'll read it anyway...
 		body, _ := ioutil.ReadAll(resp.Body)
-		defer resp.Body.Close()
-		return "", fmt.Errorf("error POSTing deployment: %s: %v", resp.Status, body)
+		return 0, fmt.Errorf("error POSTing deployment: %s: %v", resp.Status, body)
 	}
 }
 
+// UpdateInstance scales the cluster to the number of instances in
+// parameters.  To grow, BOSH deploys the new VMs, which are then added to the
+// cluster.  To shrink, the nodes BOSH will delete are first rebalanced out of
+// the cluster, as long as the remaining nodes can hold the buckets.
+func (c *BoshClient) UpdateInstance(instanceID string, plan *model.ServicePlan, parameters interface{}, credential *model.Credential, progress ProgressFunc) error {
+	utils.Logger.Printf("client.bosh.UpdateInstance %v parms: %v\n", instanceID, parameters)
+	if progress == nil {
+		progress = func(string) {}
+	}
+	err := c.ValidateUpdate(plan, parameters)
+	if err != nil {
+		return err
+	}
+	params, _ := model.DecodeParameters(parameters)
+	if params.Instances == 0 {
+		return nil
+	}
+	cbProps, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		return err
+	}
+
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		utils.Logger.Printf("client.bosh.UpdateInstance: error creating Bosh client: %v\n", err)
+		return err
+	}
+	vmStatuses, err := boshclient.FetchVMsStatus(instanceID)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.UpdateInstance... FetchVMsStatus: %v\n", err)
+		return fmt.Errorf("Could not list the VMs of %v: %v", instanceID, err)
+	}
+
+	cb := adminClient(credential)
+	switch {
+	case cbProps.instances > len(vmStatuses):
+		return c.scaleOut(instanceID, cb, cbProps, credential, progress)
+	case cbProps.instances < len(vmStatuses):
+		return c.scaleIn(instanceID, cb, cbProps, vmStatuses, credential, progress)
+	}
+	return nil
+}
+
+// ValidateParameters checks the parameters of a new instance against the plan.
+func (c *BoshClient) ValidateParameters(plan *model.ServicePlan, parameters interface{}) error {
+	_, err := cbInstanceProps(plan, parameters)
+	return err
+}
+
+// ValidateUpdate checks that the parameters of an update only change what
+// UpdateInstance can, i.e. the number of nodes.
+func (c *BoshClient) ValidateUpdate(plan *model.ServicePlan, parameters interface{}) error {
+	params, err := model.DecodeParameters(parameters)
+	if err != nil {
+		return fmt.Errorf("invalid parameters: %v", err)
+	}
+	if params.Bucket != nil || params.Indexes != nil {
+		return errors.New("the bucket settings and indexes of an existing instance cannot be changed")
+	}
+	if len(params.SampleBuckets) > 0 || params.Seed != "" {
+		return errors.New("sample buckets and seed data can only be loaded when the instance is created")
+	}
+	if params.ReplicateFrom != "" {
+		return errors.New("replication can only be set up when the instance is created")
+	}
+	if params.TLS != nil {
+		return errors.New("TLS can only be chosen when the instance is created")
+	}
+	if params.Instances == 0 {
+		return nil
+	}
+	_, err = cbInstanceProps(plan, parameters)
+	return err
+}
+
+func (c *BoshClient) scaleOut(instanceID string, cb *admin.Client, cbProps cbDefaultSettings, credential *model.Credential, progress ProgressFunc) error {
+	progress(fmt.Sprintf("deploying %d nodes...", cbProps.instances))
+	err := c.redeploy(instanceID, cbProps)
+	if err != nil {
+		return err
+	}
+
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		return err
+	}
+	vmStatuses, err := boshclient.FetchVMsStatus(instanceID)
+	if err != nil {
+		return fmt.Errorf("Could not list the VMs of %v: %v", instanceID, err)
+	}
+	cluster, err := clusterNodes(vmStatuses, cbProps.nodeGroups(len(vmStatuses)))
+	if err != nil {
+		return err
+	}
+	pool, err := cb.GetPool()
+	if err != nil {
+		return err
+	}
+	members := make(map[string]bool)
+	for _, node := range pool.Nodes {
+		members[node.OTPNode] = true
+	}
+
+	// a single node cluster knows its node as 127.0.0.1, so skip it by address
+	rootIP := nodeIP(credential.URI)
+	secure := credential.CACertificate != ""
+	var added []clusterNode
+	for _, node := range cluster {
+		if node.ip == rootIP || members[admin.OTPNodeName(node.ip)] {
+			continue
+		}
+		newNode, err := configureCouchbaseNode(admin.NodeURL(node.ip), cbProps, node.services, credential.UserName, credential.Password)
+		if err != nil {
+			return err
+		}
+		// an encrypted cluster only accepts nodes that encrypt too
+		if secure && cbProps.encryptionLevel != "" {
+			err = newNode.EnableNodeEncryption()
+			if err != nil {
+				return err
+			}
+		}
+		added = append(added, node)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	err = configureCouchbaseCluster(cb, added, credential.UserName, credential.Password, progress)
+	if err != nil {
+		return err
+	}
+
+	if cbProps.serverGroups != "" {
+		// the new nodes joined the default group: move them, then the data
+		moved, err := configureServerGroups(cb, cbProps, cluster)
+		if err != nil {
+			return err
+		}
+		if moved {
+			err = rebalanceCluster(cb, progress)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !secure {
+		return nil
+	}
+
+	// the certificate must cover the new nodes too
+	ips, err := c.nodeIPs(instanceID, credential)
+	if err != nil {
+		return err
+	}
+	progress("deploying a TLS certificate for the new nodes...")
+	err = c.deployCertificate(instanceID, cbProps, ips)
+	if err != nil {
+		return err
+	}
+	err = reloadCertificates(credential, ips)
+	if err != nil {
+		return err
+	}
+	return secureCredential(credential, ips)
+}
+
+func (c *BoshClient) scaleIn(instanceID string, cb *admin.Client, cbProps cbDefaultSettings, vmStatuses []directorVM, credential *model.Credential, progress ProgressFunc) error {
+	err := checkScaleIn(cb, cbProps.dataNodes())
+	if err != nil {
+		return err
+	}
+
+	// BOSH deletes the VMs with the highest indexes
+	rootIP := nodeIP(credential.URI)
+	var removed []string
+	for _, vmStat := range vmStatuses {
+		if vmStat.Index < cbProps.instances || len(vmStat.IPs) == 0 {
+			continue
+		}
+		if vmStat.IPs[0] == rootIP {
+			return fmt.Errorf("cannot remove %v/%d: it is the node the credentials use", vmStat.JobName, vmStat.Index)
+		}
+		removed = append(removed, vmStat.IPs[0])
+	}
+	progress(fmt.Sprintf("removing %d nodes from the cluster...", len(removed)))
+	err = ejectCouchbaseNodes(cb, removed, progress)
+	if err != nil {
+		return err
+	}
+
+	progress(fmt.Sprintf("deleting %d VMs...", len(removed)))
+	err = c.redeploy(instanceID, cbProps)
+	if err != nil || credential.CACertificate == "" {
+		return err
+	}
+	ips, err := c.nodeIPs(instanceID, credential)
+	if err != nil {
+		return err
+	}
+	return secureCredential(credential, ips)
+}
+
+// redeploy deploys deploymentName again with the instance count in cbProps,
+// once it gets a director slot, and waits for the director to finish.  A TLS
+// deployment keeps its certificate unless cbProps holds a new one.
+func (c *BoshClient) redeploy(deploymentName string, cbProps cbDefaultSettings) error {
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		return err
+	}
+	if cbProps.certificate == nil {
+		cbProps.certificate, err = c.savedCertificate(deploymentName)
+		if err != nil {
+			return err
+		}
+	}
+	info, err := boshclient.GetInfo()
+	if err != nil {
+		utils.Logger.Printf("client.bosh.redeploy: Could not fetch BOSH info %v\n", err)
+		return errors.New("BOSH error")
+	}
+	release := <-c.scheduler.queue(deploymentName, "")
+	if release == nil {
+		return fmt.Errorf("%v is being deleted", deploymentName)
+	}
+	defer release()
+	taskID, err := c.deploy(deploymentName, info.UUID, cbProps, cbProps.instances)
+	if err != nil {
+		return err
+	}
+	c.setTask(deploymentName, taskID)
+	return c.waitForTask(deploymentName, taskID)
+}
+
+// How often and for how long waitForTask polls a director task.
+var (
+	taskPollInterval = 10 * time.Second
+	taskTimeout      = 2 * time.Hour
+)
+
+// waitForTask polls the director until the task of deploymentName is
+// finished, returning an error explaining the failure unless it succeeded.
+func (c *BoshClient) waitForTask(deploymentName string, taskID int) error {
+	deadline := time.Now().Add(taskTimeout)
+	for time.Now().Before(deadline) {
+		boshclient, err := c.createBoshClient()
+		if err != nil {
+			return err
+		}
+		taskStatus, err := boshclient.GetTaskStatus(taskID)
+		if err != nil {
+			utils.Logger.Printf("client.bosh.waitForTask... GetTaskStatus: %v\n", err)
+		}
+		if taskStatus.State == "done" {
+			return nil
+		}
+		if taskFailed(taskStatus.State) {
+			return errors.New(c.taskFailure(deploymentName, taskStatus))
+		}
+		time.Sleep(taskPollInterval)
+	}
+	return fmt.Errorf("BOSH task %d did not finish within %v", taskID, taskTimeout)
+}
+
+// nodeIP returns the host part of a node URL such as http://10.244.1.2:8091.
+func nodeIP(nodeURL string) string {
+	u, err := url.Parse(nodeURL)
+	if err != nil {
+		return ""
+	}
+	host, _, err := gonet.SplitHostPort(u.Host)
+	if err != nil {
+		return u.Host
+	}
+	return host
+}
+
 func noRedirect(req *http.Request, via []*http.Request) error {
 	return fmt.Errorf("Don't redirect to %v", req)
 }
 
 // DeleteInstance deletes the instance in Bosh with the associated instanceID.
-func (c *BoshClient) DeleteInstance(instanceID string) error {
+// A deploy of it still queued is dropped.  If no director slot is free, the
+// delete is queued for orgID and DeleteInstance returns at once.
+func (c *BoshClient) DeleteInstance(instanceID string, orgID string) error {
 	utils.Logger.Printf("client.bosh.DeleteInstance: %v\n", instanceID)
+	c.scheduler.cancel(instanceID)
+	c.mutex.Lock()
+	unsent := c.unsent[instanceID] || c.deployErrors[instanceID] != ""
+	c.mutex.Unlock()
+	if unsent {
+		// the director never got the deployment
+		c.removeDeploymentFiles(instanceID)
+		return nil
+	}
+
+	slot := c.scheduler.queue(instanceID, orgID)
+	select {
+	case release := <-slot:
+		defer release()
+		return c.deleteDeployment(instanceID)
+	default:
+		utils.Logger.Printf("client.bosh.DeleteInstance: %v is queued (position %d)\n", instanceID, c.scheduler.position(instanceID))
+		go func() {
+			release := <-slot
+			if release == nil {
+				return
+			}
+			defer release()
+			err := c.deleteDeployment(instanceID)
+			if err != nil {
+				utils.Logger.Printf("client.bosh.DeleteInstance: %v\n", err)
+			}
+		}()
+		return nil
+	}
+}
+
+// deleteDeployment deletes deploymentName on the director, waiting for it to
+// be gone, then the broker's files and static IPs of the deployment.
+func (c *BoshClient) deleteDeployment(deploymentName string) error {
 	// get a bosh client
 	boshclient, err := c.createBoshClient()
 	if err != nil {
@@ -258,23 +725,55 @@ func (c *BoshClient) DeleteInstance(instanceID string) error {
 		return err
 	}
 	utils.Logger.Printf("client.bosh.DeleteInstance...Client: %v\n", boshclient)
-	fileName := c.dProps.DataDir + string(os.PathSeparator) + instanceID + ".yml"
 
-	apiResponse := boshclient.DeleteDeployment(instanceID)
-	if apiResponse.IsNotSuccessful() {
-		utils.Logger.Printf("client.bosh.DeleteInstance: failed to delete deployment %v: %v", instanceID, apiResponse)
-		return fmt.Errorf("failed to delete %v", instanceID)
-	}
-	err = os.Remove(fileName)
+	err = boshclient.DeleteDeployment(deploymentName)
 	if err != nil {
-		utils.Logger.Printf(fmt.Sprintf("client.bosh.DeleteInstance: could not remove %v: %v\n", fileName, err))
+		utils.Logger.Printf("client.bosh.DeleteInstance: failed to delete deployment %v: %v\n", deploymentName, err)
+		return fmt.Errorf("failed to delete %v", deploymentName)
 	}
+	c.removeDeploymentFiles(deploymentName)
 	return nil
 }
 
-// GetCredentials will configure the Couchbase instance with credentials and a bucket and other settings
-func (c *BoshClient) GetCredentials(instanceID string) (*model.Credential, error) {
+// removeDeploymentFiles removes what the broker keeps of a deleted
+// deployment: its manifest, certificate and static IPs.
+func (c *BoshClient) removeDeploymentFiles(deploymentName string) {
+	fileName := c.dProps.DataDir + string(os.PathSeparator) + deploymentName + ".yml"
+	err := os.Remove(fileName)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.DeleteInstance: could not remove %v: %v\n", fileName, err)
+	}
+	err = c.removeCertificate(deploymentName)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.DeleteInstance: could not remove the certificate of %v: %v\n", deploymentName, err)
+	}
+	err = c.releaseStaticIPs(deploymentName)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.DeleteInstance: could not release the static IPs of %v: %v\n", deploymentName, err)
+	}
+	c.scheduler.forget(deploymentName)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.unsent, deploymentName)
+	delete(c.deployErrors, deploymentName)
+	delete(c.errands, deploymentName)
+}
+
+// GetCredentials will configure the Couchbase instance with credentials and a
+// bucket and other settings, sized according to the plan.
+func (c *BoshClient) GetCredentials(instanceID string, plan *model.ServicePlan, parameters interface{}, progress ProgressFunc) (*model.Credential, error) {
 	// utils.Logger.Printf("client.bosh.GetCredentials: %v\n", instanceID)
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	if deployError := c.deployError(instanceID); deployError != "" {
+		return nil, &FatalError{errors.New(deployError)}
+	}
+	// a deploy waiting for a director slot has no task yet
+	if c.scheduler.position(instanceID) > 0 || c.task(instanceID) == 0 {
+		return nil, &QueuedError{errors.New("deploy queued")}
+	}
 
 	// get a bosh client
 	boshclient, err := c.createBoshClient()
@@ -282,27 +781,33 @@ func (c *BoshClient) GetCredentials(instanceID string) (*model.Credential, error
 		utils.Logger.Printf("client.bosh.GetCredentials: error creating Bosh client: %v\n", err)
 		return nil, err
 	}
-	taskStatus, apiResponse := boshclient.GetTaskStatus(c.tasks[instanceID])
-	if apiResponse.IsNotSuccessful() {
-		utils.Logger.Printf("client.bosh.GetCredentials... gogo.GetTaskStatus apiResponse: %v\n", apiResponse)
+	taskStatus, err := boshclient.GetTaskStatus(c.task(instanceID))
+	if err != nil {
+		utils.Logger.Printf("client.bosh.GetCredentials... GetTaskStatus: %v\n", err)
+		return nil, err
 	}
 	// utils.Logger.Printf("client.bosh.GetCredentials... taskStatus: %v\n", taskStatus)
 	switch taskStatus.State {
 	case "done": // get the IPs and configure them, return credentials
 	case "success":
 	case "queued":
-		return nil, errors.New("task queued")
+		return nil, &QueuedError{errors.New("task queued")}
 	case "processing":
 		return nil, errors.New("task processing")
 	case "in progress":
 		return nil, errors.New("task in progress")
-	case "error":
-		return nil, errors.New("error")
-	case "failed":
-		return nil, errors.New("failed")
+	case "error", "failed", "cancelled", "timeout":
+		return nil, &FatalError{errors.New(c.taskFailure(instanceID, taskStatus))}
 	default:
 		return nil, errors.New("unknown task status: " + taskStatus.State)
 	}
+	// the errands of a new deployment run once its task is done
+	if errand := c.errandState(instanceID); errand != nil {
+		if errand.failure != "" {
+			return nil, &FatalError{errors.New(errand.failure)}
+		}
+		return nil, fmt.Errorf("errand %v running", errand.name)
+	}
 
 	// now configure the Couchbase instances at those addresses...
 	boshclient, err = c.createBoshClient()
@@ -310,34 +815,134 @@ func (c *BoshClient) GetCredentials(instanceID string) (*model.Credential, error
 		utils.Logger.Printf("client.bosh.GetCredentials: error creating Bosh client: %v\n", err)
 		return nil, err
 	}
-	vmStatuses, apiResponse := boshclient.FetchVMsStatus(instanceID)
-	if apiResponse.IsNotSuccessful() {
-		utils.Logger.Printf("client.bosh.GetCredentials... gogo.FetchVMsStatus: %v\n", apiResponse)
-		return nil, fmt.Errorf("Could not invoke gogo.FetchVMsStatus: %v", apiResponse.Message)
+	vmStatuses, err := boshclient.FetchVMsStatus(instanceID)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.GetCredentials... FetchVMsStatus: %v\n", err)
+		return nil, fmt.Errorf("Could not list the VMs of %v: %v", instanceID, err)
+	}
+	cbProps, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := clusterNodes(vmStatuses, cbProps.nodeGroups(len(vmStatuses)))
+	if err != nil {
+		return nil, err
 	}
-	var cred *model.Credential
-	cred = nil
 	userID := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
 	passwd := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
 	saslpasswd := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
-	var iplist = make([]string, len(vmStatuses))
-	for i, vmStat := range vmStatuses {
-		ip := vmStat.IPs[0]
-		iplist[i] = ip
-		if cred != nil {
-			_, err = c.configureCouchbaseInstance(ip, userID, passwd, saslpasswd)
-		} else {
-			cred, err = c.configureCouchbaseInstance(ip, userID, passwd, saslpasswd)
+	cbProps.serverVersion, err = detectVersion(admin.NewClient(admin.NodeURL(cluster[0].ip), cbProps.adminUser, cbProps.adminPass), cbProps)
+	if err != nil {
+		return nil, configureFailure(err)
+	}
+	var nodes = make([]*admin.Client, len(cluster))
+	if cbProps.clusterInitErrand() {
+		// an errand has set up the nodes and the cluster
+		nodeURLs := make([]string, len(cluster))
+		for i, node := range cluster {
+			nodeURLs[i] = admin.NodeURL(node.ip)
+		}
+		nodes, err = takeOverCluster(nodeURLs, cbProps, userID, passwd)
+		if err != nil {
+			utils.Logger.Printf("client.bosh.GetCredentials: takeOverCluster: %v\n", err)
+			return nil, &FatalError{err}
 		}
+	} else {
+		nodeURLs := make([]string, len(cluster))
+		for i, node := range cluster {
+			nodeURLs[i] = admin.NodeURL(node.ip)
+		}
+		nodes, err = configureCouchbaseNodes(nodeURLs, cluster, cbProps, userID, passwd)
 		if err != nil {
 			return nil, err
 		}
+		// setup cluster - the nodes have new credentials now, so there is no retrying
+		if len(cluster) > 1 {
+			err = configureCouchbaseCluster(nodes[0], cluster[1:], userID, passwd, progress)
+			if err != nil {
+				utils.Logger.Printf("client.bosh.GetCredentials: configureCouchbaseCluster: %v\n", err)
+				return nil, &FatalError{err}
+			}
+		}
+	}
+	ips := make([]string, len(cluster))
+	for i, node := range cluster {
+		ips[i] = node.ip
+	}
+	if cbProps.tls {
+		progress("deploying TLS certificates...")
+		err = c.deployCertificate(instanceID, cbProps, ips)
+		if err != nil {
+			return nil, &FatalError{err}
+		}
+		err = enableTLS(nodes[0], nodes, cbProps, progress)
+		if err != nil {
+			return nil, &FatalError{err}
+		}
+	}
+	err = configureClusterSettings(nodes[0], cbProps, cluster, progress)
+	if err != nil {
+		utils.Logger.Printf("client.bosh.GetCredentials: configureClusterSettings: %v\n", err)
+		return nil, &FatalError{err}
 	}
-	// setup cluster
-	if len(iplist) > 1 {
-		c.configureCouchbaseCluster(iplist, userID, passwd)
+	credential, err := createCouchbaseBucket(nodes[0], cbProps, userID, passwd, saslpasswd)
+	if err != nil {
+		return nil, &FatalError{err}
+	}
+	err = loadCouchbaseData(nodes[0], cbProps, progress)
+	if err != nil {
+		return nil, &FatalError{err}
+	}
+	for _, node := range cluster {
+		if hasService(node.services, admin.ServiceQuery) {
+			query := admin.NewClient(admin.QueryURL(node.ip), userID, passwd)
+			err = createCouchbaseIndexes(query, cbProps, progress)
+			if err != nil {
+				return nil, &FatalError{err}
+			}
+			break
+		}
+	}
+	if cbProps.tls {
+		err = secureCredential(credential, ips)
+		if err != nil {
+			return nil, &FatalError{err}
+		}
+	}
+	return credential, nil
+}
+
+// clusterNodes matches the deployment's VMs to the plan's node groups by job
+// name, and puts a data node first since that node becomes the cluster root.
+func clusterNodes(vmStatuses []directorVM, groups []nodeGroup) ([]clusterNode, error) {
+	services := make(map[string][]string)
+	for _, group := range groups {
+		services[group.jobName] = group.services
+	}
+
+	// in index order, so the root is the first VM of its job
+	vmStatuses = append([]directorVM(nil), vmStatuses...)
+	sort.SliceStable(vmStatuses, func(i, j int) bool {
+		return vmStatuses[i].Index < vmStatuses[j].Index
+	})
+
+	var dataNodes, otherNodes []clusterNode
+	for _, vmStat := range vmStatuses {
+		groupServices, ok := services[vmStat.JobName]
+		if !ok {
+			return nil, fmt.Errorf("VM %v/%d does not belong to any node group of the plan", vmStat.JobName, vmStat.Index)
+		}
+		if len(vmStat.IPs) == 0 {
+			return nil, fmt.Errorf("VM %v/%d has no IP address", vmStat.JobName, vmStat.Index)
+		}
+		node := clusterNode{ip: vmStat.IPs[0], services: groupServices, az: vmStat.AZ, index: vmStat.Index}
+		if hasService(groupServices, admin.ServiceData) {
+			dataNodes = append(dataNodes, node)
+		} else {
+			otherNodes = append(otherNodes, node)
+		}
 	}
-	return cred, nil
+	return append(dataNodes, otherNodes...), nil
 }
 
 // RemoveCredentials does not really remove credentials for Couchbase, since
@@ -349,8 +954,22 @@ func (c *BoshClient) RemoveCredentials(instanceID string, bindingID string) erro
 	return nil
 }
 
-// SetCatalog sets the catalog object for this broker.
+// SetCatalog sets the catalog object for this broker, after checking that
+// every plan in it describes a valid Couchbase configuration.  Plans whose
+// releases or stemcells the director lacks are left out (see bosh_assets.go).
 func (c *BoshClient) SetCatalog(catalog *model.Catalog) error {
+	err := validateCatalogPlans(catalog)
+	if err != nil {
+		return err
+	}
+	err = c.checkTemplateProfiles(catalog)
+	if err != nil {
+		return err
+	}
+	catalog, err = c.checkDirectorAssets(catalog)
+	if err != nil {
+		return err
+	}
 	c.catalog = catalog
 	return nil
 }
@@ -372,175 +991,10 @@ func (c *BoshClient) RevokeKeyPair(instanceID string, privateKeyName string) err
 
 // Private methods
 
-func (c *BoshClient) createBoshClient() (api.BoshDirectorRepository, error) {
-	director := gogobosh.NewDirector(c.dProps.DirectorURL, c.dProps.DirectorUser, c.dProps.DirectorPassword)
-	return api.NewBoshDirectorRepository(&director, net.NewDirectorGateway()), nil
-}
-
 func (c *BoshClient) configure(ipAddress string) error {
 	return nil
 }
 
-func (c *BoshClient) waitAndConfigure(taskID int) {
-	utils.Logger.Printf("waitAndConfigure task: %v\n", taskID)
-}
-
-func (c *BoshClient) configureCouchbaseInstance(ipaddr, userID, passwd, saslpasswd string) (*model.Credential, error) {
-	client := &http.Client{}
-	cburl := fmt.Sprintf("http://%s:%d", ipaddr, 8091)
-	cbProps := cbDefaultProps()
-
-	// ${CURL} -u Administrator:password -X POST http://${IP}:8091/pools/default -d memoryQuota=${MEMORYQUOTA}
-	request, err := http.NewRequest("POST", fmt.Sprintf("%s/pools/default", cburl), strings.NewReader(fmt.Sprintf("memoryQuota=%d", cbProps.ramQuota)))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err := client.Do(request)
-	if err != nil {
-		utils.Logger.Printf("client.bosh.configureCouchbaseInstance: error in http POST %v\n", err)
-		return nil, err
-	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(1): %v", response.StatusCode)
-	}
-	defer response.Body.Close()
-
-	// ${CURL} -u id:pw -X POST http://${IP}:8091/pools/default -d indexMemoryQuota=${INDEXQUOTA}
-	request, err = http.NewRequest("POST", fmt.Sprintf("%s/pools/default", cburl), strings.NewReader(fmt.Sprintf("indexMemoryQuota=%d", cbProps.indexRAMQuota)))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err = client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(2): %v", response.StatusCode)
-	}
-	defer response.Body.Close()
-
-	// ${CURL} -u Administrator:password -X POST http://${IP}:8091/node/controller/setupServices -d services=${SERVICES}
-	request, err = http.NewRequest("POST", fmt.Sprintf("%s/node/controller/setupServices", cburl), strings.NewReader("services=kv%2Cindex%2Cn1ql"))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	client = &http.Client{}
-	response, err = client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(3): %v", response.StatusCode)
-	}
-	defer response.Body.Close()
-
-	// override the default ID/password
-	// ${CURL} -o /dev/null -u Administrator:password -X POST http://${IP}:8091/settings/web -d password=${PASSWORD} -d username=${USERNAME} -d port=8091
-	bucketName := "cfdefault"
-	credentials := model.Credential{
-		URI:          cburl,
-		UserName:     userID,
-		Password:     passwd,
-		SASLPassword: saslpasswd,
-		BucketName:   bucketName,
-	}
-	utils.Logger.Printf("client.bosh.configureCouchbaseInstance: %v\n", credentials)
-	request, err = http.NewRequest("POST",
-		fmt.Sprintf("%s/settings/web", cburl),
-		strings.NewReader(fmt.Sprintf("username=%s&password=%s&port=%d", credentials.UserName, credentials.Password, cbProps.port)))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err = client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(4): %v", response.StatusCode)
-	}
-	defer response.Body.Close()
-
-	// ${CURL} -u ${USERNAME}:${PASSWORD} -X POST http://${IP}:8091/pools/default/buckets \
-	//   -d name=${BUCKET} -d bucketType=couchbase -d ramQuotaMB=${BUCKETRAM} -d proxyPort=9999 \
-	//   -d authType=sasl -d saslPassword=${SASLPASSWORD}
-	request, err = http.NewRequest("POST",
-		fmt.Sprintf("%s/pools/default/buckets", cburl),
-		strings.NewReader(fmt.Sprintf("name=%s&bucketType=couchbase&ramQuotaMB=%d&authType=sasl&saslPassword=%s", bucketName, 768, saslpasswd)))
-	request.SetBasicAuth(userID, passwd)
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err = client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(5): %v", response.StatusCode)
-	}
-	defer response.Body.Close()
-
-	return &credentials, nil
-
-}
-
-func (c *BoshClient) configureCouchbaseCluster(ipaddrs []string, userID, passwd string) (err error) {
-	// tell first node about each of the others
-
-	// POST
-	// curl -s -u user:pass http://10.254.0.2:8091/controller/addNode -d 'hostname=10.254.0.6&user=USERNAME&password=PASSWORD&services=kv%2Cindex%2Cn1ql'
-
-	client := &http.Client{}
-	cburl := fmt.Sprintf("http://%s:%d", ipaddrs[0], 8091)
-	utils.Logger.Printf("client.bosh.configureCouchbaseCluster - using base URL: %v\n", cburl)
-
-	var knownNodes = "ns_1%40" + ipaddrs[0]
-	for idx, ip := range ipaddrs[1:] {
-		utils.Logger.Printf("client.bosh.configureCouchbaseCluster - adding node %d: %v\n", idx, ip)
-		knownNodes = knownNodes + "%2Cns_1%40" + ip
-		reqString := fmt.Sprintf("hostname=%s&user=%s&password=%s&services=%s", ip, userID, passwd, "kv%2Cindex%2Cn1ql")
-		request, err := http.NewRequest("POST", fmt.Sprintf("%s/controller/addNode", cburl),
-			strings.NewReader(reqString))
-		request.SetBasicAuth(userID, passwd)
-		request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		utils.Logger.Printf("client.bosh.configureCouchbaseCluster: addNode #%d %s REQUEST\n%s\n\n", idx, ip, c.dumpRequest(request))
-
-		response, err := client.Do(request)
-		utils.Logger.Printf("client.bosh.configureCouchbaseCluster: addNode #%d %s RESPONSE\n%s\n\n", idx, ip, c.dumpResponse(response))
-		if err != nil {
-			utils.Logger.Printf("client.bosh.configureCouchbaseCluster: error in http addNode %s POST: %v\n", ip, err)
-			return err
-		}
-		switch response.StatusCode {
-		case http.StatusBadRequest:
-			utils.Logger.Printf("client.bosh.configureCouchbaseCluster: got 400 from addNode %d/%s, ignoring\n", idx, ip)
-			continue
-		case http.StatusOK:
-			continue
-		default:
-			utils.Logger.Printf("client.bosh.configureCouchbaseCluster: bad response code from http addNode POST %v\n", response.StatusCode)
-			return errors.New("Bad cluster status code")
-		}
-	}
-
-	// rebalance: POST /controller/rebalance -d 'ejectedNodes=&knownNodes=ns_1%40192.168.0.77%2Cns_1%40192.168.0.56'
-	request, err := http.NewRequest("POST", fmt.Sprintf("%s/controller/rebalance", cburl),
-		strings.NewReader(fmt.Sprintf("ejectedNodes=&knownNodes=%s", knownNodes)))
-	request.SetBasicAuth(userID, passwd)
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	utils.Logger.Printf("client.bosh.configureCouchbaseCluster: rebalance REQUEST: \n%s\n\n", c.dumpRequest(request))
-	response, err := client.Do(request)
-	utils.Logger.Printf("client.bosh.configureCouchbaseCluster: rebalance RESPONSE: \n%s\n\n", c.dumpResponse(response))
-	if err != nil {
-		utils.Logger.Printf("client.bosh.configureCouchbaseCluster: error in http rebalance POST %v\n", err)
-		return err
-	}
-	if response.StatusCode != 200 {
-		utils.Logger.Printf("client.bosh.configureCouchbaseCluster: bad response code from http rebalance POST %v\n", response.StatusCode)
-		return errors.New("Bad rebalance status code")
-	}
-
-	return nil
-}
-
 func (c *BoshClient) dumpRequest(request *http.Request) string {
 	data, err := httputil.DumpRequest(request, true)
 	if err != nil {
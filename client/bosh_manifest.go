@@ -0,0 +1,247 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+	"github.com/ssdowd/couchbasebroker/manifest"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// builtinProfiles are the template profiles of the broker: bosh-lite for v1
+// manifests, cloud-config for plans with a cloudConfig.
+var builtinProfiles = map[string]*config.TemplateProfile{
+	"bosh-lite": {Templates: []string{
+		"base-cb-deploy.yml",
+		"network-bosh-lite.yml",
+		"resources-bosh-lite.yml",
+		"couchbase-job-defaults.yml",
+		"stub.yml",
+	}},
+	"cloud-config": {Templates: []string{
+		"base-cb-deploy-v2.yml",
+	}},
+}
+
+// templateProfile returns the name and the template profile of a plan: the
+// one the plan names, else cloud-config for a plan with a cloudConfig, else
+// the one of the BOSH config, else bosh-lite.  The BOSH config's profiles
+// take precedence over the builtin ones of the same name.
+func (c *BoshClient) templateProfile(cbProps cbDefaultSettings) (string, *config.TemplateProfile, error) {
+	name := cbProps.templateProfile
+	switch {
+	case name != "":
+	case cbProps.cloudConfig != nil:
+		name = "cloud-config"
+	case c.dProps.TemplateProfile != "":
+		name = c.dProps.TemplateProfile
+	default:
+		name = "bosh-lite"
+	}
+	if profile, ok := c.dProps.TemplateProfiles[name]; ok {
+		return name, profile, nil
+	}
+	if profile, ok := builtinProfiles[name]; ok {
+		return name, profile, nil
+	}
+	return name, nil, fmt.Errorf("unknown template profile %q", name)
+}
+
+// checkTemplateProfiles makes sure every plan of the catalog has a template
+// profile with templates.
+func (c *BoshClient) checkTemplateProfiles(catalog *model.Catalog) error {
+	for _, s := range catalog.Services {
+		for i := range s.Plans {
+			cbProps, err := cbPlanProps(&s.Plans[i])
+			if err != nil {
+				return err
+			}
+			name, profile, err := c.templateProfile(cbProps)
+			if err != nil {
+				return fmt.Errorf("plan %v: %v", s.Plans[i].Name, err)
+			}
+			if len(profile.Templates) == 0 {
+				return fmt.Errorf("plan %v: template profile %q has no templates", s.Plans[i].Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// buildManifest returns the deployment manifest of deploymentName: the
+// templates of the plan's profile merged with its vars and the deployment's
+// stub.
+func (c *BoshClient) buildManifest(deploymentName, directorUUID string, cbProps cbDefaultSettings, instances int) ([]byte, error) {
+	name, profile, err := c.templateProfile(cbProps)
+	if err != nil {
+		return nil, err
+	}
+	templateDir := c.dProps.TemplateDir
+	if !strings.HasPrefix(templateDir, string(os.PathSeparator)) {
+		templateDir = utils.GetPath([]string{templateDir})
+	}
+	var paths []string
+	for _, template := range manifestTemplates(profile, cbProps) {
+		if !filepath.IsAbs(template) {
+			template = filepath.Join(templateDir, template)
+		}
+		paths = append(paths, template)
+	}
+	sources, err := manifest.ReadFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+	if len(profile.Vars) > 0 {
+		vars, err := yaml.Marshal(map[string]interface{}{"vars": profile.Vars})
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, manifest.Source{Name: "template profile " + name + " vars", YAML: vars})
+	}
+	stub, err := manifestStub(deploymentName, directorUUID, cbProps, instances)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, manifest.Source{Name: deploymentName + " stub", YAML: stub})
+	// the couchbase section only feeds the grabs of the job templates, and
+	// the vars those of the profile's
+	return manifest.Merge(sources, "couchbase", "vars")
+}
+
+// manifestTemplates returns the template files of profile merged (in order)
+// to build the deployment manifest for a plan.
+func manifestTemplates(profile *config.TemplateProfile, cbProps cbDefaultSettings) []string {
+	if cbProps.cloudConfig != nil || cbProps.topology == nil {
+		return profile.Templates
+	}
+	// the jobs for each node group come from the generated stub instead
+	var templates []string
+	for _, t := range profile.Templates {
+		if filepath.Base(t) != "couchbase-job-defaults.yml" {
+			templates = append(templates, t)
+		}
+	}
+	return templates
+}
+
+// manifestStub returns the deployment specific YAML that is merged over the
+// templates: the deployment name, director UUID and Couchbase sizing, plus one
+// job per node group when the plan declares a topology, and the job
+// properties.
+func manifestStub(deploymentName, directorUUID string, cbProps cbDefaultSettings, instances int) ([]byte, error) {
+	if cbProps.cloudConfig != nil {
+		return manifestStubV2(deploymentName, cbProps, instances)
+	}
+	stub := map[string]interface{}{
+		"name":          deploymentName,
+		"director_uuid": directorUUID,
+		"couchbase": map[string]interface{}{
+			"instances":     instances,
+			"resource_pool": cbProps.resourcePool,
+		},
+	}
+
+	properties, err := jobProperties(cbProps)
+	if err != nil {
+		return nil, err
+	}
+	if cbProps.topology != nil {
+		var jobs []interface{}
+		for _, group := range cbProps.nodeGroups(instances) {
+			jobs = append(jobs, map[string]interface{}{
+				"name":      group.jobName,
+				"instances": group.instances,
+				"lifecycle": "service",
+				"networks": []interface{}{
+					map[string]interface{}{"name": "(( grab networks.[0].name ))"},
+				},
+				"properties":    properties,
+				"resource_pool": cbProps.resourcePool,
+				"templates": []interface{}{
+					map[string]interface{}{"name": couchbaseJobName},
+				},
+			})
+		}
+		stub["jobs"] = jobs
+	} else if len(properties) > 0 {
+		// merged by name into the job of couchbase-job-defaults.yml
+		stub["jobs"] = []interface{}{
+			map[string]interface{}{"name": couchbaseJobName, "properties": properties},
+		}
+	}
+
+	return yaml.Marshal(stub)
+}
+
+// manifestStubV2 returns the deployment specific part of a v2 manifest: the
+// name, the stemcell and an instance group per node group, placed with the
+// cloud-config names of the plan.
+func manifestStubV2(deploymentName string, cbProps cbDefaultSettings, instances int) ([]byte, error) {
+	properties, err := jobProperties(cbProps)
+	if err != nil {
+		return nil, err
+	}
+	var groups []interface{}
+	for _, group := range cbProps.nodeGroups(instances) {
+		instanceGroup := map[string]interface{}{
+			"name":      group.jobName,
+			"instances": group.instances,
+			"lifecycle": "service",
+			"azs":       cbProps.cloudConfig.AZs,
+			"vm_type":   group.vmType,
+			"stemcell":  stemcellAlias,
+			"networks": []interface{}{
+				map[string]interface{}{"name": cbProps.cloudConfig.Network},
+			},
+			"jobs": []interface{}{
+				map[string]interface{}{
+					"name":       couchbaseJobName,
+					"release":    couchbaseRelease,
+					"properties": properties,
+				},
+			},
+		}
+		if group.persistentDiskType != "" {
+			instanceGroup["persistent_disk_type"] = group.persistentDiskType
+		}
+		groups = append(groups, instanceGroup)
+	}
+
+	return yaml.Marshal(map[string]interface{}{
+		"name": deploymentName,
+		"stemcells": []interface{}{
+			map[string]interface{}{
+				"alias":   stemcellAlias,
+				"os":      cbProps.stemcellOS(),
+				"version": cbProps.stemcellVersion(),
+			},
+		},
+		"instance_groups": groups,
+	})
+}
+
+// jobProperties returns the properties of the Couchbase jobs: with TLS, the
+// node certificate for the job to put in the Couchbase inbox.
+func jobProperties(cbProps cbDefaultSettings) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	if cbProps.certificate == nil {
+		return properties, nil
+	}
+	authority, err := certificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	properties["couchbase"] = map[string]interface{}{
+		"tls": map[string]interface{}{
+			"ca":          string(authority.CertificatePEM()),
+			"certificate": string(cbProps.certificate.ChainPEM),
+			"private_key": string(cbProps.certificate.PrivateKeyPEM),
+		},
+	}
+	return properties, nil
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestTopology(t *testing.T) {
+	plan := &model.ServicePlan{
+		Name: "production",
+		Metadata: map[string]interface{}{
+			"ramQuota": 1024,
+			"topology": []map[string]interface{}{
+				{"name": "data", "services": []string{"kv"}, "instances": 3},
+				{"name": "query", "services": []string{"index", "n1ql"}, "instances": 2},
+			},
+		},
+	}
+	props, err := cbPlanProps(plan)
+	if err != nil {
+		t.Fatalf("cbPlanProps: %v", err)
+	}
+	groups := props.nodeGroups(0)
+	if len(groups) != 2 || groups[0].jobName != "couchbase4-data" || groups[1].instances != 2 {
+		t.Errorf("unexpected node groups %+v", groups)
+	}
+
+	vms := []directorVM{
+		{JobName: "couchbase4-query", Index: 0, IPs: []string{"10.244.1.2"}},
+		{JobName: "couchbase4-data", Index: 0, IPs: []string{"10.244.1.3"}},
+	}
+	nodes, err := clusterNodes(vms, groups)
+	if err != nil {
+		t.Fatalf("clusterNodes: %v", err)
+	}
+	if nodes[0].ip != "10.244.1.3" || nodes[1].services[1] != "n1ql" {
+		t.Errorf("data node should come first: %+v", nodes)
+	}
+	if _, err = clusterNodes([]directorVM{{JobName: "other"}}, groups); err == nil {
+		t.Errorf("expected a VM outside the topology to be rejected")
+	}
+
+	stub, err := manifestStub("cb-1", "uuid", props, 5)
+	if err != nil {
+		t.Fatalf("manifestStub: %v", err)
+	}
+	if !strings.Contains(string(stub), "name: couchbase4-query") {
+		t.Errorf("stub lacks the query job:\n%s", stub)
+	}
+
+	invalid := [][]map[string]interface{}{
+		{},
+		{{"name": "query", "services": []string{"n1ql"}, "instances": 1}},
+		{{"name": "data", "services": []string{"kv"}, "instances": 0}},
+		{{"name": "Data", "services": []string{"kv"}, "instances": 1}},
+		{{"name": "data", "services": []string{"kv"}, "instances": 1}, {"name": "data", "services": []string{"kv"}, "instances": 1}},
+	}
+	for _, topology := range invalid {
+		plan.Metadata = map[string]interface{}{"topology": topology}
+		if _, err = cbPlanProps(plan); err == nil {
+			t.Errorf("expected topology %v to be rejected", topology)
+		}
+	}
+}
+
+func TestTemplateProfiles(t *testing.T) {
+	templateDir, err := filepath.Abs(filepath.Join("..", "bosh-templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.BoshConfig{
+		TemplateDir: templateDir,
+		TemplateProfiles: map[string]*config.TemplateProfile{
+			"vsphere": {
+				Templates: []string{"base-cb-deploy.yml", "network-manual.yml", "resources-iaas.yml", "couchbase-job-defaults.yml"},
+				Vars: map[string]interface{}{
+					"network_name":                   "vm-network",
+					"network_range":                  "10.0.8.0/24",
+					"network_gateway":                "10.0.8.1",
+					"network_dns":                    []interface{}{"10.0.0.2"},
+					"network_reserved":               []interface{}{"10.0.8.2 - 10.0.8.9"},
+					"network_static":                 []interface{}{"10.0.8.10 - 10.0.8.40"},
+					"network_cloud_properties":       map[string]interface{}{"name": "VM Network"},
+					"stemcell_name":                  "bosh-vsphere-esxi-ubuntu-trusty-go_agent",
+					"stemcell_version":               3147,
+					"resource_pool_cloud_properties": map[string]interface{}{"cpu": 2, "ram": 4096, "disk": 10240},
+					"compilation_cloud_properties":   map[string]interface{}{"cpu": 4, "ram": 4096, "disk": 10240},
+				},
+			},
+			"empty": {},
+		},
+	}
+	c := &BoshClient{dProps: conf}
+	plan := func(metadata map[string]interface{}) *model.ServicePlan {
+		return &model.ServicePlan{Name: "p", Metadata: metadata}
+	}
+
+	tests := []struct {
+		config string
+		plan   *model.ServicePlan
+		want   []string
+	}{
+		// the builtin bosh-lite templates
+		{"", plan(nil), []string{"name: random", "bosh-warden-boshlite-ubuntu-trusty-go_agent"}},
+		// the profile of the BOSH config, or the plan's
+		{"vsphere", plan(nil), []string{"name: vm-network", "bosh-vsphere-esxi-ubuntu-trusty-go_agent", "ram: 4096", "range: 10.0.8.0/24"}},
+		{"", plan(map[string]interface{}{"templateProfile": "vsphere"}), []string{"name: vm-network"}},
+		{"vsphere", plan(map[string]interface{}{"templateProfile": "bosh-lite"}), []string{"name: random"}},
+	}
+	for _, test := range tests {
+		conf.TemplateProfile = test.config
+		props, err := cbPlanProps(test.plan)
+		if err != nil {
+			t.Fatalf("cbPlanProps: %v", err)
+		}
+		manifestYAML, err := c.buildManifest("cb-1", "uuid", props, 1)
+		if err != nil {
+			t.Errorf("profile %q, plan %v: %v", test.config, test.plan.Metadata, err)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(string(manifestYAML), want) {
+				t.Errorf("profile %q, plan %v: the manifest lacks %q:\n%s", test.config, test.plan.Metadata, want, manifestYAML)
+			}
+		}
+		if strings.Contains(string(manifestYAML), "vars:") {
+			t.Errorf("the vars were not pruned:\n%s", manifestYAML)
+		}
+	}
+
+	conf.TemplateProfile = ""
+	for _, profile := range []string{"missing", "empty"} {
+		catalog := &model.Catalog{Services: []model.Service{{Plans: []model.ServicePlan{*plan(map[string]interface{}{"templateProfile": profile})}}}}
+		if err = c.SetCatalog(catalog); err == nil {
+			t.Errorf("expected a plan with template profile %q to be rejected", profile)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"sync"
+)
+
+// The director runs the deploys and deletes of the broker as tasks, and a
+// burst of them overloads it (and the compilation VMs of bosh-lite).  So at
+// most max_director_tasks of them run at once; the others wait in a queue,
+// served round-robin across orgs so that one org's burst does not hold up
+// the others.
+
+// defaultMaxDirectorTasks is the number of director tasks the broker runs at
+// once unless configured otherwise.
+const defaultMaxDirectorTasks = 2
+
+// A directorScheduler hands out the director slots.
+type directorScheduler struct {
+	mutex sync.Mutex
+	// max is the number of slots; with a negative max there is no limit.
+	max     int
+	running int
+
+	// queues holds the waiting work of each org, and orgs the orgs with
+	// waiting work in the order they are served; next is the index in orgs
+	// of the org served next.
+	queues map[string][]*directorWork
+	orgs   []string
+	next   int
+
+	// owners records the org of each deployment, for its later work.
+	owners map[string]string
+}
+
+// A directorWork is work on a deployment waiting for a slot.
+type directorWork struct {
+	deploymentName string
+	slot           chan func()
+}
+
+// newDirectorScheduler returns a scheduler with max slots: the default if
+// max is 0, no limit if it is negative.
+func newDirectorScheduler(max int) *directorScheduler {
+	if max == 0 {
+		max = defaultMaxDirectorTasks
+	}
+	return &directorScheduler{
+		max:    max,
+		queues: make(map[string][]*directorWork),
+		owners: make(map[string]string),
+	}
+}
+
+// queue queues work on deploymentName for org, or for the org of the
+// deployment if org is "".  The returned channel receives the function that
+// frees the slot once the work may start: at once if a slot is free and
+// nothing waits.  It is closed if the work is cancelled.
+func (s *directorScheduler) queue(deploymentName, org string) <-chan func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if org == "" {
+		org = s.owners[deploymentName]
+	} else {
+		s.owners[deploymentName] = org
+	}
+	work := &directorWork{deploymentName: deploymentName, slot: make(chan func(), 1)}
+	if len(s.queues[org]) == 0 {
+		s.orgs = append(s.orgs, org)
+	}
+	s.queues[org] = append(s.queues[org], work)
+	s.dispatch()
+	return work.slot
+}
+
+// cancel removes the waiting work on deploymentName, returning whether there
+// was any.
+func (s *directorScheduler) cancel(deploymentName string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cancelled := false
+	for i := 0; i < len(s.orgs); i++ {
+		org := s.orgs[i]
+		var kept []*directorWork
+		for _, work := range s.queues[org] {
+			if work.deploymentName == deploymentName {
+				close(work.slot)
+				cancelled = true
+			} else {
+				kept = append(kept, work)
+			}
+		}
+		s.queues[org] = kept
+		if len(kept) == 0 {
+			s.removeOrg(i)
+			i--
+		}
+	}
+	return cancelled
+}
+
+// forget drops the org of a deleted deployment.
+func (s *directorScheduler) forget(deploymentName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.owners, deploymentName)
+}
+
+// position returns the place of the first waiting work on deploymentName in
+// the order the queue is served, from 1, or 0 if none waits.
+func (s *directorScheduler) position(deploymentName string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, work := range s.order() {
+		if work.deploymentName == deploymentName {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// order returns the waiting work in the order it will be served.
+func (s *directorScheduler) order() []*directorWork {
+	var order []*directorWork
+	served := make(map[string]int)
+	orgs := append([]string(nil), s.orgs...)
+	next := s.next
+	for len(orgs) > 0 {
+		if next >= len(orgs) {
+			next = 0
+		}
+		org := orgs[next]
+		order = append(order, s.queues[org][served[org]])
+		served[org]++
+		if served[org] == len(s.queues[org]) {
+			orgs = append(orgs[:next], orgs[next+1:]...)
+		} else {
+			next++
+		}
+	}
+	return order
+}
+
+// dispatch starts waiting work while there are free slots.  The caller
+// holds the mutex.
+func (s *directorScheduler) dispatch() {
+	for len(s.orgs) > 0 && (s.max < 0 || s.running < s.max) {
+		if s.next >= len(s.orgs) {
+			s.next = 0
+		}
+		org := s.orgs[s.next]
+		work := s.queues[org][0]
+		s.queues[org] = s.queues[org][1:]
+		if len(s.queues[org]) == 0 {
+			s.removeOrg(s.next)
+		} else {
+			s.next++
+		}
+		s.running++
+		var once sync.Once
+		work.slot <- func() {
+			once.Do(s.release)
+		}
+	}
+}
+
+// removeOrg removes the org at index i of orgs, which has no more work.
+func (s *directorScheduler) removeOrg(i int) {
+	delete(s.queues, s.orgs[i])
+	s.orgs = append(s.orgs[:i], s.orgs[i+1:]...)
+	if s.next > i {
+		s.next--
+	}
+}
+
+// release frees a slot.
+func (s *directorScheduler) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running--
+	s.dispatch()
+}
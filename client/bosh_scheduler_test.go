@@ -0,0 +1,153 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+)
+
+func TestDirectorScheduler(t *testing.T) {
+	s := newDirectorScheduler(2)
+	started := func(slot <-chan func()) func() {
+		select {
+		case release := <-slot:
+			return release
+		default:
+			return nil
+		}
+	}
+
+	release1 := started(s.queue("a-1", "a"))
+	release2 := started(s.queue("a-2", "a"))
+	if release1 == nil || release2 == nil {
+		t.Fatalf("the first two deployments should start at once")
+	}
+	a3 := s.queue("a-3", "a")
+	a4 := s.queue("a-4", "a")
+	b1 := s.queue("b-1", "b")
+	c1 := s.queue("c-1", "c")
+	// org a queued first, but b and c get their turn before a's second
+	for name, want := range map[string]int{"a-3": 1, "b-1": 2, "c-1": 3, "a-4": 4, "a-1": 0} {
+		if got := s.position(name); got != want {
+			t.Errorf("position(%v): got %d, want %d", name, got, want)
+		}
+	}
+	if started(a3) != nil {
+		t.Fatalf("a-3 started without a free slot")
+	}
+
+	release1()
+	release1() // releasing twice frees one slot
+	release3 := started(a3)
+	if release3 == nil || started(b1) != nil {
+		t.Fatalf("releasing a slot should start a-3 only")
+	}
+	if !s.cancel("b-1") || s.cancel("b-1") {
+		t.Errorf("cancel(b-1) should cancel it once")
+	}
+	if _, ok := <-b1; ok {
+		t.Errorf("a cancelled deployment got a slot")
+	}
+	release2()
+	if started(c1) == nil || started(a4) != nil {
+		t.Errorf("c-1 should start before a-4")
+	}
+	release3()
+	if started(a4) == nil {
+		t.Errorf("a-4 should start")
+	}
+
+	// later work on a deployment is queued for its org
+	unlimited := newDirectorScheduler(-1)
+	unlimited.queue("d-1", "d")
+	if unlimited.owners["d-1"] != "d" {
+		t.Errorf("owner of d-1: got %q", unlimited.owners["d-1"])
+	}
+	for i := 0; i < 10; i++ {
+		if started(unlimited.queue("d-1", "")) == nil {
+			t.Fatalf("without a limit, work should start at once")
+		}
+	}
+}
+
+func TestQueuedDeploy(t *testing.T) {
+	location := ""
+	c, stop := newFakeDirector(t, &config.BoshConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/deployments" {
+			if location != "" {
+				w.Header().Set("Location", location)
+			}
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	defer stop()
+	c.scheduler = newDirectorScheduler(1)
+	manifestYAML, err := c.allocateStaticIPs("cb-1", []byte(`networks:
+- name: services
+  subnets:
+  - range: 10.244.1.0/29
+    static: [10.244.1.2 - 10.244.1.4]
+jobs:
+- name: couchbase4
+  instances: 2
+  networks:
+  - name: services
+`))
+	if err != nil {
+		t.Fatalf("allocateStaticIPs: %v", err)
+	}
+	c.setErrandState("cb-1", &errandState{name: "smoke-test"})
+
+	// cb-1 waits behind cb-0
+	release := <-c.scheduler.queue("cb-0", "org")
+	slot := c.scheduler.queue("cb-1", "org")
+	c.setUnsent("cb-1", true)
+	creds, err := c.GetCredentials("cb-1", nil, nil, nil)
+	if creds != nil || err == nil || IsFatal(err) {
+		t.Errorf("GetCredentials while queued: %v %v", creds, err)
+	}
+
+	// the director does not say which task deploys it
+	release()
+	c.deployQueued("cb-1", manifestYAML, nil, slot)
+	creds, err = c.GetCredentials("cb-1", nil, nil, nil)
+	if creds != nil || !IsFatal(err) || !strings.Contains(err.Error(), "did not redirect to a task") {
+		t.Errorf("GetCredentials after a failed post: %v %v", creds, err)
+	}
+	if state, _ := c.GetInstanceState("cb-1"); state != "failed" {
+		t.Errorf("GetInstanceState after a failed post: got %v, want failed", state)
+	}
+	if c.errandState("cb-1") != nil {
+		t.Errorf("the errands of cb-1 are still pending")
+	}
+	allocations, err := c.loadIPAllocations()
+	if err != nil || len(allocations) != 0 {
+		t.Errorf("the static IPs of cb-1 were kept: %v %v", allocations, err)
+	}
+	if c.scheduler.position("cb-2") != 0 || !startsAtOnce(c.scheduler.queue("cb-2", "org")) {
+		t.Errorf("the director slot of cb-1 was kept")
+	}
+
+	location = "/deployments/cb-1"
+	if _, err = c.postManifest("cb-1", manifestYAML); err == nil {
+		t.Errorf("postManifest took %v for a task", location)
+	}
+	location = "/tasks/42"
+	if taskID, err := c.postManifest("cb-1", manifestYAML); taskID != 42 || err != nil {
+		t.Errorf("postManifest: got %v %v, want task 42", taskID, err)
+	}
+}
+
+// startsAtOnce reports whether slot has a director slot ready.
+func startsAtOnce(slot <-chan func()) bool {
+	select {
+	case release := <-slot:
+		return release != nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// maxFailureMessage is the longest error message of a director task put in
+// LastOperation.Description; the full one is in the stored event log.
+const maxFailureMessage = 300
+
+// A taskEvent is a line of the event output of a director task.  The events
+// of a stage report its progress; a task error has no stage.
+type taskEvent struct {
+	Stage string `json:"stage"`
+	Task  string `json:"task"`
+	State string `json:"state"`
+	Data  struct {
+		Error string `json:"error"`
+	} `json:"data"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// taskFailed reports whether a director task state is a failure.
+func taskFailed(state string) bool {
+	switch state {
+	case "error", "failed", "cancelled", "timeout":
+		return true
+	}
+	return false
+}
+
+// explainTaskFailure returns a short explanation of why a director task
+// failed: the stage that failed and the error, from the events of the task,
+// or its result when they say nothing.
+func explainTaskFailure(status directorTask, events []byte) string {
+	var failedStage, stageError, taskError string
+	for _, line := range bytes.Split(events, []byte("\n")) {
+		var event taskEvent
+		if json.Unmarshal(line, &event) != nil {
+			continue
+		}
+		if event.Error != nil {
+			taskError = event.Error.Message
+		}
+		if event.State == "failed" {
+			failedStage = event.Stage
+			if event.Task != "" {
+				failedStage += " " + event.Task
+			}
+			stageError = event.Data.Error
+		}
+	}
+
+	state := status.State
+	switch state {
+	case "error":
+		state = "failed"
+	case "timeout":
+		state = "timed out"
+	}
+	message := taskError
+	if message == "" {
+		message = stageError
+	}
+	if message == "" {
+		message = status.Result
+	}
+	if message == "" {
+		message = "the director gave no reason"
+	}
+	message = strings.TrimSpace(message)
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		message = message[:i]
+	}
+	if len(message) > maxFailureMessage {
+		message = message[:maxFailureMessage] + "..."
+	}
+	if failedStage != "" {
+		return fmt.Sprintf("BOSH task %d %v at %v: %v", status.ID, state, failedStage, message)
+	}
+	return fmt.Sprintf("BOSH task %d %v: %v", status.ID, state, message)
+}
+
+// taskEventsFile returns the path of the event log of a failed task.
+func (c *BoshClient) taskEventsFile(deploymentName string, taskID int) string {
+	return c.dProps.DataDir + string(os.PathSeparator) + fmt.Sprintf("%v-task-%d-events.log", deploymentName, taskID)
+}
+
+// taskFailure returns why the director task of deploymentName failed.  The
+// first time, it fetches the events of the task and stores them in DataDir
+// for operators.
+func (c *BoshClient) taskFailure(deploymentName string, status directorTask) string {
+	c.mutex.Lock()
+	description, ok := c.failures[status.ID]
+	c.mutex.Unlock()
+	if ok {
+		return description
+	}
+
+	var events []byte
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		utils.Logger.Printf("client.bosh.taskFailure: error creating bosh client: %v\n", err)
+	} else {
+		output, err := boshclient.GetTaskOutput(status.ID, "event")
+		if err != nil {
+			utils.Logger.Printf("client.bosh.taskFailure: could not fetch the events of task %d: %v\n", status.ID, err)
+		} else {
+			events = output
+		}
+	}
+	if events != nil {
+		fileName := c.taskEventsFile(deploymentName, status.ID)
+		utils.MkDir(c.dProps.DataDir)
+		err = utils.WriteFile(fileName, events)
+		if err != nil {
+			utils.Logger.Printf("client.bosh.taskFailure: could not store the events of task %d: %v\n", status.ID, err)
+		} else {
+			utils.Logger.Printf("client.bosh.taskFailure: the events of task %d of %v are in %v\n", status.ID, deploymentName, fileName)
+		}
+	}
+
+	description = explainTaskFailure(status, events)
+	utils.Logger.Printf("client.bosh.taskFailure: %v: %v\n", deploymentName, description)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.failures == nil {
+		c.failures = make(map[int]string)
+	}
+	c.failures[status.ID] = description
+	return description
+}
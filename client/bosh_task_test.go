@@ -0,0 +1,65 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+)
+
+func TestTaskFailure(t *testing.T) {
+	const events = `{"time":1,"stage":"Preparing deployment","tags":[],"total":1,"task":"Binding deployment","index":1,"state":"finished","progress":100}
+{"time":2,"stage":"Updating instance","tags":["couchbase4"],"total":3,"task":"couchbase4/0 (b7a0)","index":1,"state":"started","progress":0}
+{"time":9,"stage":"Updating instance","tags":["couchbase4"],"total":3,"task":"couchbase4/0 (b7a0)","index":1,"state":"failed","progress":100,"data":{"error":"not running after update"}}
+{"time":9,"error":{"code":400007,"message":"'couchbase4/0 (b7a0)' is not running after update.\nReview logs for failed jobs: couchbase"}}
+`
+	fetches := 0
+	c, stop := newFakeDirector(t, &config.BoshConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/42":
+			fmt.Fprint(w, `{"id": 42, "state": "error", "result": "'couchbase4/0 (b7a0)' is not running after update."}`)
+		case "/tasks/42/output":
+			if r.URL.Query().Get("type") != "event" {
+				http.NotFound(w, r)
+				return
+			}
+			fetches++
+			fmt.Fprint(w, events)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer stop()
+	c.tasks["cb-1"] = 42
+	state, err := c.GetInstanceState("cb-1")
+	if state != "failed" || err != nil {
+		t.Fatalf("GetInstanceState: %v %v", state, err)
+	}
+	want := "BOSH task 42 failed at Updating instance couchbase4/0 (b7a0): 'couchbase4/0 (b7a0)' is not running after update."
+	if got := c.GetInstanceDescription("cb-1"); got != want {
+		t.Errorf("GetInstanceDescription: got %q, want %q", got, want)
+	}
+	if err = c.waitForTask("cb-1", 42); err == nil || err.Error() != want {
+		t.Errorf("waitForTask: got %v, want %q", err, want)
+	}
+	if fetches != 1 {
+		t.Errorf("the events were fetched %d times, want once", fetches)
+	}
+	stored, err := ioutil.ReadFile(filepath.Join(c.dProps.DataDir, "cb-1-task-42-events.log"))
+	if err != nil || string(stored) != events {
+		t.Errorf("stored events: %q %v", stored, err)
+	}
+
+	// without events, the result of the task, if any, explains it
+	status := directorTask{ID: 7, State: "cancelled"}
+	if got := explainTaskFailure(status, nil); got != "BOSH task 7 cancelled: the director gave no reason" {
+		t.Errorf("explainTaskFailure: %q", got)
+	}
+	status = directorTask{ID: 7, State: "timeout", Result: "Task 7 time out"}
+	if got := explainTaskFailure(status, []byte("not json\n")); got != "BOSH task 7 timed out: Task 7 time out" {
+		t.Errorf("explainTaskFailure: %q", got)
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// On BOSH, the node certificate of a TLS instance goes into the deployment
+// manifest as properties of the Couchbase jobs, which write it to the
+// Couchbase inbox.  The certificate is kept next to the manifest so that
+// later deploys of the instance keep it.
+
+// certificatePath returns the file holding the certificate of a deployment.
+func (c *BoshClient) certificatePath(deploymentName string) string {
+	return c.dProps.DataDir + string(os.PathSeparator) + deploymentName + "-certificate.json"
+}
+
+// savedCertificate returns the certificate of a deployment, or nil if it
+// does not use TLS.
+func (c *BoshClient) savedCertificate(deploymentName string) (*ca.Certificate, error) {
+	path := c.certificatePath(deploymentName)
+	if !utils.Exists(path) {
+		return nil, nil
+	}
+	data, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cert ca.Certificate
+	err = json.Unmarshal(data, &cert)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return &cert, nil
+}
+
+// deployCertificate issues a certificate for the nodes at ips and deploys
+// it.  The nodes use it once told to reload their certificate.
+func (c *BoshClient) deployCertificate(deploymentName string, cbProps cbDefaultSettings, ips []string) error {
+	cert, err := issueCertificate(deploymentName, ips)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cert)
+	if err != nil {
+		return err
+	}
+	utils.MkDir(c.dProps.DataDir)
+	err = utils.WriteFile(c.certificatePath(deploymentName), data)
+	if err != nil {
+		return err
+	}
+	cbProps.certificate = cert
+	return c.redeploy(deploymentName, cbProps)
+}
+
+// removeCertificate deletes the saved certificate of a deployment.
+func (c *BoshClient) removeCertificate(deploymentName string) error {
+	err := os.Remove(c.certificatePath(deploymentName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RotateCertificate issues a new certificate for the nodes of the
+// deployment, deploys it and makes Couchbase load it.  The manifest is built
+// from the parameters of the instance, as when it was created.
+func (c *BoshClient) RotateCertificate(instanceID string, plan *model.ServicePlan, parameters interface{}, credential *model.Credential, progress ProgressFunc) error {
+	if credential.CACertificate == "" {
+		return fmt.Errorf("client.bosh.RotateCertificate: %v does not use TLS", instanceID)
+	}
+	if progress == nil {
+		progress = func(string) {}
+	}
+	cbProps, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		return err
+	}
+	ips, err := c.nodeIPs(instanceID, credential)
+	if err != nil {
+		return err
+	}
+	cbProps.instances = len(ips)
+
+	progress("deploying a new TLS certificate...")
+	err = c.deployCertificate(instanceID, cbProps, ips)
+	if err != nil {
+		return err
+	}
+	return reloadCertificates(credential, ips)
+}
+
+// nodeIPs returns the addresses of the VMs of a deployment, starting with
+// the node the credentials use.
+func (c *BoshClient) nodeIPs(deploymentName string, credential *model.Credential) ([]string, error) {
+	boshclient, err := c.createBoshClient()
+	if err != nil {
+		return nil, err
+	}
+	vmStatuses, err := boshclient.FetchVMsStatus(deploymentName)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list the VMs of %v: %v", deploymentName, err)
+	}
+	rootIP := nodeIP(credential.URI)
+	ips := []string{rootIP}
+	for _, vmStat := range vmStatuses {
+		if len(vmStat.IPs) > 0 && vmStat.IPs[0] != rootIP {
+			ips = append(ips, vmStat.IPs[0])
+		}
+	}
+	return ips, nil
+}
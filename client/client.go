@@ -6,12 +6,20 @@ import (
 
 // A Client implements the connection to some type of IaaS to provide services via a service broker.
 type Client interface {
-	CreateInstance(parameters interface{}) (string, error)
+	// CreateInstance and DeleteInstance take the org of the instance, whose
+	// requests may be queued behind those of other orgs.
+	CreateInstance(plan *model.ServicePlan, parameters interface{}, orgID string) (string, error)
 	GetInstanceState(instanceID string) (string, error)
-	DeleteInstance(instanceID string) error
+	// GetInstanceDescription explains the state GetInstanceState returned,
+	// e.g. why the instance failed, or returns "" if it cannot.
+	GetInstanceDescription(instanceID string) string
+	DeleteInstance(instanceID string, orgID string) error
+	UpdateInstance(instanceID string, plan *model.ServicePlan, parameters interface{}, credential *model.Credential, progress ProgressFunc) error
+	// RotateCertificate gives the nodes of a TLS instance a new certificate.
+	RotateCertificate(instanceID string, plan *model.ServicePlan, parameters interface{}, credential *model.Credential, progress ProgressFunc) error
 
 	// new interface
-	GetCredentials(instanceID string) (*model.Credential, error)
+	GetCredentials(instanceID string, plan *model.ServicePlan, parameters interface{}, progress ProgressFunc) (*model.Credential, error)
 	RemoveCredentials(instanceID string, bindingID string) error
 
 	// old SSH to a VM interface
@@ -21,4 +29,45 @@ type Client interface {
 	SetCatalog(catalog *model.Catalog) error
 	GetCatalog() *model.Catalog
 	IsValidPlan(planName string) bool
+	// ValidateParameters and ValidateUpdate refuse the parameters that
+	// CreateInstance and UpdateInstance could not apply, before any work is
+	// started.
+	ValidateParameters(plan *model.ServicePlan, parameters interface{}) error
+	ValidateUpdate(plan *model.ServicePlan, parameters interface{}) error
+}
+
+// A ProgressFunc is told how a long running step, such as a rebalance, is
+// getting on.  The description is suitable for LastOperation.Description.
+type ProgressFunc func(description string)
+
+// A FatalError is a setup failure that retrying cannot fix, e.g. a failed
+// rebalance after the nodes have been given new credentials.
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string {
+	return e.Err.Error()
+}
+
+// IsFatal reports whether err is a FatalError.
+func IsFatal(err error) bool {
+	_, ok := err.(*FatalError)
+	return ok
+}
+
+// A QueuedError reports a setup waiting for its turn, e.g. a deploy waiting
+// for a director slot.  The time it waits does not count as setting up.
+type QueuedError struct {
+	Err error
+}
+
+func (e *QueuedError) Error() string {
+	return e.Err.Error()
+}
+
+// IsQueued reports whether err is a QueuedError.
+func IsQueued(err error) bool {
+	_, ok := err.(*QueuedError)
+	return ok
 }
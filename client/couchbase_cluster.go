@@ -0,0 +1,211 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// Couchbase limits for the auto-failover timeout, in seconds.
+const (
+	minAutoFailoverTimeout = 5
+	maxAutoFailoverTimeout = 3600
+)
+
+// Ways to place the nodes of an instance in server groups.
+const (
+	serverGroupsByAZ    = "az"
+	serverGroupsByIndex = "index"
+)
+
+func (props cbDefaultSettings) validateCluster() error {
+	if props.autoFailoverTimeout != nil {
+		timeout := *props.autoFailoverTimeout
+		if timeout != 0 && (timeout < minAutoFailoverTimeout || timeout > maxAutoFailoverTimeout) {
+			return fmt.Errorf("autoFailoverTimeout must be 0 (disabled) or between %d and %d seconds, not %d",
+				minAutoFailoverTimeout, maxAutoFailoverTimeout, timeout)
+		}
+	}
+
+	switch props.serverGroups {
+	case "":
+	case serverGroupsByAZ, serverGroupsByIndex:
+		if props.nodes() < 2 {
+			return fmt.Errorf("serverGroups need at least 2 nodes")
+		}
+		if props.serverGroups == serverGroupsByIndex && (props.serverGroupCount < 2 || props.serverGroupCount > props.nodes()) {
+			return fmt.Errorf("serverGroupCount must be between 2 and the number of nodes (%d), not %d", props.nodes(), props.serverGroupCount)
+		}
+	default:
+		return fmt.Errorf("unknown serverGroups %q (use %v or %v)", props.serverGroups, serverGroupsByAZ, serverGroupsByIndex)
+	}
+
+	switch props.indexStorageMode {
+	case "", "forestdb", "memory_optimized", "plasma":
+	default:
+		return fmt.Errorf("unknown indexStorageMode %q (use forestdb, memory_optimized or plasma)", props.indexStorageMode)
+	}
+
+	_, err := settingsForm(props.querySettings)
+	if err != nil {
+		return fmt.Errorf("query settings: %v", err)
+	}
+	_, err = settingsForm(props.xdcrSettings)
+	if err != nil {
+		return fmt.Errorf("xdcr settings: %v", err)
+	}
+	return nil
+}
+
+// nodes returns the number of nodes of the instance.
+func (props cbDefaultSettings) nodes() int {
+	if props.topology == nil {
+		return props.instances
+	}
+	nodes := 0
+	for _, group := range props.topology {
+		nodes += group.Instances
+	}
+	return nodes
+}
+
+// settingsForm returns settings as form values.  Only strings, numbers and
+// booleans are allowed.
+func settingsForm(settings map[string]interface{}) (url.Values, error) {
+	form := url.Values{}
+	for name, value := range settings {
+		switch v := value.(type) {
+		case string:
+			form.Set(name, v)
+		case bool:
+			form.Set(name, strconv.FormatBool(v))
+		case float64:
+			form.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case int:
+			form.Set(name, strconv.Itoa(v))
+		default:
+			return nil, fmt.Errorf("%v must be a string, number or boolean", name)
+		}
+	}
+	return form, nil
+}
+
+// configureClusterSettings applies the plan's cluster-wide settings once the
+// nodes have joined the cluster cb is connected to.
+func configureClusterSettings(cb *admin.Client, cbProps cbDefaultSettings, nodes []clusterNode, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	if cbProps.autoFailoverTimeout != nil {
+		timeout := *cbProps.autoFailoverTimeout
+		err := cb.SetAutoFailover(timeout > 0, timeout)
+		if err != nil {
+			return err
+		}
+	}
+	if cbProps.indexStorageMode != "" && cbProps.runsService(admin.ServiceIndex) {
+		err := cb.SetIndexSettings(cbProps.indexStorageMode)
+		if err != nil {
+			return err
+		}
+	}
+	if len(cbProps.querySettings) > 0 {
+		form, _ := settingsForm(cbProps.querySettings)
+		err := cb.SetQuerySettings(form)
+		if err != nil {
+			return err
+		}
+	}
+	if len(cbProps.xdcrSettings) > 0 {
+		form, _ := settingsForm(cbProps.xdcrSettings)
+		err := cb.SetReplicationSettings(form)
+		if err != nil {
+			return err
+		}
+	}
+	if cbProps.serverGroups != "" {
+		progress("configuring server groups...")
+		_, err := configureServerGroups(cb, cbProps, nodes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serverGroup returns the name of the server group node belongs in.
+func (props cbDefaultSettings) serverGroup(node clusterNode) (string, error) {
+	switch props.serverGroups {
+	case serverGroupsByAZ:
+		if node.az == "" {
+			return "", fmt.Errorf("node %v has no availability zone to take its server group from", node.ip)
+		}
+		return node.az, nil
+	case serverGroupsByIndex:
+		return fmt.Sprintf("group-%d", node.index%props.serverGroupCount+1), nil
+	}
+	return "", fmt.Errorf("unknown serverGroups %q", props.serverGroups)
+}
+
+// configureServerGroups places the nodes in the server groups the plan asks
+// for, creating the groups as needed.  It reports whether any node moved:
+// buckets only follow the new layout after a rebalance.
+func configureServerGroups(cb *admin.Client, cbProps cbDefaultSettings, nodes []clusterNode) (bool, error) {
+	current, err := cb.ServerGroups()
+	if err != nil {
+		return false, err
+	}
+	// nodes are known by address here, and by otpNode name to Couchbase
+	otpNodes := make(map[string]string)
+	placed := make(map[string]string)
+	existing := make(map[string]bool)
+	for _, group := range current.Groups {
+		existing[group.Name] = true
+		for _, node := range group.Nodes {
+			ip := strings.Split(node.Hostname, ":")[0]
+			otpNodes[ip] = node.OTPNode
+			placed[node.OTPNode] = group.Name
+		}
+	}
+
+	members := make(map[string][]string)
+	moved := false
+	for _, node := range nodes {
+		group, err := cbProps.serverGroup(node)
+		if err != nil {
+			return false, err
+		}
+		otpNode, ok := otpNodes[node.ip]
+		if !ok {
+			return false, fmt.Errorf("node %v is not in the cluster", node.ip)
+		}
+		members[group] = append(members[group], otpNode)
+		if placed[otpNode] != group {
+			moved = true
+		}
+	}
+	if !moved {
+		return false, nil
+	}
+
+	var names []string
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !existing[name] {
+			err = cb.CreateServerGroup(name)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	utils.Logger.Printf("client.configureServerGroups: %v\n", members)
+	return true, cb.MoveNodes(members)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestClusterSettings(t *testing.T) {
+	plan := &model.ServicePlan{
+		Name: "production",
+		Metadata: map[string]interface{}{
+			"instances": 3,
+			"cluster": map[string]interface{}{
+				"autoFailoverTimeout": 30,
+				"serverGroups":        "az",
+				"indexStorageMode":    "memory_optimized",
+				"query":               map[string]interface{}{"queryPipelineBatch": 32},
+				"xdcr":                map[string]interface{}{"checkpointInterval": 600, "logLevel": "Info"},
+			},
+		},
+	}
+	props, err := cbPlanProps(plan)
+	if err != nil {
+		t.Fatalf("cbPlanProps: %v", err)
+	}
+
+	fake := admintest.NewServer()
+	defer fake.Close()
+	defer func(interval time.Duration) { rebalancePollInterval = interval }(rebalancePollInterval)
+	rebalancePollInterval = time.Millisecond
+	cb, err := configureCouchbaseNode(fake.URL, props, props.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	nodes := []clusterNode{
+		{ip: "127.0.0.1", az: "z1", index: 0},
+		{ip: "10.244.1.6", az: "z2", index: 1},
+		{ip: "10.244.1.10", az: "z1", index: 2},
+	}
+	if err = configureCouchbaseCluster(cb, nodes[1:], "user1", "password1", nil); err != nil {
+		t.Fatalf("configureCouchbaseCluster: %v", err)
+	}
+	if err = configureClusterSettings(cb, props, nodes, nil); err != nil {
+		t.Fatalf("configureClusterSettings: %v", err)
+	}
+
+	if s := fake.Settings["autoFailover"]; s["enabled"] != "true" || s["timeout"] != "30" {
+		t.Errorf("unexpected auto-failover settings %v", s)
+	}
+	if s := fake.Settings["indexes"]; s["storageMode"] != "memory_optimized" {
+		t.Errorf("unexpected index settings %v", s)
+	}
+	if s := fake.Settings["querySettings"]; s["queryPipelineBatch"] != "32" {
+		t.Errorf("unexpected query settings %v", s)
+	}
+	if s := fake.Settings["replications"]; s["checkpointInterval"] != "600" || s["logLevel"] != "Info" {
+		t.Errorf("unexpected xdcr settings %v", s)
+	}
+	for i, group := range []string{"z1", "z2", "z1"} {
+		if fake.Nodes[i].Group != group {
+			t.Errorf("node %v in group %q, expected %q", fake.Nodes[i].OTPNode, fake.Nodes[i].Group, group)
+		}
+	}
+
+	// switching to index groups moves the nodes once
+	props.serverGroups, props.serverGroupCount = serverGroupsByIndex, 2
+	if moved, err := configureServerGroups(cb, props, nodes); err != nil || !moved {
+		t.Errorf("expected nodes to move to index groups: %v, %v", moved, err)
+	}
+	if moved, err := configureServerGroups(cb, props, nodes); err != nil || moved {
+		t.Errorf("expected nodes to stay in their groups: %v, %v", moved, err)
+	}
+	if fake.Nodes[1].Group != "group-2" || fake.Nodes[2].Group != "group-1" {
+		t.Errorf("unexpected index groups %q %q", fake.Nodes[1].Group, fake.Nodes[2].Group)
+	}
+
+	invalid := []map[string]interface{}{
+		{"autoFailoverTimeout": 2},
+		{"serverGroups": "rack"},
+		{"serverGroups": "index", "serverGroupCount": 4},
+		{"indexStorageMode": "disk"},
+		{"query": map[string]interface{}{"queryTmpSpaceDir": []string{"/tmp"}}},
+	}
+	for _, cluster := range invalid {
+		plan.Metadata = map[string]interface{}{"instances": 3, "cluster": cluster}
+		if _, err = cbPlanProps(plan); err == nil {
+			t.Errorf("expected cluster settings %v to be rejected", cluster)
+		}
+	}
+	plan.Metadata = map[string]interface{}{"cluster": map[string]interface{}{"serverGroups": "az"}}
+	if _, err = cbPlanProps(plan); err == nil {
+		t.Errorf("expected server groups to be rejected for a single node")
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// defaultSeedDataPath is where seed data sets live unless configured otherwise.
+const defaultSeedDataPath = "data/seed"
+
+var validSeedName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// How often and for how long installSampleBuckets polls the loading tasks.
+var (
+	sampleLoadPollInterval = 5 * time.Second
+	sampleLoadTimeout      = 15 * time.Minute
+)
+
+func (props cbDefaultSettings) validateSampleData() error {
+	if len(props.sampleBuckets) == 0 && props.seed == "" {
+		return nil
+	}
+	if !props.sampleData {
+		return fmt.Errorf("this plan does not allow sample_buckets or seed data")
+	}
+
+	for i, name := range props.sampleBuckets {
+		if !contains(admin.SampleBuckets, name) {
+			return fmt.Errorf("unknown sample bucket %q (use one of %v)", name, admin.SampleBuckets)
+		}
+		if contains(props.sampleBuckets[:i], name) || name == props.bucketName {
+			return fmt.Errorf("sample bucket %q is requested twice", name)
+		}
+	}
+	// each sample gets its own bucket next to the service bucket
+	needed := props.bucketRAMQuota + minBucketRAMQuota*len(props.sampleBuckets)
+	if needed > props.ramQuota {
+		return fmt.Errorf("the service bucket and %d sample buckets need %d MB, more than the ramQuota of %d MB",
+			len(props.sampleBuckets), needed, props.ramQuota)
+	}
+
+	if props.seed != "" {
+		if props.dbType == admin.BucketMemcached {
+			return fmt.Errorf("memcached buckets cannot be seeded")
+		}
+		if !validSeedName.MatchString(props.seed) {
+			return fmt.Errorf("seed %q must be letters, digits, '_' and '-'", props.seed)
+		}
+		_, err := os.Stat(seedFile(props.seed))
+		if err != nil {
+			return fmt.Errorf("unknown seed data set %q", props.seed)
+		}
+	}
+	return nil
+}
+
+// seedFile returns the path of the named seed data set.
+func seedFile(name string) string {
+	dir := config.GetConfig().SeedDataPath
+	if dir == "" {
+		dir = defaultSeedDataPath
+	}
+	if !filepath.IsAbs(dir) {
+		dir = utils.GetPath([]string{dir})
+	}
+	return filepath.Join(dir, name+".jsonl")
+}
+
+// loadCouchbaseData installs the requested sample buckets and loads the seed
+// data set into the service bucket, reporting progress to progress.
+func loadCouchbaseData(cb *admin.Client, cbProps cbDefaultSettings, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	if len(cbProps.sampleBuckets) > 0 {
+		err := installSampleBuckets(cb, cbProps.sampleBuckets, progress)
+		if err != nil {
+			return err
+		}
+	}
+	if cbProps.seed != "" {
+		return loadSeedData(cb, cbProps.bucketName, cbProps.seed, progress)
+	}
+	return nil
+}
+
+// installSampleBuckets installs the sample buckets and waits until Couchbase
+// has finished loading them.
+func installSampleBuckets(cb *admin.Client, names []string, progress ProgressFunc) error {
+	progress(fmt.Sprintf("installing sample buckets %v...", strings.Join(names, ", ")))
+	err := cb.InstallSampleBuckets(names)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(sampleLoadTimeout)
+	for {
+		tasks, err := cb.Tasks()
+		if err != nil {
+			return err
+		}
+		var loading []string
+		for _, task := range tasks {
+			if task.Type == admin.TaskLoadingSampleBucket && task.Status == "running" {
+				loading = append(loading, task.Bucket)
+			}
+		}
+		if len(loading) == 0 {
+			break
+		}
+		progress(fmt.Sprintf("loading sample buckets %v...", strings.Join(loading, ", ")))
+		if time.Now().After(deadline) {
+			return fmt.Errorf("sample buckets %v still loading after %v", loading, sampleLoadTimeout)
+		}
+		time.Sleep(sampleLoadPollInterval)
+	}
+
+	// a sample that failed to load leaves no bucket behind
+	for _, name := range names {
+		_, err = cb.GetBucket(name)
+		if err != nil {
+			return fmt.Errorf("sample bucket %v was not installed: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// seedProgressInterval is how many documents loadSeedData stores between
+// progress reports.
+const seedProgressInterval = 100
+
+// loadSeedData stores the documents of the named seed data set in bucket.
+// The set is a JSON-lines file: one JSON object per line, stored under its
+// "id" field if it is a string, or under <seed>::<line number> otherwise.
+func loadSeedData(cb *admin.Client, bucket, seed string, progress ProgressFunc) error {
+	file, err := os.Open(seedFile(seed))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	progress(fmt.Sprintf("loading seed data %v...", seed))
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 20*1024*1024)
+	line, loaded := 0, 0
+	for scanner.Scan() {
+		line++
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		var doc map[string]interface{}
+		err = json.Unmarshal([]byte(data), &doc)
+		if err != nil {
+			return fmt.Errorf("seed %v line %d: %v", seed, line, err)
+		}
+		id, ok := doc["id"].(string)
+		if !ok || id == "" {
+			id = fmt.Sprintf("%s::%d", seed, line)
+		}
+		err = cb.SetDocument(bucket, id, []byte(data))
+		if err != nil {
+			return fmt.Errorf("seed %v line %d: %v", seed, line, err)
+		}
+		loaded++
+		if loaded%seedProgressInterval == 0 {
+			progress(fmt.Sprintf("loading seed data %v: %d documents loaded", seed, loaded))
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("seed %v: %v", seed, err)
+	}
+	utils.Logger.Printf("client.loadSeedData: loaded %d documents from %v into %v\n", loaded, seed, bucket)
+	return nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/ssdowd/couchbasebroker/config"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestLoadSampleData(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.SampleLoadSteps = 2
+	defer func(interval time.Duration) { sampleLoadPollInterval = interval }(sampleLoadPollInterval)
+	sampleLoadPollInterval = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.GetConfig().SeedDataPath = dir
+	defer func() { config.GetConfig().SeedDataPath = "" }()
+	seed := `{"id": "product::1", "name": "cup"}
+
+{"name": "no id"}
+`
+	if err = ioutil.WriteFile(filepath.Join(dir, "shop.jsonl"), []byte(seed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &model.ServicePlan{
+		Name:     "development",
+		Metadata: map[string]interface{}{"bucketRamQuota": 468, "sampleData": true},
+	}
+	parameters := map[string]interface{}{"sample_buckets": []string{"beer-sample"}, "seed": "shop"}
+	props, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		t.Fatalf("cbInstanceProps: %v", err)
+	}
+	cb, err := configureCouchbaseNode(fake.URL, props, props.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	if _, err = createCouchbaseBucket(cb, props, "user1", "password1", "saslpw"); err != nil {
+		t.Fatalf("createCouchbaseBucket: %v", err)
+	}
+
+	var reports []string
+	err = loadCouchbaseData(cb, props, func(description string) {
+		reports = append(reports, description)
+	})
+	if err != nil {
+		t.Fatalf("loadCouchbaseData: %v", err)
+	}
+	if fake.Buckets["beer-sample"] == nil {
+		t.Errorf("sample bucket not installed")
+	}
+	docs := fake.Buckets[props.bucketName].Docs
+	if len(docs) != 2 || docs["product::1"] == "" || docs["shop::3"] == "" {
+		t.Errorf("unexpected seed documents %v", docs)
+	}
+	if len(reports) < 3 || reports[1] != "loading sample buckets beer-sample..." {
+		t.Errorf("unexpected progress reports %q", reports)
+	}
+
+	invalid := []map[string]interface{}{
+		{"sample_buckets": []string{"bogus-sample"}},
+		{"sample_buckets": []string{"beer-sample", "beer-sample"}},
+		{"sample_buckets": []string{"beer-sample", "travel-sample", "gamesim-sample", "beer-sample"}},
+		{"seed": "missing"},
+		{"seed": "../shop"},
+		{"seed": "shop", "bucket": map[string]interface{}{"bucketType": "memcached"}},
+	}
+	for _, params := range invalid {
+		if _, err = cbInstanceProps(plan, params); err == nil {
+			t.Errorf("expected parameters %v to be rejected", params)
+		}
+	}
+	if _, err = cbInstanceProps(&model.ServicePlan{Name: "plain"}, parameters); err == nil {
+		t.Errorf("expected sample data to be rejected by a plan without sampleData")
+	}
+}
@@ -1,21 +1,620 @@
 package client
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+// Couchbase refuses memory quotas below these (in MB).
+const (
+	minRAMQuota       = 256
+	minIndexRAMQuota  = 256
+	minBucketRAMQuota = 100
+)
+
 type cbDefaultSettings struct {
-	adminUser     string
-	adminPass     string
-	ramQuota      int
-	indexRAMQuota int
-	dbType        string
-	port          int
+	adminUser      string
+	adminPass      string
+	ramQuota       int
+	indexRAMQuota  int
+	dbType         string
+	port           int
+	bucketName     string
+	bucketRAMQuota int
+	instances      int
+	resourcePool   string
+	services       []string
+	topology       []model.NodeGroup
+
+	// bucket settings left to Couchbase unless set
+	replicaNumber      *int
+	evictionPolicy     string
+	compressionMode    string
+	maxTTL             int
+	conflictResolution string
+	flushEnabled       bool
+	scope              string
+	collections        []string
+
+	indexes []model.IndexDefinition
+
+	sampleData    bool
+	sampleBuckets []string
+	seed          string
+
+	// minVersion is the oldest Couchbase release the plan supports, and
+	// serverVersion the release of the instance, once it is known.
+	minVersion    admin.Version
+	serverVersion admin.Version
+
+	// cluster-wide settings, from the plan only
+	autoFailoverTimeout *int
+	serverGroups        string
+	serverGroupCount    int
+	indexStorageMode    string
+	querySettings       map[string]interface{}
+	xdcrSettings        map[string]interface{}
+
+	// tls is set when the instance gets certificates: always with a
+	// required tlsMode, by the tls parameter with an optional one.  On BOSH,
+	// certificate is the one to put in the deployment manifest.
+	tlsMode         string
+	tls             bool
+	encryptionLevel string
+	certificate     *ca.Certificate
+
+	// cloudConfig, if set, selects a BOSH v2 manifest using these
+	// cloud-config names (see bosh_cloud_config.go).
+	cloudConfig *model.CloudConfigSettings
+
+	// templateProfile, if set, names the template profile of the
+	// deployment manifests (see bosh_manifest.go).
+	templateProfile string
+
+	// errands run after the deployment of a new instance (see
+	// bosh_errands.go).
+	errands []model.ErrandSettings
+}
+
+// couchbaseJobName is the BOSH job that runs Couchbase.  Each group of a plan
+// topology gets its own job, named couchbaseJobName-<group>.
+const couchbaseJobName = "couchbase4"
+
+// A nodeGroup is a set of nodes deployed as one BOSH job, all running the same services.
+type nodeGroup struct {
+	jobName   string
+	services  []string
+	instances int
+
+	// the cloud-config names of a v2 manifest
+	vmType             string
+	persistentDiskType string
 }
 
 func cbDefaultProps() cbDefaultSettings {
 	return cbDefaultSettings{
-		adminUser:     "Administrator",
-		adminPass:     "password",
-		ramQuota:      768,
-		indexRAMQuota: 256,
-		dbType:        "couchbase",
-		port:          8091,
+		adminUser:      "Administrator",
+		adminPass:      "password",
+		ramQuota:       768,
+		indexRAMQuota:  256,
+		dbType:         "couchbase",
+		port:           8091,
+		bucketName:     "cfdefault",
+		bucketRAMQuota: 768,
+		instances:      1,
+		resourcePool:   "default",
+		services:       admin.DefaultServices,
+	}
+}
+
+// cbPlanProps returns the defaults overridden by the settings in the plan
+// metadata, or an error if the resulting combination is invalid.
+func cbPlanProps(plan *model.ServicePlan) (cbDefaultSettings, error) {
+	props := cbDefaultProps()
+	if plan == nil {
+		return props, nil
+	}
+	settings, err := plan.Settings()
+	if err != nil {
+		return props, fmt.Errorf("plan %v: invalid metadata: %v", plan.Name, err)
+	}
+
+	if settings.RAMQuota != 0 {
+		props.ramQuota = settings.RAMQuota
+		// unless told otherwise, the bucket gets all of the data RAM
+		props.bucketRAMQuota = settings.RAMQuota
+	}
+	if settings.IndexRAMQuota != 0 {
+		props.indexRAMQuota = settings.IndexRAMQuota
+	}
+	if settings.BucketRAMQuota != 0 {
+		props.bucketRAMQuota = settings.BucketRAMQuota
+	}
+	if settings.Instances != 0 {
+		props.instances = settings.Instances
+	}
+	if settings.ResourcePool != "" {
+		props.resourcePool = settings.ResourcePool
+	}
+	if len(settings.Services) > 0 {
+		props.services = settings.Services
+	}
+	props.topology = settings.Topology
+	props.applyBucketSettings(settings.Bucket)
+	props.indexes = settings.Indexes
+	props.sampleData = settings.SampleData
+	props.applyClusterSettings(settings.Cluster)
+	if settings.TLS != nil {
+		props.tlsMode = settings.TLS.Mode
+		props.tls = settings.TLS.Mode == tlsRequired
+		props.encryptionLevel = settings.TLS.EncryptionLevel
+	}
+	props.cloudConfig = settings.CloudConfig
+	props.templateProfile = settings.TemplateProfile
+	props.errands = settings.Errands
+	if settings.MinVersion != "" {
+		props.minVersion, err = admin.ParseVersion(settings.MinVersion)
+		if err != nil {
+			return props, fmt.Errorf("plan %v: minVersion: %v", plan.Name, err)
+		}
+	}
+
+	err = props.validate()
+	if err != nil {
+		return props, fmt.Errorf("plan %v: %v", plan.Name, err)
+	}
+	return props, nil
+}
+
+// cbInstanceProps returns the plan settings overridden by the parameters of a
+// provision request, or an error if the parameters do not fit the plan.
+func cbInstanceProps(plan *model.ServicePlan, parameters interface{}) (cbDefaultSettings, error) {
+	props, err := cbPlanProps(plan)
+	if err != nil {
+		return props, err
+	}
+	params, err := model.DecodeParameters(parameters)
+	if err != nil {
+		return props, fmt.Errorf("invalid parameters: %v", err)
+	}
+
+	if params.Instances != 0 {
+		if props.topology != nil {
+			return props, errors.New("the instances parameter cannot be used with a plan that declares a topology")
+		}
+		props.instances = params.Instances
+	}
+	props.applyBucketSettings(params.Bucket)
+	if params.Indexes != nil {
+		props.indexes = params.Indexes
+	}
+	props.sampleBuckets = params.SampleBuckets
+	props.seed = params.Seed
+	if params.TLS != nil {
+		props.tls = *params.TLS
+	}
+	if params.ReplicateFrom != "" && props.dbType == admin.BucketMemcached {
+		return props, errors.New("invalid parameters: memcached buckets cannot be the target of replicate_from")
+	}
+
+	err = props.validate()
+	if err != nil {
+		return props, fmt.Errorf("invalid parameters: %v", err)
+	}
+	return props, nil
+}
+
+// applyBucketSettings overrides the bucket settings with those that are set in b.
+func (props *cbDefaultSettings) applyBucketSettings(b *model.BucketSettings) {
+	if b == nil {
+		return
+	}
+	if b.Name != "" {
+		props.bucketName = b.Name
+	}
+	if b.BucketType != "" {
+		props.dbType = b.BucketType
+	}
+	if b.ReplicaNumber != nil {
+		replicas := *b.ReplicaNumber
+		props.replicaNumber = &replicas
+	}
+	if b.EvictionPolicy != "" {
+		props.evictionPolicy = b.EvictionPolicy
+	}
+	if b.CompressionMode != "" {
+		props.compressionMode = b.CompressionMode
+	}
+	if b.MaxTTL != nil {
+		props.maxTTL = *b.MaxTTL
+	}
+	if b.ConflictResolutionType != "" {
+		props.conflictResolution = b.ConflictResolutionType
+	}
+	if b.FlushEnabled != nil {
+		props.flushEnabled = *b.FlushEnabled
+	}
+	if b.Scope != "" {
+		props.scope = b.Scope
+	}
+	if b.Collections != nil {
+		props.collections = b.Collections
+	}
+}
+
+// applyClusterSettings sets the cluster-wide settings from c.
+func (props *cbDefaultSettings) applyClusterSettings(c *model.ClusterSettings) {
+	if c == nil {
+		return
+	}
+	props.autoFailoverTimeout = c.AutoFailoverTimeout
+	props.serverGroups = c.ServerGroups
+	props.serverGroupCount = c.ServerGroupCount
+	props.indexStorageMode = c.IndexStorageMode
+	props.querySettings = c.Query
+	props.xdcrSettings = c.XDCR
+}
+
+func (props cbDefaultSettings) validate() error {
+	if props.ramQuota < minRAMQuota {
+		return fmt.Errorf("ramQuota %d is below the Couchbase minimum of %d MB", props.ramQuota, minRAMQuota)
+	}
+	if props.indexRAMQuota < minIndexRAMQuota {
+		return fmt.Errorf("indexRamQuota %d is below the Couchbase minimum of %d MB", props.indexRAMQuota, minIndexRAMQuota)
+	}
+	if props.bucketRAMQuota < minBucketRAMQuota {
+		return fmt.Errorf("bucketRamQuota %d is below the Couchbase minimum of %d MB", props.bucketRAMQuota, minBucketRAMQuota)
+	}
+	if props.bucketRAMQuota > props.ramQuota {
+		return fmt.Errorf("bucketRamQuota %d exceeds ramQuota %d", props.bucketRAMQuota, props.ramQuota)
+	}
+	if props.instances < 1 {
+		return fmt.Errorf("instances must be at least 1, not %d", props.instances)
+	}
+	if props.resourcePool == "" {
+		return fmt.Errorf("resourcePool must not be empty")
+	}
+	if props.topology == nil {
+		err := validateServices(props.services)
+		if err != nil {
+			return err
+		}
+		err = requireDataService(props.services)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := validateTopology(props.topology)
+		if err != nil {
+			return err
+		}
+	}
+	err := props.validateBucket()
+	if err != nil {
+		return err
+	}
+	err = props.validateIndexes()
+	if err != nil {
+		return err
+	}
+	err = props.validateSampleData()
+	if err != nil {
+		return err
+	}
+	err = props.validateTLS()
+	if err != nil {
+		return err
+	}
+	err = props.validateCloudConfig()
+	if err != nil {
+		return err
+	}
+	err = props.validateErrands()
+	if err != nil {
+		return err
+	}
+	return props.validateCluster()
+}
+
+// Couchbase limits for bucket settings.
+const (
+	maxReplicas  = 3
+	maxBucketTTL = 2147483647
+)
+
+var validBucketName = regexp.MustCompile(`^[A-Za-z0-9_%-][A-Za-z0-9._%-]{0,99}$`)
+
+// evictionPolicies lists the eviction policies allowed for each bucket type.
+var evictionPolicies = map[string][]string{
+	admin.BucketCouchbase: {"valueOnly", "fullEviction"},
+	admin.BucketEphemeral: {"noEviction", "nruEviction"},
+}
+
+func (props cbDefaultSettings) validateBucket() error {
+	if !validBucketName.MatchString(props.bucketName) {
+		return fmt.Errorf("bucket name %q must be at most 100 letters, digits, '.', '_', '%%' or '-', and not start with '.'", props.bucketName)
+	}
+
+	switch props.dbType {
+	case admin.BucketCouchbase, admin.BucketEphemeral:
+	case admin.BucketMemcached:
+		// memcached buckets are plain caches: none of the settings below apply
+		if props.replicaNumber != nil || props.evictionPolicy != "" || props.compressionMode != "" ||
+			props.maxTTL != 0 || props.conflictResolution != "" || props.scope != "" {
+			return fmt.Errorf("memcached buckets do not support replicas, eviction, compression, maxTTL, conflict resolution or scopes")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown bucketType %q (use couchbase, ephemeral or memcached)", props.dbType)
+	}
+
+	if props.replicaNumber != nil {
+		replicas := *props.replicaNumber
+		if replicas < 0 || replicas > maxReplicas {
+			return fmt.Errorf("replicaNumber must be between 0 and %d, not %d", maxReplicas, replicas)
+		}
+		if nodes := props.dataNodes(); replicas >= nodes {
+			return fmt.Errorf("replicaNumber %d needs at least %d data nodes, the instance has %d", replicas, replicas+1, nodes)
+		}
+	}
+	if props.evictionPolicy != "" && !contains(evictionPolicies[props.dbType], props.evictionPolicy) {
+		return fmt.Errorf("evictionPolicy %q is not valid for %v buckets (use one of %v)",
+			props.evictionPolicy, props.dbType, evictionPolicies[props.dbType])
+	}
+	switch props.compressionMode {
+	case "", "off", "passive", "active":
+	default:
+		return fmt.Errorf("unknown compressionMode %q (use off, passive or active)", props.compressionMode)
+	}
+	if props.maxTTL < 0 || props.maxTTL > maxBucketTTL {
+		return fmt.Errorf("maxTTL must be between 0 and %d seconds, not %d", maxBucketTTL, props.maxTTL)
+	}
+	switch props.conflictResolution {
+	case "", "seqno", "lww":
+	default:
+		return fmt.Errorf("unknown conflictResolutionType %q (use seqno or lww)", props.conflictResolution)
+	}
+	return props.validateCollections()
+}
+
+var validCollectionName = regexp.MustCompile(`^[A-Za-z0-9-][A-Za-z0-9_%-]{0,250}$`)
+
+func (props cbDefaultSettings) validateCollections() error {
+	if props.scope == "" {
+		if len(props.collections) > 0 {
+			return fmt.Errorf("collections need a scope")
+		}
+		return nil
+	}
+	if !validCollectionName.MatchString(props.scope) {
+		return fmt.Errorf("scope name %q must be letters, digits, '_', '%%' or '-', and not start with '_' or '%%'", props.scope)
+	}
+	for i, name := range props.collections {
+		if !validCollectionName.MatchString(name) {
+			return fmt.Errorf("collection name %q must be letters, digits, '_', '%%' or '-', and not start with '_' or '%%'", name)
+		}
+		if contains(props.collections[:i], name) {
+			return fmt.Errorf("collection %q is declared twice", name)
+		}
+	}
+	if !props.minVersion.IsZero() && !props.minVersion.AtLeast(admin.VersionCollections) {
+		return fmt.Errorf("scopes and collections need Couchbase %v or later, the plan allows %v", admin.VersionCollections, props.minVersion)
+	}
+	return nil
+}
+
+var validIndexName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_#-]*$`)
+
+// attributePath matches the index fields that name an attribute, e.g.
+// address.city, rather than being an expression.
+var attributePath = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// checkIndexExpression rejects what could end or comment out the rest of the
+// CREATE INDEX statement an index field or where clause is pasted into.
+func checkIndexExpression(indexName, what, expression string) error {
+	for _, token := range []string{";", "--", "/*"} {
+		if strings.Contains(expression, token) {
+			return fmt.Errorf("index %q: %v %q must not hold %q", indexName, what, expression, token)
+		}
+	}
+	return nil
+}
+
+func (props cbDefaultSettings) validateIndexes() error {
+	if len(props.indexes) == 0 {
+		return nil
+	}
+	if props.dbType == admin.BucketMemcached {
+		return fmt.Errorf("memcached buckets cannot be indexed")
+	}
+	if !props.runsService(admin.ServiceQuery) || !props.runsService(admin.ServiceIndex) {
+		return fmt.Errorf("indexes need nodes running the n1ql and index services")
+	}
+
+	names := make(map[string]bool)
+	primaries := 0
+	for _, index := range props.indexes {
+		if index.Name != "" && !validIndexName.MatchString(index.Name) {
+			return fmt.Errorf("index name %q must start with a letter and hold only letters, digits, '_', '#' and '-'", index.Name)
+		}
+		if index.Primary {
+			primaries++
+			if len(index.Fields) > 0 || index.Where != "" {
+				return fmt.Errorf("primary index %q takes no fields or where clause", index.Name)
+			}
+		} else {
+			if index.Name == "" {
+				return fmt.Errorf("secondary indexes need a name")
+			}
+			if len(index.Fields) == 0 {
+				return fmt.Errorf("index %q needs at least one field", index.Name)
+			}
+			for _, field := range index.Fields {
+				if strings.TrimSpace(field) == "" {
+					return fmt.Errorf("index %q has an empty field", index.Name)
+				}
+				err := checkIndexExpression(index.Name, "field", field)
+				if err != nil {
+					return err
+				}
+			}
+			err := checkIndexExpression(index.Name, "where clause", index.Where)
+			if err != nil {
+				return err
+			}
+		}
+		if index.Name != "" && names[index.Name] {
+			return fmt.Errorf("index %q is declared twice", index.Name)
+		}
+		names[index.Name] = true
+	}
+	if primaries > 1 {
+		return fmt.Errorf("a bucket can only have one primary index")
+	}
+	return nil
+}
+
+// runsService reports whether any node of the instance runs service.
+func (props cbDefaultSettings) runsService(service string) bool {
+	if props.topology == nil {
+		return hasService(props.services, service)
+	}
+	for _, group := range props.topology {
+		if hasService(group.Services, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataNodes returns the number of nodes running the data service.
+func (props cbDefaultSettings) dataNodes() int {
+	if props.topology == nil {
+		return props.instances
+	}
+	nodes := 0
+	for _, group := range props.topology {
+		if hasService(group.Services, admin.ServiceData) {
+			nodes += group.Instances
+		}
+	}
+	return nodes
+}
+
+func validateTopology(topology []model.NodeGroup) error {
+	if len(topology) == 0 {
+		return fmt.Errorf("topology must list at least one node group")
+	}
+	names := make(map[string]bool)
+	var all []string
+	for _, group := range topology {
+		if !validGroupName.MatchString(group.Name) {
+			return fmt.Errorf("topology group name %q must be lowercase letters, digits and dashes", group.Name)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("topology group %q is declared twice", group.Name)
+		}
+		names[group.Name] = true
+		if group.Instances < 1 {
+			return fmt.Errorf("topology group %q needs at least 1 instance, not %d", group.Name, group.Instances)
+		}
+		if len(group.Services) == 0 {
+			return fmt.Errorf("topology group %q has no services", group.Name)
+		}
+		err := validateServices(group.Services)
+		if err != nil {
+			return fmt.Errorf("topology group %q: %v", group.Name, err)
+		}
+		all = append(all, group.Services...)
+	}
+	return requireDataService(all)
+}
+
+var validGroupName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func validateServices(services []string) error {
+	for _, s := range services {
+		switch s {
+		case admin.ServiceData, admin.ServiceIndex, admin.ServiceQuery, admin.ServiceFTS:
+		default:
+			return fmt.Errorf("unknown Couchbase service %q", s)
+		}
+	}
+	return nil
+}
+
+func requireDataService(services []string) error {
+	if !hasService(services, admin.ServiceData) {
+		return fmt.Errorf("services %v must include the data service (%v)", services, admin.ServiceData)
+	}
+	return nil
+}
+
+func hasService(services []string, service string) bool {
+	return contains(services, service)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeGroups returns the groups of nodes to deploy: one per topology group,
+// or a single group of instances nodes if the plan has no topology.
+func (props cbDefaultSettings) nodeGroups(instances int) []nodeGroup {
+	var vmType, diskType string
+	if props.cloudConfig != nil {
+		vmType = props.cloudConfig.VMType
+		diskType = props.cloudConfig.PersistentDiskType
+	}
+	if props.topology == nil {
+		return []nodeGroup{{
+			jobName:            couchbaseJobName,
+			services:           props.services,
+			instances:          instances,
+			vmType:             vmType,
+			persistentDiskType: diskType,
+		}}
+	}
+	groups := make([]nodeGroup, len(props.topology))
+	for i, g := range props.topology {
+		groups[i] = nodeGroup{
+			jobName:            couchbaseJobName + "-" + g.Name,
+			services:           g.Services,
+			instances:          g.Instances,
+			vmType:             vmType,
+			persistentDiskType: diskType,
+		}
+		if g.VMType != "" {
+			groups[i].vmType = g.VMType
+		}
+		if g.PersistentDiskType != "" {
+			groups[i].persistentDiskType = g.PersistentDiskType
+		}
+	}
+	return groups
+}
+
+// validateCatalogPlans checks every plan in the catalog, so bad plans are
+// rejected when the catalog is loaded rather than when provisioning.
+func validateCatalogPlans(catalog *model.Catalog) error {
+	for _, s := range catalog.Services {
+		for i := range s.Plans {
+			_, err := cbPlanProps(&s.Plans[i])
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestPlanProps(t *testing.T) {
+	plan := &model.ServicePlan{
+		Name: "dev-cluster",
+		Metadata: map[string]interface{}{
+			"ramQuota":      1024,
+			"indexRamQuota": 512,
+			"instances":     3,
+			"services":      []string{"kv", "n1ql"},
+		},
+	}
+	props, err := cbPlanProps(plan)
+	if err != nil {
+		t.Fatalf("cbPlanProps: %v", err)
+	}
+	if props.ramQuota != 1024 || props.indexRAMQuota != 512 || props.bucketRAMQuota != 1024 || props.instances != 3 {
+		t.Errorf("plan settings not applied: %+v", props)
+	}
+
+	invalid := []map[string]interface{}{
+		{"ramQuota": 128},
+		{"ramQuota": 512, "bucketRamQuota": 768},
+		{"instances": -1},
+		{"services": []string{"n1ql"}},
+		{"services": []string{"kv", "bogus"}},
+		{"errands": []map[string]interface{}{{"name": "smoke test"}}},
+		{"errands": []map[string]interface{}{{"name": "a", "initializesCluster": true}, {"name": "b", "initializesCluster": true}}},
+	}
+	for _, metadata := range invalid {
+		plan.Metadata = metadata
+		if _, err = cbPlanProps(plan); err == nil {
+			t.Errorf("expected plan metadata %v to be rejected", metadata)
+		}
+	}
+}
+
+func TestBucketParameters(t *testing.T) {
+	plan := &model.ServicePlan{
+		Name: "dev-cluster",
+		Metadata: map[string]interface{}{
+			"instances": 3,
+			"bucket":    map[string]interface{}{"bucketType": "couchbase", "replicaNumber": 1, "compressionMode": "passive"},
+		},
+	}
+	var parameters interface{}
+	err := json.Unmarshal([]byte(`{"bucket": {"name": "orders", "replicaNumber": 2,
+		"evictionPolicy": "fullEviction", "maxTTL": 3600, "flushEnabled": true}}`), &parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		t.Fatalf("cbInstanceProps: %v", err)
+	}
+
+	fake := admintest.NewServer()
+	defer fake.Close()
+	cb, err := configureCouchbaseNode(fake.URL, props, props.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	if _, err = createCouchbaseBucket(cb, props, "user1", "password1", "saslpw"); err != nil {
+		t.Fatalf("createCouchbaseBucket: %v", err)
+	}
+	bucket := fake.Buckets["orders"]
+	if bucket == nil || bucket.ReplicaNumber != 2 || bucket.EvictionPolicy != "fullEviction" ||
+		bucket.CompressionMode != "passive" || bucket.MaxTTL != 3600 || !bucket.FlushEnabled {
+		t.Errorf("bucket settings not applied: %+v", bucket)
+	}
+
+	invalid := []map[string]interface{}{
+		{"bucket": map[string]interface{}{"replicaNumber": 3}},
+		{"bucket": map[string]interface{}{"bucketType": "ephemeral", "evictionPolicy": "fullEviction"}},
+		{"bucket": map[string]interface{}{"bucketType": "memcached"}},
+		{"bucket": map[string]interface{}{"bucketType": "bogus"}},
+		{"bucket": map[string]interface{}{"name": ".hidden"}},
+		{"bucket": map[string]interface{}{"conflictResolutionType": "newest"}},
+		{"bucket": map[string]interface{}{"maxTTL": -1}},
+		{"instances": 1},
+	}
+	for _, params := range invalid {
+		if _, err = cbInstanceProps(plan, params); err == nil {
+			t.Errorf("expected parameters %v to be rejected", params)
+		}
+	}
+}
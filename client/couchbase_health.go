@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+// memoryPressurePercent is the share of the RAM quota holding data above
+// which a cluster is reported as degraded.
+const memoryPressurePercent = 90
+
+// CheckHealth polls the cluster of a provisioned instance through its stored
+// administrator credentials, and reports the state of its nodes and memory,
+// and when its certificate expires.
+func CheckHealth(credential *model.Credential) *model.InstanceHealth {
+	health := &model.InstanceHealth{
+		Status:    model.HealthHealthy,
+		CheckedAt: time.Now().UTC(),
+	}
+	cb := adminClient(credential)
+	cb.Retries = 0
+	pool, err := cb.GetPool()
+	if err != nil {
+		health.Status = model.HealthUnreachable
+		health.Summary = fmt.Sprintf("cluster unreachable: %v", err)
+		return health
+	}
+
+	var problems []string
+	for _, node := range pool.Nodes {
+		nodeHealth := model.NodeHealth{
+			Hostname:   node.Hostname,
+			Status:     node.Status,
+			FailedOver: node.ClusterMembership == admin.MembershipFailed,
+		}
+		health.Nodes = append(health.Nodes, nodeHealth)
+		switch {
+		case nodeHealth.FailedOver:
+			problems = append(problems, fmt.Sprintf("node %v failed over", node.Hostname))
+		case node.Status == admin.NodeWarmup:
+			problems = append(problems, fmt.Sprintf("node %v warming up", node.Hostname))
+		case node.Status != admin.NodeHealthy:
+			problems = append(problems, fmt.Sprintf("node %v %v", node.Hostname, node.Status))
+		}
+	}
+
+	ram := pool.StorageTotals.RAM
+	if ram.QuotaTotal > 0 {
+		health.MemoryUsedPercent = float64(ram.UsedByData) * 100 / float64(ram.QuotaTotal)
+		if health.MemoryUsedPercent >= memoryPressurePercent {
+			problems = append(problems, fmt.Sprintf("data uses %.0f%% of the RAM quota", health.MemoryUsedPercent))
+		}
+	}
+
+	if credential.CACertificate != "" {
+		expires, err := certificateExpiry(credential)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("certificate: %v", err))
+		} else {
+			health.CertificateExpires = &expires
+		}
+	}
+
+	if len(problems) > 0 {
+		health.Status = model.HealthDegraded
+		health.Summary = strings.Join(problems, ", ")
+	}
+	return health
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestCheckHealth(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.MemoryQuota = 1024
+	fake.AdminUser, fake.AdminPassword = "user1", "password1"
+	fake.Nodes = append(fake.Nodes, &admintest.FakeNode{Hostname: "10.244.1.6:8091", OTPNode: "ns_1@10.244.1.6", Membership: "active", Status: "healthy"})
+	cred := &model.Credential{URI: fake.URL, UserName: "user1", Password: "password1"}
+
+	health := CheckHealth(cred)
+	if health.Status != model.HealthHealthy || len(health.Nodes) != 2 || health.Summary != "" {
+		t.Errorf("expected a healthy cluster, got %+v", health)
+	}
+
+	fake.Nodes[1].Status = "unhealthy"
+	fake.Nodes[1].Membership = "inactiveFailed"
+	fake.Buckets["orders"] = &admintest.FakeBucket{Name: "orders", RAMQuotaMB: 1024, MemUsed: 1900 << 20}
+	health = CheckHealth(cred)
+	if health.Status != model.HealthDegraded || !health.Nodes[1].FailedOver ||
+		health.Summary != "node 10.244.1.6:8091 failed over, data uses 93% of the RAM quota" {
+		t.Errorf("expected a degraded cluster, got %+v", health)
+	}
+
+	fake.Nodes[1].Membership = "active"
+	fake.Nodes[1].Status = "warmup"
+	if health = CheckHealth(cred); health.Summary != "node 10.244.1.6:8091 warming up, data uses 93% of the RAM quota" {
+		t.Errorf("unexpected summary %q", health.Summary)
+	}
+
+	cred.Password = "wrong"
+	if health = CheckHealth(cred); health.Status != model.HealthUnreachable {
+		t.Errorf("expected an unreachable cluster, got %+v", health)
+	}
+}
@@ -0,0 +1,402 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// A clusterNode is a node to add to the cluster and the services it runs.
+// The availability zone and index of its VM can place it in a server group.
+type clusterNode struct {
+	ip       string
+	services []string
+	az       string
+	index    int
+}
+
+// configureCouchbaseNode initializes a freshly started node (memory quotas,
+// services) and replaces the default administrator with userID/passwd.  It
+// returns a client authenticated with the new credentials.
+func configureCouchbaseNode(nodeURL string, cbProps cbDefaultSettings, services []string, userID, passwd string) (*admin.Client, error) {
+	cb := admin.NewClient(nodeURL, cbProps.adminUser, cbProps.adminPass)
+
+	err := cb.SetPoolSettings(admin.PoolSettings{
+		MemoryQuota:      cbProps.ramQuota,
+		IndexMemoryQuota: cbProps.indexRAMQuota,
+	})
+	if err != nil {
+		utils.Logger.Printf("client.configureCouchbaseNode: %v: %v\n", nodeURL, err)
+		return nil, err
+	}
+	err = cb.SetupServices(services)
+	if err != nil {
+		utils.Logger.Printf("client.configureCouchbaseNode: %v: %v\n", nodeURL, err)
+		return nil, err
+	}
+	err = cb.SetWebCredentials(userID, passwd, cbProps.port)
+	if err != nil {
+		utils.Logger.Printf("client.configureCouchbaseNode: %v: %v\n", nodeURL, err)
+		return nil, err
+	}
+	return cb.WithCredentials(userID, passwd), nil
+}
+
+// configureCouchbaseNodes configures the nodes of cluster, at nodeURLs, one
+// after the other, giving them the administrator userID/passwd.  Failing once
+// a node has them is a FatalError: a retry would not know them.
+func configureCouchbaseNodes(nodeURLs []string, cluster []clusterNode, cbProps cbDefaultSettings, userID, passwd string) ([]*admin.Client, error) {
+	nodes := make([]*admin.Client, len(cluster))
+	for i, node := range cluster {
+		var err error
+		nodes[i], err = configureCouchbaseNode(nodeURLs[i], cbProps, node.services, userID, passwd)
+		if err != nil && i > 0 {
+			return nil, &FatalError{err}
+		}
+		if err != nil {
+			return nil, configureFailure(err)
+		}
+	}
+	return nodes, nil
+}
+
+// configureFailure returns err, as a FatalError if the default administrator
+// credentials were refused: an earlier attempt gave the node credentials that
+// are lost, so retrying cannot succeed.
+func configureFailure(err error) error {
+	if admin.IsUnauthorized(err) {
+		return &FatalError{fmt.Errorf("the node no longer accepts the default administrator credentials, an earlier attempt set it up: %v", err)}
+	}
+	return err
+}
+
+// detectVersion returns the Couchbase release of the node cb talks to.  A
+// release older than the plan's minVersion is a FatalError: the deployment
+// will not change by itself.
+func detectVersion(cb *admin.Client, cbProps cbDefaultSettings) (admin.Version, error) {
+	version, err := cb.ServerVersion()
+	if err != nil {
+		return version, err
+	}
+	utils.Logger.Printf("client.detectVersion: %v runs Couchbase %v\n", cb.URL(), version)
+	if !version.AtLeast(cbProps.minVersion) {
+		return version, &FatalError{fmt.Errorf("the plan needs Couchbase %v or later, the instance runs %v", cbProps.minVersion, version)}
+	}
+	return version, nil
+}
+
+// How often and for how long createCouchbaseBucket waits for a new bucket
+// to accept scopes.
+var (
+	bucketPollInterval = 2 * time.Second
+	bucketTimeout      = 2 * time.Minute
+)
+
+// createCouchbaseBucket creates the service bucket and returns the credentials
+// for it.  cb must be authenticated as the cluster administrator.
+//
+// Before Couchbase 5.0 (cbProps.serverVersion), the bucket is protected by a
+// SASL password.  Later releases only know RBAC users, so a user named after
+// the bucket gets that password: clients keep authenticating the same way.
+// From 7.0, the bucket can also get a scope of collections.
+func createCouchbaseBucket(cb *admin.Client, cbProps cbDefaultSettings, userID, passwd, saslpasswd string) (*model.Credential, error) {
+	credentials := model.Credential{
+		URI:          cb.URL(),
+		UserName:     userID,
+		Password:     passwd,
+		SASLPassword: saslpasswd,
+		BucketName:   cbProps.bucketName,
+	}
+	utils.Logger.Printf("client.createCouchbaseBucket: %v\n", credentials)
+
+	rbac := cbProps.serverVersion.AtLeast(admin.VersionRBAC)
+	if cbProps.scope != "" && !cbProps.serverVersion.AtLeast(admin.VersionCollections) {
+		return nil, fmt.Errorf("scopes and collections need Couchbase %v or later, the instance runs %v",
+			admin.VersionCollections, cbProps.serverVersion)
+	}
+
+	settings := admin.BucketSettings{
+		Name:                   cbProps.bucketName,
+		BucketType:             cbProps.dbType,
+		RAMQuotaMB:             cbProps.bucketRAMQuota,
+		ReplicaNumber:          cbProps.replicaNumber,
+		EvictionPolicy:         cbProps.evictionPolicy,
+		CompressionMode:        cbProps.compressionMode,
+		MaxTTL:                 cbProps.maxTTL,
+		ConflictResolutionType: cbProps.conflictResolution,
+		FlushEnabled:           cbProps.flushEnabled,
+	}
+	if !rbac {
+		settings.AuthType = "sasl"
+		settings.SASLPassword = saslpasswd
+	}
+	err := cb.CreateBucket(settings)
+	if err != nil {
+		utils.Logger.Printf("client.createCouchbaseBucket: %v\n", err)
+		return nil, err
+	}
+
+	if rbac {
+		role := fmt.Sprintf("bucket_full_access[%s]", cbProps.bucketName)
+		err = cb.SetUser(cbProps.bucketName, saslpasswd, []string{role})
+		if err != nil {
+			utils.Logger.Printf("client.createCouchbaseBucket: bucket user: %v\n", err)
+			return nil, err
+		}
+	}
+	if cbProps.scope != "" {
+		err = createCouchbaseCollections(cb, cbProps)
+		if err != nil {
+			return nil, err
+		}
+		credentials.Scope = cbProps.scope
+		credentials.Collections = cbProps.collections
+	}
+	return &credentials, nil
+}
+
+// createCouchbaseCollections creates the scope and collections of cbProps in
+// the service bucket, waiting for the new bucket to accept them.
+func createCouchbaseCollections(cb *admin.Client, cbProps cbDefaultSettings) error {
+	deadline := time.Now().Add(bucketTimeout)
+	for {
+		err := cb.CreateScope(cbProps.bucketName, cbProps.scope)
+		if err == nil {
+			break
+		}
+		if !admin.IsNotFound(err) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(bucketPollInterval)
+	}
+	for _, collection := range cbProps.collections {
+		err := cb.CreateCollection(cbProps.bucketName, cbProps.scope, collection)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// How often and for how long configureCouchbaseCluster polls a rebalance.
+var (
+	rebalancePollInterval = 5 * time.Second
+	rebalanceTimeout      = 30 * time.Minute
+)
+
+// configureCouchbaseCluster adds the nodes to the cluster cb is connected to,
+// each with its own services, and rebalances over all of them, reporting the
+// rebalance progress to progress (if not nil) until it completes.
+func configureCouchbaseCluster(cb *admin.Client, nodes []clusterNode, userID, passwd string, progress ProgressFunc) error {
+	utils.Logger.Printf("client.configureCouchbaseCluster - using base URL: %v\n", cb.URL())
+
+	for idx, node := range nodes {
+		utils.Logger.Printf("client.configureCouchbaseCluster - adding node %d: %v %v\n", idx, node.ip, node.services)
+		_, err := cb.AddNode(node.ip, userID, passwd, node.services)
+		if err != nil {
+			utils.Logger.Printf("client.configureCouchbaseCluster: addNode %d/%s: %v\n", idx, node.ip, err)
+			return err
+		}
+	}
+
+	err := rebalanceCluster(cb, progress)
+	if err != nil {
+		utils.Logger.Printf("client.configureCouchbaseCluster: rebalance: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// rebalanceCluster rebalances over all the nodes of the cluster cb is
+// connected to, reporting the rebalance progress to progress (if not nil)
+// until it completes.
+func rebalanceCluster(cb *admin.Client, progress ProgressFunc) error {
+	pool, err := cb.GetPool()
+	if err != nil {
+		return err
+	}
+	knownNodes := make([]string, len(pool.Nodes))
+	for i, node := range pool.Nodes {
+		knownNodes[i] = node.OTPNode
+	}
+	err = cb.Rebalance(knownNodes, nil)
+	if err != nil {
+		return err
+	}
+	return cb.WaitForRebalance(rebalancePollInterval, rebalanceTimeout, func(percent float64) {
+		if progress != nil {
+			progress(fmt.Sprintf("rebalancing %d nodes: %.0f%% complete", len(knownNodes), percent))
+		}
+	})
+}
+
+// checkScaleIn returns an error if shrinking the cluster to dataNodes data
+// nodes would leave a bucket without enough nodes for its replicas, or
+// without enough memory for the data it holds.
+func checkScaleIn(cb *admin.Client, dataNodes int) error {
+	buckets, err := cb.ListBuckets()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		if bucket.BucketType != admin.BucketMemcached && bucket.ReplicaNumber >= dataNodes {
+			return fmt.Errorf("bucket %v has %d replicas, which needs at least %d data nodes",
+				bucket.Name, bucket.ReplicaNumber, bucket.ReplicaNumber+1)
+		}
+		quota := int64(dataNodes) * bucket.Quota.RawRAM
+		if bucket.BasicStats.MemUsed > quota {
+			return fmt.Errorf("bucket %v uses %d MB of memory, more than its %d MB quota on %d nodes",
+				bucket.Name, bucket.BasicStats.MemUsed>>20, quota>>20, dataNodes)
+		}
+	}
+	return nil
+}
+
+// ejectCouchbaseNodes rebalances the nodes at ipaddrs out of the cluster cb is
+// connected to, reporting the rebalance progress to progress (if not nil).
+func ejectCouchbaseNodes(cb *admin.Client, ipaddrs []string, progress ProgressFunc) error {
+	pool, err := cb.GetPool()
+	if err != nil {
+		return err
+	}
+	knownNodes := make([]string, len(pool.Nodes))
+	for i, node := range pool.Nodes {
+		knownNodes[i] = node.OTPNode
+	}
+	ejectedNodes := make([]string, len(ipaddrs))
+	for i, ip := range ipaddrs {
+		ejectedNodes[i] = admin.OTPNodeName(ip)
+		if !contains(knownNodes, ejectedNodes[i]) {
+			return fmt.Errorf("node %v is not in the cluster", ip)
+		}
+	}
+
+	utils.Logger.Printf("client.ejectCouchbaseNodes - removing %v\n", ejectedNodes)
+	err = cb.Rebalance(knownNodes, ejectedNodes)
+	if err != nil {
+		return err
+	}
+	return cb.WaitForRebalance(rebalancePollInterval, rebalanceTimeout, func(percent float64) {
+		if progress != nil {
+			progress(fmt.Sprintf("rebalancing %d nodes out of the cluster: %.0f%% complete", len(ejectedNodes), percent))
+		}
+	})
+}
+
+// How often and for how long createCouchbaseIndexes waits for the query
+// service to see a new bucket, and for the indexes to come online.
+var (
+	indexPollInterval = 2 * time.Second
+	indexTimeout      = 10 * time.Minute
+)
+
+// createCouchbaseIndexes creates the indexes of cbProps on the service bucket
+// through the query service query talks to, and waits for them to come
+// online, reporting progress to progress (if not nil).
+func createCouchbaseIndexes(query *admin.Client, cbProps cbDefaultSettings, progress ProgressFunc) error {
+	if len(cbProps.indexes) == 0 {
+		return nil
+	}
+	if progress == nil {
+		progress = func(string) {}
+	}
+	deadline := time.Now().Add(indexTimeout)
+
+	pending := make(map[string]bool)
+	for i, index := range cbProps.indexes {
+		progress(fmt.Sprintf("creating index %d of %d...", i+1, len(cbProps.indexes)))
+		statement := indexStatement(cbProps.bucketName, index)
+		utils.Logger.Printf("client.createCouchbaseIndexes: %v\n", statement)
+		for {
+			err := query.Query(statement, nil)
+			if err == nil {
+				break
+			}
+			// a new bucket takes a moment to show up in the query service
+			if !admin.IsKeyspaceNotFound(err) || time.Now().After(deadline) {
+				return fmt.Errorf("%v: %v", statement, err)
+			}
+			time.Sleep(indexPollInterval)
+		}
+		pending[indexName(index)] = true
+	}
+
+	for {
+		indexes, err := query.ListIndexes(cbProps.bucketName)
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			if index.State == "online" {
+				delete(pending, index.Name)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		online := len(cbProps.indexes) - len(pending)
+		progress(fmt.Sprintf("building indexes: %d of %d online", online, len(cbProps.indexes)))
+		if time.Now().After(deadline) {
+			return fmt.Errorf("indexes still not online after %v: %v", indexTimeout, pendingNames(pending))
+		}
+		time.Sleep(indexPollInterval)
+	}
+}
+
+// indexStatement returns the N1QL statement that creates index on bucket.
+func indexStatement(bucket string, index model.IndexDefinition) string {
+	if index.Primary {
+		if index.Name == "" {
+			return fmt.Sprintf("CREATE PRIMARY INDEX ON %s USING GSI", admin.QuoteIdentifier(bucket))
+		}
+		return fmt.Sprintf("CREATE PRIMARY INDEX %s ON %s USING GSI",
+			admin.QuoteIdentifier(index.Name), admin.QuoteIdentifier(bucket))
+	}
+	fields := make([]string, len(index.Fields))
+	for i, field := range index.Fields {
+		fields[i] = indexField(field)
+	}
+	statement := fmt.Sprintf("CREATE INDEX %s ON %s(%s)",
+		admin.QuoteIdentifier(index.Name), admin.QuoteIdentifier(bucket), strings.Join(fields, ", "))
+	if index.Where != "" {
+		statement += " WHERE " + index.Where
+	}
+	return statement + " USING GSI"
+}
+
+// indexField returns a field of an index for its CREATE INDEX statement: an
+// attribute path, e.g. address.city, with each name quoted, so that fields
+// named like N1QL keywords work; other expressions as they are.
+func indexField(field string) string {
+	field = strings.TrimSpace(field)
+	if !attributePath.MatchString(field) {
+		return field
+	}
+	names := strings.Split(field, ".")
+	for i, name := range names {
+		names[i] = admin.QuoteIdentifier(name)
+	}
+	return strings.Join(names, ".")
+}
+
+// indexName returns the name Couchbase gives index.
+func indexName(index model.IndexDefinition) string {
+	if index.Primary && index.Name == "" {
+		return "#primary"
+	}
+	return index.Name
+}
+
+func pendingNames(pending map[string]bool) []string {
+	var names []string
+	for name := range pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
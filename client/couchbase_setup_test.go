@@ -0,0 +1,320 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestProvisionSingleNode(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	cbProps := cbDefaultProps()
+
+	cb, err := configureCouchbaseNode(fake.URL, cbProps, cbProps.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	cred, err := createCouchbaseBucket(cb, cbProps, "user1", "password1", "saslpw")
+	if err != nil {
+		t.Fatalf("createCouchbaseBucket: %v", err)
+	}
+
+	if fake.AdminUser != "user1" || fake.AdminPassword != "password1" {
+		t.Errorf("administrator not replaced: %v/%v", fake.AdminUser, fake.AdminPassword)
+	}
+	if fake.MemoryQuota != cbProps.ramQuota || fake.IndexMemoryQuota != cbProps.indexRAMQuota {
+		t.Errorf("unexpected quotas %v/%v", fake.MemoryQuota, fake.IndexMemoryQuota)
+	}
+	bucket := fake.Buckets[cbProps.bucketName]
+	if bucket == nil || bucket.SASLPassword != "saslpw" || bucket.RAMQuotaMB != cbProps.bucketRAMQuota {
+		t.Errorf("unexpected bucket %+v", bucket)
+	}
+	if cred.URI != fake.URL || cred.BucketName != cbProps.bucketName || cred.Password != "password1" {
+		t.Errorf("unexpected credentials %+v", cred)
+	}
+
+	// a second attempt fails cleanly: the default administrator is gone
+	if _, err = configureCouchbaseNode(fake.URL, cbProps, cbProps.services, "user2", "password2"); err == nil {
+		t.Errorf("reconfiguring a provisioned node should fail")
+	}
+}
+
+func TestProvisionCluster(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	cbProps := cbDefaultProps()
+
+	cb, err := configureCouchbaseNode(fake.URL, cbProps, cbProps.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	nodes := []clusterNode{
+		{ip: "10.244.1.6", services: cbProps.services},
+		{ip: "10.244.1.10", services: []string{"index", "n1ql"}},
+	}
+	fake.RebalanceSteps = 2
+	defer func(interval time.Duration) { rebalancePollInterval = interval }(rebalancePollInterval)
+	rebalancePollInterval = time.Millisecond
+	var reports []string
+	err = configureCouchbaseCluster(cb, nodes, "user1", "password1", func(description string) {
+		reports = append(reports, description)
+	})
+	if err != nil {
+		t.Fatalf("configureCouchbaseCluster: %v", err)
+	}
+	if len(reports) != 2 || reports[1] != "rebalancing 3 nodes: 100% complete" {
+		t.Errorf("unexpected progress reports %q", reports)
+	}
+	if len(fake.Nodes) != 3 || fake.Rebalances != 1 {
+		t.Errorf("expected 3 nodes and 1 rebalance: %v, %d rebalances", fake, fake.Rebalances)
+	}
+	for _, n := range fake.Nodes {
+		if n.Membership != "active" {
+			t.Errorf("node %v not active after rebalance", n.OTPNode)
+		}
+	}
+	if services := fake.Nodes[2].Services; len(services) != 2 || services[0] != "index" {
+		t.Errorf("node services not applied: %v", services)
+	}
+}
+
+func TestProvisionClusterRebalanceFailure(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.RebalanceFailure = "Rebalance failed. See logs for detailed reason. You can try again."
+	cbProps := cbDefaultProps()
+
+	cb, err := configureCouchbaseNode(fake.URL, cbProps, cbProps.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	err = configureCouchbaseCluster(cb, []clusterNode{{ip: "10.244.1.6"}}, "user1", "password1", nil)
+	if err == nil || !strings.Contains(err.Error(), fake.RebalanceFailure) {
+		t.Errorf("expected the rebalance failure, got %v", err)
+	}
+}
+
+func TestScaleIn(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	defer func(interval time.Duration) { rebalancePollInterval = interval }(rebalancePollInterval)
+	rebalancePollInterval = time.Millisecond
+	cbProps := cbDefaultProps()
+	one := 1
+	cbProps.replicaNumber = &one
+
+	cb, err := configureCouchbaseNode(fake.URL, cbProps, cbProps.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	nodes := []clusterNode{{ip: "10.244.1.6"}, {ip: "10.244.1.10"}}
+	if err = configureCouchbaseCluster(cb, nodes, "user1", "password1", nil); err != nil {
+		t.Fatalf("configureCouchbaseCluster: %v", err)
+	}
+	if _, err = createCouchbaseBucket(cb, cbProps, "user1", "password1", "saslpw"); err != nil {
+		t.Fatalf("createCouchbaseBucket: %v", err)
+	}
+
+	if err = checkScaleIn(cb, 2); err != nil {
+		t.Errorf("scaling in to 2 nodes should be allowed: %v", err)
+	}
+	if err = checkScaleIn(cb, 1); err == nil {
+		t.Errorf("scaling in to 1 node should be refused: the bucket has a replica")
+	}
+	fake.Buckets[cbProps.bucketName].MemUsed = int64(cbProps.bucketRAMQuota) << 22
+	if err = checkScaleIn(cb, 2); err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Errorf("scaling in should be refused for lack of memory, got %v", err)
+	}
+
+	if err = ejectCouchbaseNodes(cb, []string{"10.244.1.99"}, nil); err == nil {
+		t.Errorf("expected ejecting an unknown node to fail")
+	}
+	if err = ejectCouchbaseNodes(cb, []string{"10.244.1.10"}, nil); err != nil {
+		t.Fatalf("ejectCouchbaseNodes: %v", err)
+	}
+	if len(fake.Nodes) != 2 || fake.Nodes[1].OTPNode != "ns_1@10.244.1.6" {
+		t.Errorf("node not ejected: %v", fake)
+	}
+
+	if ip := nodeIP("http://10.244.1.2:8091"); ip != "10.244.1.2" {
+		t.Errorf("nodeIP: %v", ip)
+	}
+}
+
+func TestCreateIndexes(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.IndexBuildSteps = 2
+	defer func(interval time.Duration) { indexPollInterval = interval }(indexPollInterval)
+	indexPollInterval = time.Millisecond
+
+	var parameters interface{}
+	err := json.Unmarshal([]byte(`{"indexes": [{"primary": true},
+		{"name": "by_type", "fields": ["type", "LOWER(name)", "address.city"], "where": "type IS NOT MISSING"}]}`), &parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err := cbInstanceProps(nil, parameters)
+	if err != nil {
+		t.Fatalf("cbInstanceProps: %v", err)
+	}
+	cb, err := configureCouchbaseNode(fake.URL, props, props.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	if _, err = createCouchbaseBucket(cb, props, "user1", "password1", "saslpw"); err != nil {
+		t.Fatalf("createCouchbaseBucket: %v", err)
+	}
+
+	cb.RetryDelay = time.Millisecond
+	var reports []string
+	err = createCouchbaseIndexes(cb, props, func(description string) {
+		reports = append(reports, description)
+	})
+	if err != nil {
+		t.Fatalf("createCouchbaseIndexes: %v", err)
+	}
+	index := fake.Indexes["cfdefault/by_type"]
+	if index == nil || index.Statement != "CREATE INDEX `by_type` ON `cfdefault`(`type`, LOWER(name), `address`.`city`) WHERE type IS NOT MISSING USING GSI" {
+		t.Errorf("unexpected index %+v", index)
+	}
+	if fake.Indexes["cfdefault/#primary"] == nil {
+		t.Errorf("primary index not created")
+	}
+	if last := reports[len(reports)-1]; last != "building indexes: 0 of 2 online" {
+		t.Errorf("unexpected progress reports %q", reports)
+	}
+
+	// a failing statement is reported with the query service error
+	props.indexes = []model.IndexDefinition{{Name: "by_type", Fields: []string{"type"}}}
+	err = createCouchbaseIndexes(cb, props, nil)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected the duplicate index to fail, got %v", err)
+	}
+
+	invalid := []map[string]interface{}{
+		{"indexes": []map[string]interface{}{{"name": "by_type"}}},
+		{"indexes": []map[string]interface{}{{"fields": []string{"type"}}}},
+		{"indexes": []map[string]interface{}{{"primary": true}, {"primary": true, "name": "p2"}}},
+		{"indexes": []map[string]interface{}{{"primary": true}}, "bucket": map[string]interface{}{"bucketType": "memcached"}},
+		{"indexes": []map[string]interface{}{{"name": "by_type", "fields": []string{"type"}, "where": "true; DROP PRIMARY INDEX ON `cfdefault`"}}},
+		{"indexes": []map[string]interface{}{{"name": "by_type", "fields": []string{"type) USING GSI --"}}}},
+	}
+	for _, params := range invalid {
+		if _, err = cbInstanceProps(nil, params); err == nil {
+			t.Errorf("expected parameters %v to be rejected", params)
+		}
+	}
+}
+
+func TestServerVersions(t *testing.T) {
+	plan := &model.ServicePlan{
+		Name: "dev-cluster",
+		Metadata: map[string]interface{}{
+			"minVersion": "7.0",
+			"bucket":     map[string]interface{}{"scope": "inventory", "collections": []interface{}{"items", "orders"}},
+		},
+	}
+	props, err := cbInstanceProps(plan, nil)
+	if err != nil {
+		t.Fatalf("cbInstanceProps: %v", err)
+	}
+
+	// a legacy release is refused for good
+	legacy := admintest.NewServer()
+	defer legacy.Close()
+	cb := admin.NewClient(legacy.URL, props.adminUser, props.adminPass)
+	if _, err = detectVersion(cb, props); err == nil {
+		t.Errorf("Couchbase %v should not satisfy minVersion 7.0", legacy.Version)
+	} else if _, ok := err.(*FatalError); !ok {
+		t.Errorf("expected a FatalError, got %v", err)
+	}
+
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.Version = "7.1.3-3479-enterprise"
+	cb = admin.NewClient(fake.URL, props.adminUser, props.adminPass)
+	if props.serverVersion, err = detectVersion(cb, props); err != nil {
+		t.Fatalf("detectVersion: %v", err)
+	}
+	cb, err = configureCouchbaseNode(fake.URL, props, props.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	cred, err := createCouchbaseBucket(cb, props, "user1", "password1", "saslpw")
+	if err != nil {
+		t.Fatalf("createCouchbaseBucket: %v", err)
+	}
+
+	// RBAC replaces the SASL password with a user named after the bucket
+	bucket := fake.Buckets[props.bucketName]
+	if bucket == nil || bucket.SASLPassword != "" {
+		t.Fatalf("unexpected bucket %+v", bucket)
+	}
+	user := fake.Users[props.bucketName]
+	if user == nil || user.Password != "saslpw" || len(user.Roles) != 1 ||
+		user.Roles[0] != "bucket_full_access["+props.bucketName+"]" {
+		t.Errorf("unexpected bucket user %+v", user)
+	}
+	if got := strings.Join(bucket.Scopes["inventory"], ","); got != "items,orders" {
+		t.Errorf("unexpected collections %q", got)
+	}
+	if cred.Scope != "inventory" || len(cred.Collections) != 2 {
+		t.Errorf("unexpected credentials %+v", cred)
+	}
+
+	invalid := []map[string]interface{}{
+		{"bucket": map[string]interface{}{"scope": "inventory", "collections": []interface{}{"items", "items"}}},
+		{"bucket": map[string]interface{}{"scope": "_bad"}},
+		{"bucket": map[string]interface{}{"scope": "inventory", "bucketType": "memcached"}},
+	}
+	for _, params := range invalid {
+		if _, err = cbInstanceProps(plan, params); err == nil {
+			t.Errorf("expected parameters %v to be rejected", params)
+		}
+	}
+	plan.Metadata = map[string]interface{}{"minVersion": "6.6", "bucket": map[string]interface{}{"scope": "inventory"}}
+	if _, err = cbInstanceProps(plan, nil); err == nil {
+		t.Errorf("collections should need minVersion 7.0")
+	}
+	plan.Metadata = map[string]interface{}{"bucket": map[string]interface{}{"collections": []interface{}{"items"}}}
+	if _, err = cbInstanceProps(plan, nil); err == nil {
+		t.Errorf("collections should need a scope")
+	}
+}
+
+func TestConfigureNodesFailure(t *testing.T) {
+	fakes := []*admintest.Server{admintest.NewServer(), admintest.NewServer()}
+	for _, fake := range fakes {
+		defer fake.Close()
+	}
+	fakes[1].Handle("/pools/default", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `["the node is not ready"]`, http.StatusBadRequest)
+	})
+	cbProps := cbDefaultProps()
+	cluster := []clusterNode{{ip: "10.244.1.2"}, {ip: "10.244.1.3"}}
+	nodeURLs := []string{fakes[0].URL, fakes[1].URL}
+
+	// the root node has the new credentials when the next fails
+	_, err := configureCouchbaseNodes(nodeURLs, cluster, cbProps, "user1", "password1")
+	if !IsFatal(err) || fakes[0].AdminUser != "user1" {
+		t.Errorf("a failure after the root node: %v, root administrator %v", err, fakes[0].AdminUser)
+	}
+	// and a retry would not know them
+	_, err = configureCouchbaseNodes(nodeURLs, cluster, cbProps, "user2", "password2")
+	if !IsFatal(err) {
+		t.Errorf("a retry after the root node was set up: %v", err)
+	}
+	// a failure before any node is set up can be retried
+	_, err = configureCouchbaseNodes(nodeURLs[1:], cluster[1:], cbProps, "user1", "password1")
+	if err == nil || IsFatal(err) {
+		t.Errorf("a failure on the root node: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	config "github.com/ssdowd/couchbasebroker/config"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// TLS modes of a plan.
+const (
+	tlsOff      = "off"
+	tlsOptional = "optional"
+	tlsRequired = "required"
+)
+
+const (
+	// The files, in the data directory, holding the CA the broker generates
+	// when none is configured.
+	caCertificateFile = "ca.pem"
+	caPrivateKeyFile  = "ca-key.pem"
+	caCommonName      = "Couchbase Service Broker CA"
+
+	defaultCertificateValidity = 365 * 24 * time.Hour
+	defaultCertificateRenewal  = 30 * 24 * time.Hour
+
+	// defaultAutoFailoverTimeout is the Couchbase default, restored after
+	// auto-failover was turned off to enable node encryption.
+	defaultAutoFailoverTimeout = 120
+)
+
+var (
+	brokerCAMutex sync.Mutex
+	brokerCA      *ca.Authority
+)
+
+// certificateAuthority returns the CA that signs the node certificates: the
+// configured one, or else one generated on first use and kept in the data
+// directory so that it survives restarts.
+func certificateAuthority() (*ca.Authority, error) {
+	brokerCAMutex.Lock()
+	defer brokerCAMutex.Unlock()
+	if brokerCA != nil {
+		return brokerCA, nil
+	}
+
+	conf := config.GetConfig()
+	if conf.CACertificate != "" || conf.CAPrivateKey != "" {
+		a, err := ca.Load([]byte(conf.CACertificate), []byte(conf.CAPrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ca_certificate or ca_private_key: %v", err)
+		}
+		brokerCA = a
+		return brokerCA, nil
+	}
+
+	certPath := filepath.Join(conf.DataPath, caCertificateFile)
+	keyPath := filepath.Join(conf.DataPath, caPrivateKeyFile)
+	if utils.Exists(certPath) {
+		certPEM, err := utils.ReadFile(certPath)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := utils.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		a, err := ca.Load(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", certPath, err)
+		}
+		brokerCA = a
+		return brokerCA, nil
+	}
+
+	utils.Logger.Printf("client.certificateAuthority: generating a CA in %v\n", certPath)
+	a, err := ca.Generate(caCommonName)
+	if err != nil {
+		return nil, err
+	}
+	utils.MkDir(conf.DataPath)
+	err = utils.WriteFile(keyPath, a.PrivateKeyPEM())
+	if err != nil {
+		return nil, err
+	}
+	err = utils.WriteFile(certPath, a.CertificatePEM())
+	if err != nil {
+		return nil, err
+	}
+	brokerCA = a
+	return brokerCA, nil
+}
+
+// certificateValidity returns how long the node certificates are valid.
+func certificateValidity() time.Duration {
+	if days := config.GetConfig().CertificateValidityDays; days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return defaultCertificateValidity
+}
+
+// CertificateRenewal returns how long before they expire the node
+// certificates are rotated.
+func CertificateRenewal() time.Duration {
+	if days := config.GetConfig().CertificateRenewalDays; days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return defaultCertificateRenewal
+}
+
+// issueCertificate returns a certificate for the nodes of instanceID, valid
+// for all their addresses.  The same certificate is installed on every node.
+func issueCertificate(instanceID string, ips []string) (*ca.Certificate, error) {
+	authority, err := certificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := authority.Issue(instanceID, ips, certificateValidity())
+	if err != nil {
+		return nil, err
+	}
+	utils.Logger.Printf("client.issueCertificate: %v: serial %v for %v, expires %v\n", instanceID, cert.SerialNumber, ips, cert.NotAfter)
+	return cert, nil
+}
+
+// enableTLS makes the nodes use the certificate they were given (see
+// issueCertificate), then encrypts the traffic between them if the plan asks
+// for it.  root is the node the credentials use; all clients must be
+// authenticated as the cluster administrator.
+func enableTLS(root *admin.Client, nodes []*admin.Client, cbProps cbDefaultSettings, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	if cbProps.encryptionLevel != "" && !cbProps.serverVersion.AtLeast(admin.VersionNodeEncryption) {
+		return fmt.Errorf("node-to-node encryption needs Couchbase %v or later, the instance runs %v",
+			admin.VersionNodeEncryption, cbProps.serverVersion)
+	}
+	authority, err := certificateAuthority()
+	if err != nil {
+		return err
+	}
+	progress("installing TLS certificates...")
+	err = root.UploadClusterCA(authority.CertificatePEM())
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		err = node.ReloadCertificate()
+		if err != nil {
+			return err
+		}
+	}
+	if cbProps.encryptionLevel == "" {
+		return nil
+	}
+
+	progress("encrypting the traffic between nodes...")
+	// Couchbase refuses to change node encryption while auto-failover is on
+	err = root.SetAutoFailover(false, 0)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		err = node.EnableNodeEncryption()
+		if err != nil {
+			return err
+		}
+	}
+	err = root.SetClusterEncryptionLevel(cbProps.encryptionLevel)
+	if err != nil {
+		return err
+	}
+	// the plan's cluster settings, applied later, may change this again
+	return root.SetAutoFailover(true, defaultAutoFailoverTimeout)
+}
+
+// reloadCertificates makes the nodes of a TLS instance load a new
+// certificate, e.g. after a rotation.
+func reloadCertificates(credential *model.Credential, ips []string) error {
+	for _, ip := range ips {
+		cb := adminClient(&model.Credential{
+			URI:           admin.SecureNodeURL(ip),
+			UserName:      credential.UserName,
+			Password:      credential.Password,
+			CACertificate: credential.CACertificate,
+		})
+		err := cb.ReloadCertificate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secureCredential switches credential to the TLS endpoints of the nodes at
+// ips, the first of which is the root node.
+func secureCredential(credential *model.Credential, ips []string) error {
+	authority, err := certificateAuthority()
+	if err != nil {
+		return err
+	}
+	credential.URI = admin.SecureNodeURL(ips[0])
+	credential.ConnectionString = "couchbases://" + strings.Join(ips, ",")
+	credential.CACertificate = string(authority.CertificatePEM())
+	return nil
+}
+
+// validateTLS checks the TLS settings of a plan and of the instance.
+func (props cbDefaultSettings) validateTLS() error {
+	switch props.tlsMode {
+	case "", tlsOff, tlsOptional, tlsRequired:
+	default:
+		return fmt.Errorf("unknown tls mode %q (use off, optional or required)", props.tlsMode)
+	}
+	if props.tls && (props.tlsMode == "" || props.tlsMode == tlsOff) {
+		return fmt.Errorf("the plan does not offer TLS")
+	}
+	if !props.tls && props.tlsMode == tlsRequired {
+		return fmt.Errorf("the plan requires TLS")
+	}
+
+	switch props.encryptionLevel {
+	case "":
+		return nil
+	case admin.EncryptionControl, admin.EncryptionAll:
+	default:
+		return fmt.Errorf("unknown tls encryptionLevel %q (use %v or %v)", props.encryptionLevel, admin.EncryptionControl, admin.EncryptionAll)
+	}
+	if props.tlsMode == "" || props.tlsMode == tlsOff {
+		return fmt.Errorf("tls encryptionLevel needs a tls mode of optional or required")
+	}
+	if !props.minVersion.IsZero() && !props.minVersion.AtLeast(admin.VersionNodeEncryption) {
+		return fmt.Errorf("node-to-node encryption needs Couchbase %v or later, the plan allows %v", admin.VersionNodeEncryption, props.minVersion)
+	}
+	return nil
+}
+
+// adminClient returns an administration client for an instance, given its
+// stored credentials.  The client of a TLS instance trusts only its CA.
+func adminClient(credential *model.Credential) *admin.Client {
+	cb := admin.NewClient(credential.URI, credential.UserName, credential.Password)
+	if credential.CACertificate != "" {
+		err := cb.TrustCA([]byte(credential.CACertificate))
+		if err != nil {
+			utils.Logger.Printf("client.adminClient: %v: %v\n", credential.URI, err)
+		}
+	}
+	return cb
+}
+
+// certificateExpiry returns when the certificate presented by the root node
+// of a TLS instance expires.
+func certificateExpiry(credential *model.Credential) (time.Time, error) {
+	pool, err := ca.CertPool([]byte(credential.CACertificate))
+	if err != nil {
+		return time.Time{}, err
+	}
+	u, err := url.Parse(credential.URI)
+	if err != nil {
+		return time.Time{}, err
+	}
+	dialer := &net.Dialer{Timeout: admin.DefaultTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", u.Host, &tls.Config{RootCAs: pool, ServerName: u.Hostname()})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].NotAfter, nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	config "github.com/ssdowd/couchbasebroker/config"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.GetConfig().DataPath = dir
+	defer func() { config.GetConfig().DataPath = "" }()
+	brokerCA = nil
+	defer func() { brokerCA = nil }()
+
+	plan := &model.ServicePlan{
+		Name: "secure",
+		Metadata: map[string]interface{}{
+			"tls": map[string]interface{}{"mode": "optional", "encryptionLevel": "all"},
+		},
+	}
+	props, err := cbInstanceProps(plan, map[string]interface{}{"tls": true})
+	if err != nil {
+		t.Fatalf("cbInstanceProps: %v", err)
+	}
+	if !props.tls {
+		t.Fatalf("the tls parameter should turn TLS on")
+	}
+
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.Version = "6.6.0-7909-enterprise"
+	props.serverVersion, _ = admin.ParseVersion(fake.Version)
+	cb, err := configureCouchbaseNode(fake.URL, props, props.services, "user1", "password1")
+	if err != nil {
+		t.Fatalf("configureCouchbaseNode: %v", err)
+	}
+	if err = enableTLS(cb, []*admin.Client{cb}, props, nil); err != nil {
+		t.Fatalf("enableTLS: %v", err)
+	}
+
+	// the CA was generated, saved, and uploaded to the cluster
+	if !utils.Exists(filepath.Join(dir, caCertificateFile)) || !utils.Exists(filepath.Join(dir, caPrivateKeyFile)) {
+		t.Errorf("the generated CA was not saved in %v", dir)
+	}
+	authority, err := certificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.ClusterCA != string(authority.CertificatePEM()) || fake.CertificateReloads != 1 {
+		t.Errorf("certificate not installed: %d reloads", fake.CertificateReloads)
+	}
+	if !fake.NodeEncryption || fake.Settings["security"]["clusterEncryptionLevel"] != "all" {
+		t.Errorf("node encryption not enabled: %v", fake.Settings["security"])
+	}
+	if fake.Settings["autoFailover"]["enabled"] != "true" {
+		t.Errorf("auto-failover should be back on: %v", fake.Settings["autoFailover"])
+	}
+
+	credential := &model.Credential{URI: fake.URL}
+	if err = secureCredential(credential, []string{"10.244.1.2", "10.244.1.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if credential.URI != "https://10.244.1.2:18091" || credential.ConnectionString != "couchbases://10.244.1.2,10.244.1.3" ||
+		credential.CACertificate == "" {
+		t.Errorf("unexpected credentials %+v", credential)
+	}
+
+	// the stub hands the certificate to the Couchbase job
+	props.certificate, err = issueCertificate("cb-1", []string{"10.244.1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := manifestStub("cb-1", "uuid", props, 1)
+	if err != nil {
+		t.Fatalf("manifestStub: %v", err)
+	}
+	if !strings.Contains(string(stub), "name: couchbase4") || !strings.Contains(string(stub), "private_key:") {
+		t.Errorf("stub lacks the certificate:\n%s", stub)
+	}
+
+	// an older release cannot encrypt node-to-node traffic
+	props.serverVersion = admin.Version{Major: 5}
+	if err = enableTLS(cb, []*admin.Client{cb}, props, nil); err == nil {
+		t.Errorf("expected node encryption to need Couchbase 5.5")
+	}
+
+	invalid := []struct {
+		tls    map[string]interface{}
+		params map[string]interface{}
+	}{
+		{map[string]interface{}{"mode": "sometimes"}, nil},
+		{map[string]interface{}{"mode": "required", "encryptionLevel": "strict"}, nil},
+		{map[string]interface{}{"encryptionLevel": "all"}, nil},
+		{map[string]interface{}{"mode": "required"}, map[string]interface{}{"tls": false}},
+		{map[string]interface{}{"mode": "off"}, map[string]interface{}{"tls": true}},
+	}
+	for _, test := range invalid {
+		plan.Metadata = map[string]interface{}{"tls": test.tls}
+		if _, err = cbInstanceProps(plan, test.params); err == nil {
+			t.Errorf("expected tls %v with parameters %v to be rejected", test.tls, test.params)
+		}
+	}
+	plan.Metadata = map[string]interface{}{"minVersion": "5.0", "tls": map[string]interface{}{"mode": "required", "encryptionLevel": "control"}}
+	if _, err = cbPlanProps(plan); err == nil {
+		t.Errorf("node encryption should need minVersion 5.5")
+	}
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	authority, err := ca.Generate("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := authority.Issue("node", []string{"127.0.0.1"}, 10*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(cert.ChainPEM, cert.PrivateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	server.StartTLS()
+	defer server.Close()
+
+	credential := &model.Credential{URI: server.URL, CACertificate: string(authority.CertificatePEM())}
+	expires, err := certificateExpiry(credential)
+	if err != nil {
+		t.Fatalf("certificateExpiry: %v", err)
+	}
+	if !expires.Equal(cert.NotAfter.Truncate(time.Second)) {
+		t.Errorf("expiry %v, expected %v", expires, cert.NotAfter)
+	}
+	if !ca.NeedsRotation(expires, time.Now(), CertificateRenewal()) {
+		t.Errorf("a certificate expiring in 10 days should be rotated")
+	}
+
+	other, err := ca.Generate("other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential.CACertificate = string(other.CertificatePEM())
+	if _, err = certificateExpiry(credential); err == nil {
+		t.Errorf("expected a certificate from another CA to be refused")
+	}
+}
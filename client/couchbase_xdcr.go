@@ -0,0 +1,89 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// ReplicationClusterName returns the name of the remote cluster reference,
+// on a source cluster, to the instance replicating its bucket.
+func ReplicationClusterName(instanceID string) string {
+	return "cf-replica-" + instanceID
+}
+
+// CreateReplication configures XDCR from the bucket of the source instance to
+// the bucket of the target: a remote cluster reference named name, and a
+// continuous replication to it, both on the source cluster.  The instances are
+// known by their stored administrator credentials.  Replications to a TLS
+// instance are encrypted.
+func CreateReplication(name string, source, target *model.Credential) (*model.Replication, error) {
+	targetURL, err := url.Parse(target.URI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL %v: %v", target.URI, err)
+	}
+	cb := adminClient(source)
+	if target.CACertificate != "" {
+		// an encrypted reference still names the plain administration port
+		hostname := fmt.Sprintf("%s:%d", targetURL.Hostname(), admin.DefaultPort)
+		_, err = cb.CreateSecureRemoteCluster(name, hostname, target.UserName, target.Password, []byte(target.CACertificate))
+	} else {
+		_, err = cb.CreateRemoteCluster(name, targetURL.Host, target.UserName, target.Password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := cb.CreateReplication(source.BucketName, name, target.BucketName)
+	if err != nil {
+		// don't leave the reference behind to clash with a retry
+		rmErr := cb.DeleteRemoteCluster(name)
+		if rmErr != nil {
+			utils.Logger.Printf("client.CreateReplication: removing remote cluster %v: %v\n", name, rmErr)
+		}
+		return nil, err
+	}
+	return &model.Replication{
+		RemoteCluster: name,
+		ReplicationID: id,
+		Status:        "running",
+	}, nil
+}
+
+// UpdateReplicationStatus refreshes the status of replication from the
+// source cluster.  A replication the cluster no longer knows is "missing".
+func UpdateReplicationStatus(source *model.Credential, replication *model.Replication) error {
+	cb := adminClient(source)
+	cb.Retries = 0
+	task, err := cb.Replication(replication.ReplicationID)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		replication.Status = "missing"
+		replication.Errors = nil
+		replication.ChangesLeft = 0
+		return nil
+	}
+	replication.Status = task.Status
+	replication.Errors = task.Errors
+	replication.ChangesLeft = task.ChangesLeft
+	return nil
+}
+
+// RemoveReplication cancels replication and removes its remote cluster
+// reference from the source cluster.  Either may already be gone.
+func RemoveReplication(source *model.Credential, replication *model.Replication) error {
+	cb := adminClient(source)
+	err := cb.CancelReplication(replication.ReplicationID)
+	if err != nil && !admin.IsNotFound(err) {
+		return err
+	}
+	err = cb.DeleteRemoteCluster(replication.RemoteCluster)
+	if err != nil && !admin.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
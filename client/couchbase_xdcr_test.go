@@ -0,0 +1,61 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+func TestReplication(t *testing.T) {
+	source := admintest.NewServer()
+	defer source.Close()
+	source.MemoryQuota = 1024
+	source.AdminUser, source.AdminPassword = "user1", "password1"
+	source.Buckets["orders"] = &admintest.FakeBucket{Name: "orders", BucketType: "couchbase", RAMQuotaMB: 256}
+	sourceCred := &model.Credential{URI: source.URL, UserName: "user1", Password: "password1", BucketName: "orders"}
+	targetCred := &model.Credential{URI: "http://10.244.2.2:8091", UserName: "user2", Password: "password2", BucketName: "orders"}
+
+	name := ReplicationClusterName("replica-1")
+	replication, err := CreateReplication(name, sourceCred, targetCred)
+	if err != nil {
+		t.Fatalf("CreateReplication: %v", err)
+	}
+	remote := source.RemoteClusters[name]
+	if remote == nil || remote.Hostname != "10.244.2.2:8091" || remote.Username != "user2" {
+		t.Errorf("unexpected remote cluster %+v", remote)
+	}
+	if source.Replications[replication.ReplicationID] == nil {
+		t.Errorf("replication %+v not created", replication)
+	}
+
+	source.Replications[replication.ReplicationID].Errors = []string{"target unreachable"}
+	if err = UpdateReplicationStatus(sourceCred, replication); err != nil {
+		t.Fatalf("UpdateReplicationStatus: %v", err)
+	}
+	if replication.Status != "running" || len(replication.Errors) != 1 {
+		t.Errorf("unexpected status %+v", replication)
+	}
+
+	// a failed replication does not leave the reference behind
+	if _, err = CreateReplication("other", sourceCred, &model.Credential{URI: targetCred.URI}); err == nil {
+		t.Errorf("expected a replication without a target bucket to fail")
+	}
+	if source.RemoteClusters["other"] != nil {
+		t.Errorf("remote cluster of the failed replication not removed")
+	}
+
+	if err = RemoveReplication(sourceCred, replication); err != nil {
+		t.Fatalf("RemoveReplication: %v", err)
+	}
+	if len(source.Replications) != 0 || len(source.RemoteClusters) != 0 {
+		t.Errorf("replication not removed: %v %v", source.Replications, source.RemoteClusters)
+	}
+	// removing it again is harmless
+	if err = RemoveReplication(sourceCred, replication); err != nil {
+		t.Errorf("RemoveReplication twice: %v", err)
+	}
+	if err = UpdateReplicationStatus(sourceCred, replication); err != nil || replication.Status != "missing" {
+		t.Errorf("expected a missing replication, got %+v, %v", replication, err)
+	}
+}
@@ -1,9 +1,10 @@
 package client
 
 import (
+	"archive/tar"
+	"bytes"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"strings"
 
@@ -11,6 +12,7 @@ import (
 	uuid "github.com/pborman/uuid"
 
 	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin"
 	model "github.com/ssdowd/couchbasebroker/model"
 	utils "github.com/ssdowd/couchbasebroker/utils"
 )
@@ -62,33 +64,29 @@ func (c *DockerClient) GetInstanceState(instanceID string) (string, error) {
 		ipaddr := container.NetworkSettings.IPAddress
 		cbProps := cbDefaultProps()
 
-		// now test the Couchbase instance at that address...
-		client := &http.Client{}
-		cburl := fmt.Sprintf("http://%s:%d", ipaddr, 8091)
-
 		// if the default admin password does not work, we must have provisioned it...
-		request, err := http.NewRequest("GET", fmt.Sprintf("%s/pools/default", cburl), nil)
-		request.SetBasicAuth(cbProps.adminUser, cbProps.adminPass)
-		response, err := client.Do(request)
+		cb := admin.NewClient(admin.NodeURL(ipaddr), cbProps.adminUser, cbProps.adminPass)
+		cb.Retries = 0
+		_, err := cb.GetPool()
+		if admin.IsUnauthorized(err) {
+			return "running", nil
+		}
 		if err != nil {
-			utils.Logger.Printf("client.docker.GetCredentials: error in http POST %v\n", err)
 			// this is tricky - a connection refused can mean it's dead ot not warmed up yet.
 			// but we don't want to wait here.
-			return "pending", nil
+			utils.Logger.Printf("client.docker.GetInstanceState: %v\n", err)
 		}
-		switch response.StatusCode {
-		case http.StatusUnauthorized:
-			return "running", nil
-		case http.StatusOK:
-			return "pending", nil
-		}
-
 		return "pending", nil
 	}
 
 	return "pending", nil
 }
 
+// GetInstanceDescription returns "": a container does not say more.
+func (c *DockerClient) GetInstanceDescription(instanceID string) string {
+	return ""
+}
+
 // IsValidPlan returns a boolean indicating whether the given planName is in the catalog.
 func (c *DockerClient) IsValidPlan(planName string) bool {
 	if c.catalog == nil {
@@ -96,20 +94,16 @@ func (c *DockerClient) IsValidPlan(planName string) bool {
 		return false
 	}
 
-	// TODO: loop through services in the catalog, and look for a matching plan name
-	for _, s := range c.catalog.Services {
-		for _, p := range s.Plans {
-			if p.Name == planName {
-				return true
-			}
-		}
-	}
-	return false
+	return c.catalog.FindPlan(planName) != nil
 }
 
 // CreateInstance is the equivalent of: docker run -d --name=cb-test couchbase.
-func (c *DockerClient) CreateInstance(parameters interface{}) (string, error) {
-	// for now we ignore any parameters...
+func (c *DockerClient) CreateInstance(plan *model.ServicePlan, parameters interface{}, orgID string) (string, error) {
+	// check the parameters now rather than when the container is configured
+	err := c.ValidateParameters(plan, parameters)
+	if err != nil {
+		return "", err
+	}
 
 	// get a docker client
 	dclient, err := c.createDockerClient()
@@ -151,7 +145,7 @@ func (c *DockerClient) CreateInstance(parameters interface{}) (string, error) {
 }
 
 // DeleteInstance will delete the supplied instanceID from the Docker host.
-func (c *DockerClient) DeleteInstance(instanceID string) error {
+func (c *DockerClient) DeleteInstance(instanceID string, orgID string) error {
 	utils.Logger.Printf("client.docker.DeleteInstance: %v\n", instanceID)
 	// get a docker client
 	dclient, err := c.createDockerClient()
@@ -188,8 +182,33 @@ func (c *DockerClient) DeleteInstance(instanceID string) error {
 	return nil
 }
 
-// GetCredentials will configure the Couchbase instance with credentials and a bucket and other settings.
-func (c *DockerClient) GetCredentials(instanceID string) (*model.Credential, error) {
+// ValidateParameters checks the parameters against the plan, which must be
+// for a single node.
+func (c *DockerClient) ValidateParameters(plan *model.ServicePlan, parameters interface{}) error {
+	cbProps, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		return err
+	}
+	if cbProps.instances != 1 {
+		return fmt.Errorf("only single node instances are supported, not %d", cbProps.instances)
+	}
+	return nil
+}
+
+// ValidateUpdate refuses every update: see UpdateInstance.
+func (c *DockerClient) ValidateUpdate(plan *model.ServicePlan, parameters interface{}) error {
+	return errors.New("updating a Docker instance is not supported")
+}
+
+// UpdateInstance is a stub to implement the Client interface: Docker
+// instances are single nodes, so there is nothing to scale.
+func (c *DockerClient) UpdateInstance(instanceID string, plan *model.ServicePlan, parameters interface{}, credential *model.Credential, progress ProgressFunc) error {
+	return errors.New("UpdateInstance not implemented for Docker")
+}
+
+// GetCredentials will configure the Couchbase instance with credentials and a
+// bucket and other settings, sized according to the plan.
+func (c *DockerClient) GetCredentials(instanceID string, plan *model.ServicePlan, parameters interface{}, progress ProgressFunc) (*model.Credential, error) {
 	utils.Logger.Printf("client.docker.GetCredentials: %v\n", instanceID)
 
 	// get a docker client
@@ -209,99 +228,79 @@ func (c *DockerClient) GetCredentials(instanceID string) (*model.Credential, err
 		return nil, fmt.Errorf("client.docker.GetCredentials: %v was not running", instanceID)
 	}
 	ipaddr := container.NetworkSettings.IPAddress
-	cbProps := cbDefaultProps()
+	cbProps, err := cbInstanceProps(plan, parameters)
+	if err != nil {
+		return nil, err
+	}
 
 	// now configure the Couchbase instance at that address...
-	client := &http.Client{}
-	cburl := fmt.Sprintf("http://%s:%d", ipaddr, 8091)
-
-	// ${CURL} -u Administrator:password -X POST http://${IP}:8091/pools/default -d memoryQuota=${MEMORYQUOTA}
-	request, err := http.NewRequest("POST", fmt.Sprintf("%s/pools/default", cburl), strings.NewReader(fmt.Sprintf("memoryQuota=%d", cbProps.ramQuota)))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err := client.Do(request)
+	userID := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
+	passwd := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
+	saslpasswd := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
+	cbProps.serverVersion, err = detectVersion(admin.NewClient(admin.NodeURL(ipaddr), cbProps.adminUser, cbProps.adminPass), cbProps)
 	if err != nil {
-		utils.Logger.Printf("client.docker.GetCredentials: error in http POST %v\n", err)
 		return nil, err
 	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(1): %v", response.StatusCode)
-	}
-
-	// ${CURL} -u id:pw -X POST http://${IP}:8091/pools/default -d indexMemoryQuota=${INDEXQUOTA}
-	request, err = http.NewRequest("POST", fmt.Sprintf("%s/pools/default", cburl), strings.NewReader(fmt.Sprintf("indexMemoryQuota=%d", cbProps.indexRAMQuota)))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err = client.Do(request)
+	cb, err := configureCouchbaseNode(admin.NodeURL(ipaddr), cbProps, cbProps.services, userID, passwd)
 	if err != nil {
+		utils.Logger.Printf("client.docker.GetCredentials: %v\n", err)
 		return nil, err
 	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(2): %v", response.StatusCode)
+	if cbProps.tls {
+		err = c.installCertificate(dclient, instanceID, ipaddr)
+		if err != nil {
+			return nil, &FatalError{err}
+		}
+		err = enableTLS(cb, []*admin.Client{cb}, cbProps, progress)
+		if err != nil {
+			return nil, &FatalError{err}
+		}
 	}
-
-	// ${CURL} -u Administrator:password -X POST http://${IP}:8091/node/controller/setupServices -d services=${SERVICES}
-	request, err = http.NewRequest("POST", fmt.Sprintf("%s/node/controller/setupServices", cburl), strings.NewReader("services=kv%2Cindex%2Cn1ql"))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	client = &http.Client{}
-	response, err = client.Do(request)
+	err = configureClusterSettings(cb, cbProps, []clusterNode{{ip: ipaddr, services: cbProps.services}}, progress)
 	if err != nil {
-		return nil, err
+		return nil, &FatalError{err}
 	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(3): %v", response.StatusCode)
+	credential, err := createCouchbaseBucket(cb, cbProps, userID, passwd, saslpasswd)
+	if err != nil {
+		return nil, &FatalError{err}
 	}
-
-	// override the default ID/password
-	// ${CURL} -o /dev/null -u Administrator:password -X POST http://${IP}:8091/settings/web -d password=${PASSWORD} -d username=${USERNAME} -d port=8091
-	userID := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
-	passwd := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
-	saslpasswd := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
-	bucketName := "cfdefault"
-	credentials := model.Credential{
-		URI:          cburl,
-		UserName:     userID,
-		Password:     passwd,
-		SASLPassword: saslpasswd,
-		BucketName:   bucketName,
-	}
-	utils.Logger.Printf("client.docker.GetCredentials: %v\n", credentials)
-	request, err = http.NewRequest("POST",
-		fmt.Sprintf("%s/settings/web", cburl),
-		strings.NewReader(fmt.Sprintf("username=%s&password=%s&port=%d", credentials.UserName, credentials.Password, cbProps.port)))
-	request.SetBasicAuth("Administrator", "password")
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err = client.Do(request)
+	err = loadCouchbaseData(cb, cbProps, progress)
 	if err != nil {
-		return nil, err
+		return nil, &FatalError{err}
+	}
+	err = createCouchbaseIndexes(admin.NewClient(admin.QueryURL(ipaddr), userID, passwd), cbProps, progress)
+	if err != nil {
+		return nil, &FatalError{err}
 	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(4): %v", response.StatusCode)
+	if cbProps.tls {
+		err = secureCredential(credential, []string{ipaddr})
+		if err != nil {
+			return nil, &FatalError{err}
+		}
 	}
+	return credential, nil
+}
 
-	// ${CURL} -u ${USERNAME}:${PASSWORD} -X POST http://${IP}:8091/pools/default/buckets \
-	//   -d name=${BUCKET} -d bucketType=couchbase -d ramQuotaMB=${BUCKETRAM} -d proxyPort=9999 \
-	//   -d authType=sasl -d saslPassword=${SASLPASSWORD}
-	request, err = http.NewRequest("POST",
-		fmt.Sprintf("%s/pools/default/buckets", cburl),
-		strings.NewReader(fmt.Sprintf("name=%s&bucketType=couchbase&ramQuotaMB=%d&authType=sasl&saslPassword=%s", bucketName, 768, saslpasswd)))
-	request.SetBasicAuth(userID, passwd)
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err = client.Do(request)
+// RotateCertificate issues a new certificate for the container and makes
+// Couchbase load it.
+func (c *DockerClient) RotateCertificate(instanceID string, plan *model.ServicePlan, parameters interface{}, credential *model.Credential, progress ProgressFunc) error {
+	if credential.CACertificate == "" {
+		return fmt.Errorf("client.docker.RotateCertificate: %v does not use TLS", instanceID)
+	}
+	dclient, err := c.createDockerClient()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	switch response.StatusCode {
-	case http.StatusBadRequest, http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad response from Couchbase(5): %v", response.StatusCode)
+	container, err := dclient.InspectContainer(instanceID)
+	if err != nil {
+		return err
 	}
-
-	return &credentials, nil
+	ipaddr := container.NetworkSettings.IPAddress
+	err = c.installCertificate(dclient, instanceID, ipaddr)
+	if err != nil {
+		return err
+	}
+	return reloadCertificates(credential, []string{ipaddr})
 }
 
 // RemoveCredentials is a stub to implement the Client interface.
@@ -311,8 +310,13 @@ func (c *DockerClient) RemoveCredentials(instanceID string, bindingID string) er
 	return nil
 }
 
-// SetCatalog sets the catalog object for this broker.
+// SetCatalog sets the catalog object for this broker, after checking that
+// every plan in it describes a valid Couchbase configuration.
 func (c *DockerClient) SetCatalog(catalog *model.Catalog) error {
+	err := validateCatalogPlans(catalog)
+	if err != nil {
+		return err
+	}
 	c.catalog = catalog
 	return nil
 }
@@ -351,6 +355,65 @@ func (c *DockerClient) createDockerClient() (*dockerclient.Client, error) {
 	return dockerclient.NewTLSClient(endpoint, cert, key, ca)
 }
 
+// couchbaseDataDir is where the Couchbase image keeps its configuration; node
+// certificates are picked up from its inbox directory.
+const couchbaseDataDir = "/opt/couchbase/var/lib/couchbase"
+
+// couchbaseUID is the user the Couchbase image runs as.
+const couchbaseUID = 1000
+
+// installCertificate issues a certificate for the container at ipaddr and
+// copies it to the Couchbase inbox.  Couchbase uses it once told to reload.
+func (c *DockerClient) installCertificate(dclient *dockerclient.Client, instanceID, ipaddr string) error {
+	cert, err := issueCertificate(instanceID, []string{ipaddr})
+	if err != nil {
+		return err
+	}
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	err = tw.WriteHeader(&tar.Header{
+		Name:     "inbox/",
+		Typeflag: tar.TypeDir,
+		Mode:     0700,
+		Uid:      couchbaseUID,
+		Gid:      couchbaseUID,
+	})
+	if err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"inbox/chain.pem", cert.ChainPEM},
+		{"inbox/pkey.key", cert.PrivateKeyPEM},
+	}
+	for _, f := range files {
+		err = tw.WriteHeader(&tar.Header{
+			Name: f.name,
+			Mode: 0600,
+			Size: int64(len(f.content)),
+			Uid:  couchbaseUID,
+			Gid:  couchbaseUID,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(f.content)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return dclient.UploadToContainer(instanceID, dockerclient.UploadToContainerOptions{
+		InputStream: &archive,
+		Path:        couchbaseDataDir,
+	})
+}
+
 func (c *DockerClient) configure(ipAddress string) error {
 	return nil
 }
@@ -3,17 +3,63 @@ package config
 import (
 	"encoding/json"
 	"strings"
+	"sync"
 
 	"github.com/ssdowd/couchbasebroker/utils"
 )
 
 // A BoshConfig holds the information needed to communicate with a BOSH director.
+// DirectorPassword and DirectorClientSecret may be given as "env:VAR" or
+// "file:/path" (see ResolveSecret).
 type BoshConfig struct {
 	DirectorURL      string `json:"director_url"`
 	DirectorUser     string `json:"director_user"`
 	DirectorPassword string `json:"director_password"`
-	TemplateDir      string `json:"template_dir"`
-	DataDir          string `json:"data_dir"`
+
+	// With DirectorClient set, the broker authenticates to the director with
+	// UAA client credentials instead of DirectorUser.  DirectorUAAURL
+	// defaults to the UAA the director advertises.
+	DirectorUAAURL       string `json:"director_uaa_url"`
+	DirectorClient       string `json:"director_client"`
+	DirectorClientSecret string `json:"director_client_secret"`
+
+	// DirectorCACert is the PEM encoded CA certificate of the director (and
+	// of its UAA), or the path of a file holding it.  Without it the system
+	// roots are trusted.  DirectorInsecureSkipVerify turns verification
+	// off, for development directors only.
+	DirectorCACert             string `json:"director_ca_cert"`
+	DirectorInsecureSkipVerify bool   `json:"director_insecure_skip_verify"`
+
+	// MaxDirectorTasks is the number of deploys and deletes the broker runs
+	// on the director at once (default 2, negative for no limit).
+	MaxDirectorTasks int `json:"max_director_tasks"`
+
+	// DirectorAssetCheck decides what happens to the plans whose manifests
+	// reference releases or stemcells the director lacks: "plans" (the
+	// default) leaves them out of the catalog, "strict" rejects the catalog
+	// and "off" skips the check.
+	DirectorAssetCheck string `json:"director_asset_check"`
+
+	TemplateDir string `json:"template_dir"`
+	DataDir     string `json:"data_dir"`
+
+	// TemplateProfiles are the sets of templates deployment manifests can be
+	// built from, by name.  TemplateProfile names the one of the plans that
+	// choose neither a profile nor a cloudConfig; without it the broker uses
+	// its bosh-lite templates.
+	TemplateProfile  string                      `json:"template_profile"`
+	TemplateProfiles map[string]*TemplateProfile `json:"template_profiles"`
+
+	mutex sync.RWMutex
+}
+
+// A TemplateProfile is the templates a deployment manifest is built from, for
+// a kind of director, e.g. bosh-lite or vSphere.
+type TemplateProfile struct {
+	// Templates are the files merged in order, relative to TemplateDir.
+	Templates []string `json:"templates"`
+	// Vars are the values the templates grab as vars.<name>.
+	Vars map[string]interface{} `json:"vars"`
 }
 
 // BoshOptions holds
@@ -23,6 +69,7 @@ type BoshConfig struct {
 
 var (
 	currentBoshConfiguration BoshConfig
+	currentBoshConfigPath    string
 )
 
 // LoadBoshConfig loads the BOSH configuration in the given path and returns a BoshConfig.
@@ -32,15 +79,27 @@ func LoadBoshConfig(path string) (*BoshConfig, error) {
 	}
 	bytes, err := utils.ReadFile(path)
 	if err != nil {
-		currentBoshConfiguration = defaultBoshProperties()
+		setDefaultBoshProperties()
 		return &currentBoshConfiguration, err
 	}
 
 	err = json.Unmarshal(bytes, &currentBoshConfiguration)
 	if err != nil {
-		currentBoshConfiguration = defaultBoshProperties()
+		setDefaultBoshProperties()
 		return &currentBoshConfiguration, err
 	}
+	currentBoshConfigPath = path
+
+	password, err := ResolveSecret(currentBoshConfiguration.DirectorPassword)
+	if err != nil {
+		return &currentBoshConfiguration, err
+	}
+	currentBoshConfiguration.DirectorPassword = password
+	clientSecret, err := ResolveSecret(currentBoshConfiguration.DirectorClientSecret)
+	if err != nil {
+		return &currentBoshConfiguration, err
+	}
+	currentBoshConfiguration.DirectorClientSecret = clientSecret
 	return &currentBoshConfiguration, nil
 }
 
@@ -49,11 +108,66 @@ func GetBoshConfig() *BoshConfig {
 	return &currentBoshConfiguration
 }
 
-func defaultBoshProperties() BoshConfig {
-	return BoshConfig{
-		DirectorURL:      "https://localhost:25555",
-		DirectorUser:     "user",
-		DirectorPassword: "password",
-		TemplateDir:      "unknown",
+// DirectorCredentials returns the user and password used to authenticate to the director.
+func (b *BoshConfig) DirectorCredentials() (string, string) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.DirectorUser, b.DirectorPassword
+}
+
+// UsesUAA reports whether the director is called with UAA tokens.
+func (b *BoshConfig) UsesUAA() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.DirectorClient != ""
+}
+
+// DirectorClientCredentials returns the UAA client and secret used to get
+// director tokens.
+func (b *BoshConfig) DirectorClientCredentials() (string, string) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.DirectorClient, b.DirectorClientSecret
+}
+
+// reloadBoshConfigSecrets re-reads the BOSH config file and updates only the
+// director credentials (user or UAA client).
+func reloadBoshConfigSecrets() error {
+	if currentBoshConfigPath == "" {
+		return nil
 	}
+	bytes, err := utils.ReadFile(currentBoshConfigPath)
+	if err != nil {
+		return err
+	}
+
+	var fresh BoshConfig
+	err = json.Unmarshal(bytes, &fresh)
+	if err != nil {
+		return err
+	}
+	password, err := ResolveSecret(fresh.DirectorPassword)
+	if err != nil {
+		return err
+	}
+	clientSecret, err := ResolveSecret(fresh.DirectorClientSecret)
+	if err != nil {
+		return err
+	}
+
+	currentBoshConfiguration.mutex.Lock()
+	defer currentBoshConfiguration.mutex.Unlock()
+	currentBoshConfiguration.DirectorUser = fresh.DirectorUser
+	currentBoshConfiguration.DirectorPassword = password
+	currentBoshConfiguration.DirectorClient = fresh.DirectorClient
+	currentBoshConfiguration.DirectorClientSecret = clientSecret
+	return nil
+}
+
+func setDefaultBoshProperties() {
+	currentBoshConfiguration.DirectorURL = "https://localhost:25555"
+	currentBoshConfiguration.DirectorUser = "user"
+	currentBoshConfiguration.DirectorPassword = "password"
+	currentBoshConfiguration.TemplateDir = "unknown"
+	currentBoshConfiguration.DataDir = ""
 }
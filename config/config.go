@@ -2,11 +2,17 @@ package config
 
 import (
 	"encoding/json"
+	"sync"
+
 	"github.com/ssdowd/couchbasebroker/utils"
 )
 
 // Config holds the configuration info for the broker (port, data file path,
-// catalog path, instances file, bindings file, rest ID/password).
+// catalog path, instances file, bindings file, rest ID/password) and the
+// optional CredHub settings used to store binding credentials.
+//
+// RestPassword, CredHubSecret, CACertificate and CAPrivateKey may be given as
+// "env:VAR" or "file:/path" (see ResolveSecret).
 type Config struct {
 	Port                     string `json:"port"`
 	DataPath                 string `json:"data_path"`
@@ -15,10 +21,41 @@ type Config struct {
 	ServiceBindingsFileName  string `json:"service_bindings_file_name"`
 	RestUser                 string `json:"restuser"`
 	RestPassword             string `json:"restpassword"`
+
+	// SeedDataPath holds the JSON-lines document sets that the seed
+	// provision parameter can load (default data/seed).
+	SeedDataPath string `json:"seed_data_path"`
+
+	// HealthCheckIntervalSeconds is how often the health of provisioned
+	// instances is polled (default 60, negative to disable).
+	HealthCheckIntervalSeconds int `json:"health_check_interval_seconds"`
+
+	// CACertificate and CAPrivateKey are the PEM encoded CA that signs the
+	// certificates of TLS instances.  Without them, the broker generates a
+	// CA and keeps it in DataPath.  CertificateValidityDays (default 365) is
+	// how long node certificates are valid, and CertificateRenewalDays
+	// (default 30) how long before expiry they are rotated.
+	CACertificate           string `json:"ca_certificate"`
+	CAPrivateKey            string `json:"ca_private_key"`
+	CertificateValidityDays int    `json:"certificate_validity_days"`
+	CertificateRenewalDays  int    `json:"certificate_renewal_days"`
+
+	BrokerName    string `json:"broker_name"`
+	CredHubURL    string `json:"credhub_url"`
+	CredHubUAAURL string `json:"credhub_uaa_url"`
+	CredHubClient string `json:"credhub_client"`
+	CredHubSecret string `json:"credhub_secret"`
+	// CredHubCACert is the PEM encoded CA, or the path of a file holding
+	// it, that signs the certificates of CredHub and its UAA.  The system
+	// roots are trusted if it is not set.
+	CredHubCACert string `json:"credhub_ca_cert"`
+
+	mutex sync.RWMutex
 }
 
 var (
 	currentConfiguration Config
+	currentConfigPath    string
 )
 
 // LoadConfig loads and returns the configuration at the indicated path.
@@ -32,6 +69,12 @@ func LoadConfig(path string) (*Config, error) {
 	if err != nil {
 		return &currentConfiguration, err
 	}
+	currentConfigPath = path
+
+	err = currentConfiguration.resolveSecrets()
+	if err != nil {
+		return &currentConfiguration, err
+	}
 	return &currentConfiguration, nil
 }
 
@@ -39,3 +82,76 @@ func LoadConfig(path string) (*Config, error) {
 func GetConfig() *Config {
 	return &currentConfiguration
 }
+
+// RestCredentials returns the user and password clients must use to call the broker.
+func (c *Config) RestCredentials() (string, string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.RestUser, c.RestPassword
+}
+
+// CredHubClientCredentials returns the UAA client and secret used to get
+// CredHub tokens.
+func (c *Config) CredHubClientCredentials() (string, string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.CredHubClient, c.CredHubSecret
+}
+
+func (c *Config) resolveSecrets() error {
+	restPassword, err := ResolveSecret(c.RestPassword)
+	if err != nil {
+		return err
+	}
+	credHubSecret, err := ResolveSecret(c.CredHubSecret)
+	if err != nil {
+		return err
+	}
+	caCertificate, err := ResolveSecret(c.CACertificate)
+	if err != nil {
+		return err
+	}
+	caPrivateKey, err := ResolveSecret(c.CAPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.RestPassword = restPassword
+	c.CredHubSecret = credHubSecret
+	c.CACertificate = caCertificate
+	c.CAPrivateKey = caPrivateKey
+	return nil
+}
+
+// reloadConfigSecrets re-reads the config file and updates only its secret
+// fields; everything else keeps the value loaded at startup.  The CA is not
+// reloaded: the nodes of TLS instances trust the CA they were given, so a new
+// one would only sign certificates they reject.
+func reloadConfigSecrets() error {
+	if currentConfigPath == "" {
+		return nil
+	}
+	bytes, err := utils.ReadFile(currentConfigPath)
+	if err != nil {
+		return err
+	}
+
+	var fresh Config
+	err = json.Unmarshal(bytes, &fresh)
+	if err != nil {
+		return err
+	}
+	err = fresh.resolveSecrets()
+	if err != nil {
+		return err
+	}
+
+	currentConfiguration.mutex.Lock()
+	defer currentConfiguration.mutex.Unlock()
+	currentConfiguration.RestUser = fresh.RestUser
+	currentConfiguration.RestPassword = fresh.RestPassword
+	currentConfiguration.CredHubSecret = fresh.CredHubSecret
+	return nil
+}
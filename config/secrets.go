@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ssdowd/couchbasebroker/utils"
+)
+
+// A SecretResolver looks up the value of a secret given the reference that
+// follows its scheme prefix, e.g. "VAR" for "env:VAR".
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to the SecretResolver interface.
+type SecretResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref).
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	resolverMutex   sync.RWMutex
+	secretResolvers = map[string]SecretResolver{
+		"env":  SecretResolverFunc(resolveEnvSecret),
+		"file": SecretResolverFunc(resolveFileSecret),
+	}
+)
+
+// RegisterSecretResolver makes resolver handle secret values of the form
+// "scheme:ref", so external secret backends can be plugged in.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	resolverMutex.Lock()
+	defer resolverMutex.Unlock()
+	secretResolvers[scheme] = resolver
+}
+
+// ResolveSecret returns the value of a secret configuration field.  Values
+// prefixed with a registered scheme ("env:VAR", "file:/path", ...) are looked
+// up through the matching resolver; anything else is returned as-is.
+func ResolveSecret(value string) (string, error) {
+	idx := strings.Index(value, ":")
+	if idx <= 0 {
+		return value, nil
+	}
+
+	resolverMutex.RLock()
+	resolver, ok := secretResolvers[value[:idx]]
+	resolverMutex.RUnlock()
+	if !ok {
+		return value, nil
+	}
+	secret, err := resolver.Resolve(value[idx+1:])
+	if err != nil {
+		return "", fmt.Errorf("could not resolve secret %q: %v", value, err)
+	}
+	return secret, nil
+}
+
+func resolveEnvSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+func resolveFileSecret(path string) (string, error) {
+	bytes, err := utils.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(bytes), "\r\n"), nil
+}
+
+// ReloadSecrets re-reads the configuration files loaded so far and resolves
+// their secret fields again, so passwords can be rotated without a restart.
+func ReloadSecrets() error {
+	err := reloadConfigSecrets()
+	if err != nil {
+		return err
+	}
+	return reloadBoshConfigSecrets()
+}
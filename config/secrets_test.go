@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	os.Setenv("CBBROKER_TEST_SECRET", "from-env")
+	defer os.Unsetenv("CBBROKER_TEST_SECRET")
+
+	f, err := ioutil.TempFile("", "cbbroker-secret-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("from-file\n")
+	f.Close()
+
+	RegisterSecretResolver("vault", SecretResolverFunc(func(ref string) (string, error) {
+		if ref == "secret/broker" {
+			return "from-vault", nil
+		}
+		return "", errors.New("no such secret")
+	}))
+
+	cases := map[string]string{
+		"plain":                    "plain",
+		"env:CBBROKER_TEST_SECRET": "from-env",
+		"file:" + f.Name():         "from-file",
+		"vault:secret/broker":      "from-vault",
+		"unknown:value":            "unknown:value",
+	}
+	for value, expected := range cases {
+		got, err := ResolveSecret(value)
+		if err != nil {
+			t.Errorf("ResolveSecret(%q) failed: %v", value, err)
+		}
+		if got != expected {
+			t.Errorf("ResolveSecret(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+
+	for _, value := range []string{"env:CBBROKER_TEST_UNSET", "file:/nonexistent/secret", "vault:other"} {
+		if _, err := ResolveSecret(value); err == nil {
+			t.Errorf("ResolveSecret(%q) should fail", value)
+		}
+	}
+}
+
+func TestReloadSecrets(t *testing.T) {
+	defer func(path, boshPath string) {
+		currentConfigPath, currentBoshConfigPath = path, boshPath
+	}(currentConfigPath, currentBoshConfigPath)
+	dir, err := ioutil.TempDir("", "cbbroker-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	password := write("password", "old")
+	ca := write("ca", "old-ca")
+	directorPassword := write("director_password", "old-director")
+	configPath := write("config.json", `{"restuser": "admin", "restpassword": "file:`+password+`", "ca_certificate": "file:`+ca+`"}`)
+	boshConfigPath := write("boshconfig.json", `{"director_password": "file:`+directorPassword+`"}`)
+	conf, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boshConf, err := LoadBoshConfig(boshConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("password", "new")
+	write("ca", "new-ca")
+	write("director_password", "new-director")
+	err = ReloadSecrets()
+	if err != nil {
+		t.Fatalf("ReloadSecrets failed: %v", err)
+	}
+	if user, pw := conf.RestCredentials(); user != "admin" || pw != "new" {
+		t.Errorf("reloaded broker credentials: %q %q", user, pw)
+	}
+	if boshConf.DirectorPassword != "new-director" {
+		t.Errorf("reloaded director password: %q", boshConf.DirectorPassword)
+	}
+	// the CA is only read at startup
+	if conf.CACertificate != "old-ca" {
+		t.Errorf("reloaded CA: %q", conf.CACertificate)
+	}
+
+	// a secret that cannot be resolved leaves the loaded ones alone
+	write("config.json", `{"restuser": "admin", "restpassword": "env:CBBROKER_TEST_UNSET"}`)
+	if err := ReloadSecrets(); err == nil {
+		t.Errorf("ReloadSecrets of an unresolvable secret should fail")
+	}
+	if _, pw := conf.RestCredentials(); pw != "new" {
+		t.Errorf("broker password after a failed reload: %q", pw)
+	}
+}
@@ -0,0 +1,95 @@
+package admintest
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// A FakeIndex is a N1QL index on the fake cluster.
+type FakeIndex struct {
+	Name      string
+	Bucket    string
+	Primary   bool
+	Statement string
+	// buildPolls is how many more listings report the index as building.
+	buildPolls int
+}
+
+var (
+	createPrimaryIndex = regexp.MustCompile("^CREATE PRIMARY INDEX (?:`([^`]+)` )?ON `([^`]+)`")
+	createIndex        = regexp.MustCompile("^CREATE INDEX `([^`]+)` ON `([^`]+)`")
+	listIndexes        = regexp.MustCompile(`FROM system:indexes WHERE keyspace_id = "([^"]+)"`)
+)
+
+// handleQuery understands just enough N1QL to create and list indexes.
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	statement := strings.TrimSpace(r.FormValue("statement"))
+
+	if m := listIndexes.FindStringSubmatch(statement); m != nil {
+		results := []map[string]interface{}{}
+		for _, index := range s.Indexes {
+			if index.Bucket != m[1] {
+				continue
+			}
+			state := "online"
+			if index.buildPolls > 0 {
+				index.buildPolls--
+				state = "building"
+			}
+			results = append(results, map[string]interface{}{
+				"name":        index.Name,
+				"keyspace_id": index.Bucket,
+				"is_primary":  index.Primary,
+				"state":       state,
+			})
+		}
+		writeQueryResults(w, results)
+		return
+	}
+
+	var name, bucket string
+	primary := false
+	if m := createPrimaryIndex.FindStringSubmatch(statement); m != nil {
+		name, bucket, primary = m[1], m[2], true
+		if name == "" {
+			name = "#primary"
+		}
+	} else if m := createIndex.FindStringSubmatch(statement); m != nil {
+		name, bucket = m[1], m[2]
+	} else {
+		writeQueryError(w, http.StatusBadRequest, 3000, "syntax error - at "+statement)
+		return
+	}
+
+	if _, ok := s.Buckets[bucket]; !ok {
+		writeQueryError(w, http.StatusInternalServerError, 12003,
+			fmt.Sprintf("Keyspace not found keyspace %s - cause: No bucket named %s", bucket, bucket))
+		return
+	}
+	key := bucket + "/" + name
+	if _, ok := s.Indexes[key]; ok {
+		writeQueryError(w, http.StatusInternalServerError, 5000, "GSI CreateIndex() - cause: Index "+name+" already exists.")
+		return
+	}
+	s.Indexes[key] = &FakeIndex{
+		Name:       name,
+		Bucket:     bucket,
+		Primary:    primary,
+		Statement:  statement,
+		buildPolls: s.IndexBuildSteps,
+	}
+	writeQueryResults(w, []interface{}{})
+}
+
+func writeQueryResults(w http.ResponseWriter, results interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "results": results})
+}
+
+func writeQueryError(w http.ResponseWriter, code int, errorCode int, msg string) {
+	writeJSON(w, code, map[string]interface{}{
+		"status": "errors",
+		"errors": []map[string]interface{}{{"code": errorCode, "msg": msg}},
+	})
+}
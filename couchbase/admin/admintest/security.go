@@ -0,0 +1,49 @@
+package admintest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func (s *Server) handleUploadClusterCA(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if !strings.Contains(string(body), "BEGIN CERTIFICATE") {
+		writeListError(w, http.StatusBadRequest, "Malformed certificate")
+		return
+	}
+	s.ClusterCA = string(body)
+	writeJSON(w, http.StatusOK, map[string]string{"cert": s.ClusterCA})
+}
+
+func (s *Server) handleReloadCertificate(w http.ResponseWriter, r *http.Request) {
+	if s.ClusterCA == "" {
+		writeListError(w, http.StatusBadRequest, "Cluster CA needs to be set before setting node certificate.")
+		return
+	}
+	s.CertificateReloads++
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleNetConfig serves the listener and network configuration calls that
+// switch a node to encrypted node-to-node traffic.
+func (s *Server) handleNetConfig(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/setupNetConfig") {
+		s.NodeEncryption = r.FormValue("nodeEncryption") == "on"
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleSecuritySettings(w http.ResponseWriter, r *http.Request) {
+	if level := r.FormValue("clusterEncryptionLevel"); level != "" {
+		if !s.NodeEncryption {
+			writeFieldError(w, "clusterEncryptionLevel", "Can't set cluster encryption level when cluster encryption is disabled.")
+			return
+		}
+		if level != "control" && level != "all" && level != "strict" {
+			writeFieldError(w, "clusterEncryptionLevel", "Cluster encryption level must be one of ['control', 'all', 'strict']")
+			return
+		}
+	}
+	s.handleSettings("security")(w, r)
+}
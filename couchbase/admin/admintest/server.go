@@ -0,0 +1,700 @@
+// Package admintest provides an in-memory fake of the Couchbase cluster
+// administration REST API for tests.
+package admintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default credentials of a freshly installed node.
+const (
+	DefaultAdminUser     = "Administrator"
+	DefaultAdminPassword = "password"
+)
+
+// DefaultVersion is the Couchbase version the fake reports unless told otherwise.
+const DefaultVersion = "4.5.1-2844-enterprise"
+
+// A FakeBucket is a bucket created on the fake cluster.
+type FakeBucket struct {
+	Name                   string
+	BucketType             string
+	RAMQuotaMB             int
+	ReplicaNumber          int
+	EvictionPolicy         string
+	CompressionMode        string
+	MaxTTL                 int
+	ConflictResolutionType string
+	AuthType               string
+	SASLPassword           string
+	FlushEnabled           bool
+	ItemCount              int64
+	DataUsed               int64
+	MemUsed                int64
+	// Docs holds the documents stored through the REST API, by id.
+	Docs map[string]string
+	// Scopes maps the scopes of the bucket to their collections.
+	Scopes map[string][]string
+
+	// loadingPolls is how many more task listings report a sample bucket
+	// as still loading.
+	loadingPolls int
+}
+
+// A FakeNode is a node of the fake cluster.
+type FakeNode struct {
+	Hostname   string
+	OTPNode    string
+	Services   []string
+	Membership string
+	Status     string
+	// Group is the node's server group (the first group if empty).
+	Group string
+}
+
+// A FakeUser is a local RBAC user of the fake cluster.
+type FakeUser struct {
+	Password string
+	Roles    []string
+}
+
+// A Server is a fake Couchbase cluster.  Exported fields may be inspected
+// (and set before use) by tests; hold Lock while the server is in use.
+type Server struct {
+	*httptest.Server
+	sync.Mutex
+
+	// Version is the Couchbase version reported by /pools.  It decides
+	// whether buckets take SASL passwords (before 5.0), RBAC users exist
+	// (5.0 and later) and buckets have scopes (7.0 and later).
+	Version string
+
+	AdminUser        string
+	AdminPassword    string
+	MemoryQuota      int
+	IndexMemoryQuota int
+	Services         []string
+	Nodes            []*FakeNode
+	Buckets          map[string]*FakeBucket
+	Users            map[string]*FakeUser
+	Settings         map[string]map[string]string
+	Rebalances       int
+
+	// RebalanceSteps is how many polls of the task list report a rebalance
+	// as running before it completes.
+	RebalanceSteps int
+	// RebalanceFailure, if set, makes rebalances fail with this message and
+	// leaves the nodes as they were.
+	RebalanceFailure string
+
+	// SampleLoadSteps is how many task listings report a sample bucket as
+	// loading before it is done.
+	SampleLoadSteps int
+
+	// Indexes holds the N1QL indexes, keyed by "bucket/index".
+	Indexes map[string]*FakeIndex
+	// IndexBuildSteps is how many listings report a new index as building
+	// before it comes online.
+	IndexBuildSteps int
+
+	// ServerGroups names the server groups, in creation order.
+	ServerGroups []string
+
+	// RemoteClusters and Replications hold the XDCR configuration, keyed by
+	// cluster name and replication ID.
+	RemoteClusters map[string]*FakeRemoteCluster
+	Replications   map[string]*FakeReplication
+
+	// ClusterCA is the uploaded cluster CA certificate, CertificateReloads
+	// counts the node certificate reloads, and NodeEncryption is set once
+	// node-to-node encryption is on.
+	ClusterCA          string
+	CertificateReloads int
+	NodeEncryption     bool
+
+	// Requests records "METHOD /path" for every request received.
+	Requests []string
+
+	mux       *http.ServeMux
+	overrides map[string]http.HandlerFunc
+
+	rebalanceRemaining int
+	rebalanceError     string
+	groupsRevision     int
+}
+
+// NewServer starts a fake single-node cluster that has not been initialized yet.
+func NewServer() *Server {
+	s := &Server{
+		Version:        DefaultVersion,
+		AdminUser:      DefaultAdminUser,
+		AdminPassword:  DefaultAdminPassword,
+		Buckets:        make(map[string]*FakeBucket),
+		Users:          make(map[string]*FakeUser),
+		Settings:       make(map[string]map[string]string),
+		Indexes:        make(map[string]*FakeIndex),
+		ServerGroups:   []string{"Group 1"},
+		RemoteClusters: make(map[string]*FakeRemoteCluster),
+		Replications:   make(map[string]*FakeReplication),
+		mux:            http.NewServeMux(),
+		overrides:      make(map[string]http.HandlerFunc),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.Nodes = []*FakeNode{{
+		Hostname:   strings.TrimPrefix(s.URL, "http://"),
+		OTPNode:    "ns_1@127.0.0.1",
+		Membership: "active",
+		Status:     "healthy",
+	}}
+
+	s.mux.HandleFunc("/pools", s.handlePools)
+	s.mux.HandleFunc("/nodes/self/controller/settings", s.handleInitNode)
+	s.mux.HandleFunc("/pools/default", s.handlePool)
+	s.mux.HandleFunc("/node/controller/setupServices", s.handleSetupServices)
+	s.mux.HandleFunc("/settings/web", s.handleWebSettings)
+	s.mux.HandleFunc("/pools/default/buckets", s.handleBuckets)
+	s.mux.HandleFunc("/pools/default/buckets/", s.handleBucket)
+	s.mux.HandleFunc("/controller/addNode", s.handleAddNode)
+	s.mux.HandleFunc("/controller/rebalance", s.handleRebalance)
+	s.mux.HandleFunc("/pools/default/rebalanceProgress", s.handleRebalanceProgress)
+	s.mux.HandleFunc("/pools/default/tasks", s.handleTasks)
+	s.mux.HandleFunc("/settings/rbac/users", s.handleUsers)
+	s.mux.HandleFunc("/settings/rbac/users/local/", s.handleUser)
+	s.mux.HandleFunc("/settings/autoFailover", s.handleSettings("autoFailover"))
+	s.mux.HandleFunc("/settings/indexes", s.handleSettings("indexes"))
+	s.mux.HandleFunc("/settings/querySettings", s.handleSettings("querySettings"))
+	s.mux.HandleFunc("/settings/replications", s.handleSettings("replications"))
+	s.mux.HandleFunc("/pools/default/serverGroups", s.handleServerGroups)
+	s.mux.HandleFunc("/query/service", s.handleQuery)
+	s.mux.HandleFunc("/sampleBuckets/install", s.handleInstallSamples)
+	s.mux.HandleFunc("/pools/default/remoteClusters", s.handleRemoteClusters)
+	s.mux.HandleFunc("/pools/default/remoteClusters/", s.handleRemoteCluster)
+	s.mux.HandleFunc("/controller/createReplication", s.handleCreateReplication)
+	s.mux.HandleFunc("/controller/cancelXDCR/", s.handleCancelReplication)
+	s.mux.HandleFunc("/controller/uploadClusterCA", s.handleUploadClusterCA)
+	s.mux.HandleFunc("/node/controller/reloadCertificate", s.handleReloadCertificate)
+	s.mux.HandleFunc("/node/controller/enableExternalListener", s.handleNetConfig)
+	s.mux.HandleFunc("/node/controller/setupNetConfig", s.handleNetConfig)
+	s.mux.HandleFunc("/node/controller/disableExternalListener", s.handleNetConfig)
+	s.mux.HandleFunc("/settings/security", s.handleSecuritySettings)
+	return s
+}
+
+// Handle replaces the fake's handling of requests for path, e.g. to inject
+// faults.  The handler is called with the server lock held.
+func (s *Server) Handle(path string, handler http.HandlerFunc) {
+	s.Lock()
+	defer s.Unlock()
+	s.overrides[path] = handler
+}
+
+// CountRequests returns how many requests were received for "METHOD /path".
+func (s *Server) CountRequests(request string) int {
+	s.Lock()
+	defer s.Unlock()
+	count := 0
+	for _, r := range s.Requests {
+		if r == request {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.Requests = append(s.Requests, r.Method+" "+r.URL.Path)
+	r.ParseForm()
+
+	user, pass, _ := r.BasicAuth()
+	if user != s.AdminUser || pass != s.AdminPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if handler, ok := s.overrides[r.URL.Path]; ok {
+		handler(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeFieldError(w http.ResponseWriter, field, msg string) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": map[string]string{field: msg}})
+}
+
+func writeListError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, []string{msg})
+}
+
+// major returns the major version of the fake.
+func (s *Server) major() int {
+	major, _ := strconv.Atoi(strings.SplitN(s.Version, ".", 2)[0])
+	return major
+}
+
+func (s *Server) handlePools(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"isAdminCreds":          true,
+		"implementationVersion": s.Version,
+	})
+}
+
+func (s *Server) handleInitNode(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handlePool(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if v := r.FormValue("memoryQuota"); v != "" {
+			quota, _ := strconv.Atoi(v)
+			if quota < 256 {
+				writeFieldError(w, "memoryQuota", "The RAM Quota value is too small.")
+				return
+			}
+			s.MemoryQuota = quota
+		}
+		if v := r.FormValue("indexMemoryQuota"); v != "" {
+			quota, _ := strconv.Atoi(v)
+			if quota < 256 {
+				writeFieldError(w, "indexMemoryQuota", "The index RAM Quota value is too small.")
+				return
+			}
+			s.IndexMemoryQuota = quota
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	nodes := make([]map[string]interface{}, len(s.Nodes))
+	for i, n := range s.Nodes {
+		nodes[i] = map[string]interface{}{
+			"hostname":          n.Hostname,
+			"otpNode":           n.OTPNode,
+			"status":            n.Status,
+			"clusterMembership": n.Membership,
+			"services":          n.Services,
+			"version":           s.Version,
+		}
+	}
+	var quotaUsed, usedByData int64
+	for _, b := range s.Buckets {
+		quotaUsed += int64(b.RAMQuotaMB) << 20 * int64(len(s.Nodes))
+		usedByData += b.MemUsed
+	}
+	quotaTotal := int64(s.MemoryQuota) << 20 * int64(len(s.Nodes))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":             "default",
+		"nodes":            nodes,
+		"memoryQuota":      s.MemoryQuota,
+		"indexMemoryQuota": s.IndexMemoryQuota,
+		"rebalanceStatus":  s.rebalanceStatus(),
+		"storageTotals": map[string]interface{}{
+			"ram": map[string]int64{"quotaTotal": quotaTotal, "quotaUsed": quotaUsed, "usedByData": usedByData},
+		},
+	})
+}
+
+func (s *Server) handleSetupServices(w http.ResponseWriter, r *http.Request) {
+	if s.Services != nil {
+		writeListError(w, http.StatusBadRequest, "cannot change node services after cluster is provisioned")
+		return
+	}
+	s.Services = strings.Split(r.FormValue("services"), ",")
+	s.Nodes[0].Services = s.Services
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleWebSettings(w http.ResponseWriter, r *http.Request) {
+	username, password := r.FormValue("username"), r.FormValue("password")
+	if username == "" || len(password) < 6 {
+		writeFieldError(w, "password", "The password must be at least six characters.")
+		return
+	}
+	s.AdminUser = username
+	s.AdminPassword = password
+	writeJSON(w, http.StatusOK, map[string]string{"newBaseUri": s.URL + "/"})
+}
+
+func (s *Server) bucketJSON(b *FakeBucket) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                   b.Name,
+		"bucketType":             b.BucketType,
+		"authType":               b.AuthType,
+		"replicaNumber":          b.ReplicaNumber,
+		"evictionPolicy":         b.EvictionPolicy,
+		"compressionMode":        b.CompressionMode,
+		"maxTTL":                 b.MaxTTL,
+		"conflictResolutionType": b.ConflictResolutionType,
+		"quota": map[string]int64{
+			"ram":    int64(b.RAMQuotaMB) * 1024 * 1024 * int64(len(s.Nodes)),
+			"rawRAM": int64(b.RAMQuotaMB) * 1024 * 1024,
+		},
+		"basicStats": map[string]interface{}{
+			"itemCount": b.ItemCount,
+			"dataUsed":  b.DataUsed,
+			"memUsed":   b.MemUsed,
+		},
+	}
+}
+
+func (s *Server) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		list := []map[string]interface{}{}
+		for _, b := range s.Buckets {
+			list = append(list, s.bucketJSON(b))
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		writeFieldError(w, "name", "Bucket name cannot be empty")
+		return
+	}
+	if _, ok := s.Buckets[name]; ok {
+		writeFieldError(w, "name", "Bucket with given name already exists")
+		return
+	}
+	if s.major() >= 5 && (r.FormValue("saslPassword") != "" || r.FormValue("authType") == "sasl") {
+		writeFieldError(w, "saslPassword", "SASL bucket passwords are not supported, use RBAC users")
+		return
+	}
+	ram, _ := strconv.Atoi(r.FormValue("ramQuotaMB"))
+	if ram < 100 {
+		writeFieldError(w, "ramQuotaMB", "RAM quota cannot be less than 100 MB")
+		return
+	}
+	used := 0
+	for _, b := range s.Buckets {
+		used += b.RAMQuotaMB
+	}
+	if used+ram > s.MemoryQuota {
+		writeFieldError(w, "ramQuotaMB", "RAM quota specified is too large to be provisioned into this cluster.")
+		return
+	}
+	bucket := &FakeBucket{
+		Name:                   name,
+		BucketType:             r.FormValue("bucketType"),
+		RAMQuotaMB:             ram,
+		ReplicaNumber:          1,
+		EvictionPolicy:         r.FormValue("evictionPolicy"),
+		CompressionMode:        r.FormValue("compressionMode"),
+		ConflictResolutionType: r.FormValue("conflictResolutionType"),
+		AuthType:               r.FormValue("authType"),
+		SASLPassword:           r.FormValue("saslPassword"),
+		FlushEnabled:           r.FormValue("flushEnabled") == "1",
+	}
+	bucket.MaxTTL, _ = strconv.Atoi(r.FormValue("maxTTL"))
+	if bucket.BucketType == "" {
+		bucket.BucketType = "couchbase"
+	}
+	if v := r.FormValue("replicaNumber"); v != "" {
+		bucket.ReplicaNumber, _ = strconv.Atoi(v)
+	}
+	s.Buckets[name] = bucket
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleBucket(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/pools/default/buckets/")
+	docID, scopes := "", ""
+	if i := strings.Index(name, "/docs/"); i >= 0 {
+		name, docID = name[:i], name[i+len("/docs/"):]
+	}
+	if i := strings.Index(name, "/scopes"); i >= 0 && s.major() >= 7 {
+		name, scopes = name[:i], name[i:]
+	}
+	bucket, ok := s.Buckets[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, "Requested resource not found.")
+		return
+	}
+	if docID != "" {
+		s.handleDoc(w, r, bucket, docID)
+		return
+	}
+	if scopes != "" {
+		s.handleScopes(w, r, bucket, scopes)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, s.bucketJSON(bucket))
+	case "DELETE":
+		delete(s.Buckets, name)
+		w.WriteHeader(http.StatusOK)
+	case "POST":
+		if v := r.FormValue("ramQuotaMB"); v != "" {
+			bucket.RAMQuotaMB, _ = strconv.Atoi(v)
+		}
+		if v := r.FormValue("replicaNumber"); v != "" {
+			bucket.ReplicaNumber, _ = strconv.Atoi(v)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleAddNode(w http.ResponseWriter, r *http.Request) {
+	hostname := r.FormValue("hostname")
+	otpNode := "ns_1@" + strings.Split(hostname, ":")[0]
+	for _, n := range s.Nodes {
+		if n.OTPNode == otpNode {
+			writeListError(w, http.StatusBadRequest, "Prepare join failed. Node is already part of cluster.")
+			return
+		}
+	}
+	s.Nodes = append(s.Nodes, &FakeNode{
+		Hostname:   hostname,
+		OTPNode:    otpNode,
+		Services:   strings.Split(r.FormValue("services"), ","),
+		Membership: "inactiveAdded",
+		Status:     "healthy",
+	})
+	writeJSON(w, http.StatusOK, map[string]string{"otpNode": otpNode})
+}
+
+func (s *Server) handleRebalance(w http.ResponseWriter, r *http.Request) {
+	known := splitList(r.FormValue("knownNodes"))
+	ejected := splitList(r.FormValue("ejectedNodes"))
+	if len(known) != len(s.Nodes) {
+		writeJSON(w, http.StatusBadRequest, map[string]int{"mismatch": 1})
+		return
+	}
+	for _, n := range s.Nodes {
+		if !contains(known, n.OTPNode) {
+			writeJSON(w, http.StatusBadRequest, map[string]int{"mismatch": 1})
+			return
+		}
+	}
+
+	s.Rebalances++
+	s.rebalanceRemaining = s.RebalanceSteps
+	s.rebalanceError = s.RebalanceFailure
+	if s.rebalanceError == "" {
+		var remaining []*FakeNode
+		for _, n := range s.Nodes {
+			if !contains(ejected, n.OTPNode) {
+				n.Membership = "active"
+				remaining = append(remaining, n)
+			}
+		}
+		s.Nodes = remaining
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) rebalanceStatus() string {
+	if s.rebalanceRemaining > 0 {
+		return "running"
+	}
+	return "none"
+}
+
+// rebalancePercent is how far the running rebalance has got.
+func (s *Server) rebalancePercent() float64 {
+	return 100 * float64(s.RebalanceSteps-s.rebalanceRemaining) / float64(s.RebalanceSteps)
+}
+
+func (s *Server) handleRebalanceProgress(w http.ResponseWriter, r *http.Request) {
+	if s.rebalanceRemaining > 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":           "running",
+			s.Nodes[0].OTPNode: map[string]float64{"progress": s.rebalancePercent() / 100},
+		})
+		return
+	}
+	status := map[string]string{"status": "none"}
+	if s.rebalanceError != "" {
+		status["errorMessage"] = s.rebalanceError
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	task := map[string]interface{}{"type": "rebalance", "status": "notRunning"}
+	if s.rebalanceRemaining > 0 {
+		s.rebalanceRemaining--
+		task["status"] = "running"
+		task["progress"] = s.rebalancePercent()
+	} else if s.rebalanceError != "" {
+		task["errorMessage"] = s.rebalanceError
+	}
+	tasks := []interface{}{task}
+	for _, b := range s.Buckets {
+		if b.loadingPolls > 0 {
+			b.loadingPolls--
+			tasks = append(tasks, map[string]interface{}{"type": "loadingSampleBucket", "status": "running", "bucket": b.Name})
+		}
+	}
+	for _, rep := range s.Replications {
+		tasks = append(tasks, rep.task())
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+func (s *Server) handleInstallSamples(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &names); err != nil {
+		writeListError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	used := 0
+	for _, b := range s.Buckets {
+		used += b.RAMQuotaMB
+	}
+	if used+100*len(names) > s.MemoryQuota {
+		writeListError(w, http.StatusBadRequest, fmt.Sprintf("Not enough Quota, you need to allocate %dMB to install the samples", 100*len(names)))
+		return
+	}
+	for _, name := range names {
+		if _, ok := s.Buckets[name]; ok {
+			writeListError(w, http.StatusBadRequest, fmt.Sprintf("Sample %s is already loaded.", name))
+			return
+		}
+	}
+	for _, name := range names {
+		s.Buckets[name] = &FakeBucket{
+			Name:          name,
+			BucketType:    "couchbase",
+			RAMQuotaMB:    100,
+			ReplicaNumber: 1,
+			ItemCount:     1000,
+			loadingPolls:  s.SampleLoadSteps,
+		}
+	}
+	writeJSON(w, http.StatusAccepted, []string{})
+}
+
+func (s *Server) handleDoc(w http.ResponseWriter, r *http.Request, bucket *FakeBucket, id string) {
+	if r.Method != "POST" {
+		writeJSON(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(r.FormValue("value")), &value); err != nil {
+		writeFieldError(w, "value", "Value must be json")
+		return
+	}
+	if bucket.Docs == nil {
+		bucket.Docs = make(map[string]string)
+	}
+	if _, ok := bucket.Docs[id]; !ok {
+		bucket.ItemCount++
+	}
+	bucket.Docs[id] = r.FormValue("value")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleScopes creates a scope (path "/scopes") or a collection (path
+// "/scopes/<scope>/collections") in bucket.
+func (s *Server) handleScopes(w http.ResponseWriter, r *http.Request, bucket *FakeBucket, path string) {
+	name := r.FormValue("name")
+	if r.Method != "POST" || name == "" {
+		writeFieldError(w, "name", "name is missing")
+		return
+	}
+	if bucket.Scopes == nil {
+		bucket.Scopes = map[string][]string{"_default": {"_default"}}
+	}
+	if path == "/scopes" {
+		if _, ok := bucket.Scopes[name]; ok {
+			writeFieldError(w, "name", "Scope with this name already exists")
+			return
+		}
+		bucket.Scopes[name] = nil
+		writeJSON(w, http.StatusOK, map[string]string{"uid": "1"})
+		return
+	}
+	scope := strings.TrimSuffix(strings.TrimPrefix(path, "/scopes/"), "/collections")
+	collections, ok := bucket.Scopes[scope]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, "Scope not found")
+		return
+	}
+	if contains(collections, name) {
+		writeFieldError(w, "name", "Collection with this name already exists")
+		return
+	}
+	bucket.Scopes[scope] = append(collections, name)
+	writeJSON(w, http.StatusOK, map[string]string{"uid": "2"})
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	list := []map[string]interface{}{}
+	for id, u := range s.Users {
+		roles := []map[string]string{}
+		for _, role := range u.Roles {
+			roles = append(roles, map[string]string{"role": role})
+		}
+		list = append(list, map[string]interface{}{"id": id, "roles": roles})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	if s.major() < 5 {
+		writeJSON(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/settings/rbac/users/local/")
+	switch r.Method {
+	case "PUT":
+		s.Users[id] = &FakeUser{Password: r.FormValue("password"), Roles: splitList(r.FormValue("roles"))}
+		w.WriteHeader(http.StatusOK)
+	case "DELETE":
+		if _, ok := s.Users[id]; !ok {
+			writeJSON(w, http.StatusNotFound, "User was not found.")
+			return
+		}
+		delete(s.Users, id)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleSettings(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings := make(map[string]string)
+		for k := range r.PostForm {
+			settings[k] = r.PostForm.Get(k)
+		}
+		s.Settings[name] = settings
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// String summarizes the fake cluster, for test failure messages.
+func (s *Server) String() string {
+	return fmt.Sprintf("fake couchbase %s: %d nodes, %d buckets", s.URL, len(s.Nodes), len(s.Buckets))
+}
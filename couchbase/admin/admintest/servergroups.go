@@ -0,0 +1,104 @@
+package admintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// groupOf returns the server group of a node.
+func (s *Server) groupOf(n *FakeNode) string {
+	if n.Group == "" {
+		return s.ServerGroups[0]
+	}
+	return n.Group
+}
+
+func groupURI(i int) string {
+	return fmt.Sprintf("/pools/default/serverGroups/%d", i)
+}
+
+func (s *Server) handleServerGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		groups := []map[string]interface{}{}
+		for i, name := range s.ServerGroups {
+			nodes := []map[string]string{}
+			for _, n := range s.Nodes {
+				if s.groupOf(n) == name {
+					nodes = append(nodes, map[string]string{"otpNode": n.OTPNode, "hostname": n.Hostname})
+				}
+			}
+			groups = append(groups, map[string]interface{}{"name": name, "uri": groupURI(i), "nodes": nodes})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"groups": groups,
+			"uri":    "/pools/default/serverGroups?rev=" + strconv.Itoa(s.groupsRevision),
+		})
+	case "POST":
+		name := r.FormValue("name")
+		if name == "" || contains(s.ServerGroups, name) {
+			writeFieldError(w, "name", "name is already taken")
+			return
+		}
+		s.ServerGroups = append(s.ServerGroups, name)
+		s.groupsRevision++
+		w.WriteHeader(http.StatusOK)
+	case "PUT":
+		s.updateServerGroups(w, r)
+	}
+}
+
+func (s *Server) updateServerGroups(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("rev") != strconv.Itoa(s.groupsRevision) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	var update struct {
+		Groups []struct {
+			URI   string `json:"uri"`
+			Nodes []struct {
+				OTPNode string `json:"otpNode"`
+			} `json:"nodes"`
+		} `json:"groups"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &update); err != nil || len(update.Groups) != len(s.ServerGroups) {
+		writeListError(w, http.StatusBadRequest, "Bad input")
+		return
+	}
+
+	groups := make(map[string]string)
+	for _, g := range update.Groups {
+		name := ""
+		for i := range s.ServerGroups {
+			if groupURI(i) == g.URI {
+				name = s.ServerGroups[i]
+			}
+		}
+		if name == "" {
+			writeListError(w, http.StatusBadRequest, "Bad input: unknown group "+g.URI)
+			return
+		}
+		for _, n := range g.Nodes {
+			if _, ok := groups[n.OTPNode]; ok {
+				writeListError(w, http.StatusBadRequest, "Bad input: node "+n.OTPNode+" is listed twice")
+				return
+			}
+			groups[n.OTPNode] = name
+		}
+	}
+	for _, n := range s.Nodes {
+		if _, ok := groups[n.OTPNode]; !ok {
+			writeListError(w, http.StatusBadRequest, "Bad input: node "+n.OTPNode+" is missing")
+			return
+		}
+	}
+	for _, n := range s.Nodes {
+		n.Group = groups[n.OTPNode]
+	}
+	s.groupsRevision++
+	w.WriteHeader(http.StatusOK)
+}
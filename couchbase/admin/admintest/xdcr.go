@@ -0,0 +1,143 @@
+package admintest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A FakeRemoteCluster is a remote cluster reference of the fake cluster.
+type FakeRemoteCluster struct {
+	Name     string
+	UUID     string
+	Hostname string
+	Username string
+	Password string
+	// Certificate is the CA trusted for encrypted replications.
+	Certificate string
+}
+
+// A FakeReplication is an XDCR replication of the fake cluster.  Tests may
+// set Status and Errors to simulate a troubled replication.
+type FakeReplication struct {
+	ID          string
+	FromBucket  string
+	ToCluster   string
+	ToBucket    string
+	Status      string
+	Errors      []string
+	ChangesLeft int64
+}
+
+func (rep *FakeReplication) task() map[string]interface{} {
+	errors := rep.Errors
+	if errors == nil {
+		errors = []string{}
+	}
+	return map[string]interface{}{
+		"type":        "xdcr",
+		"id":          rep.ID,
+		"status":      rep.Status,
+		"source":      rep.FromBucket,
+		"errors":      errors,
+		"changesLeft": rep.ChangesLeft,
+	}
+}
+
+func (s *Server) handleRemoteClusters(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		list := []map[string]interface{}{}
+		for _, remote := range s.RemoteClusters {
+			list = append(list, map[string]interface{}{
+				"name": remote.Name, "uuid": remote.UUID, "hostname": remote.Hostname,
+				"username": remote.Username, "deleted": false,
+			})
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	name := r.FormValue("name")
+	switch {
+	case name == "":
+		writeFieldError(w, "name", "cluster name is missing")
+		return
+	case r.FormValue("hostname") == "":
+		writeFieldError(w, "hostname", "hostname (ip) is missing")
+		return
+	case s.RemoteClusters[name] != nil:
+		writeFieldError(w, "_", "duplicate cluster names are not allowed")
+		return
+	case r.FormValue("demandEncryption") == "1" && r.FormValue("certificate") == "":
+		writeFieldError(w, "certificate", "certificate must be given if demand encryption is on")
+		return
+	}
+	remote := &FakeRemoteCluster{
+		Name:        name,
+		UUID:        fmt.Sprintf("%032x", len(s.Requests)),
+		Hostname:    r.FormValue("hostname"),
+		Username:    r.FormValue("username"),
+		Password:    r.FormValue("password"),
+		Certificate: r.FormValue("certificate"),
+	}
+	s.RemoteClusters[name] = remote
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name": remote.Name, "uuid": remote.UUID, "hostname": remote.Hostname, "username": remote.Username,
+	})
+}
+
+func (s *Server) handleRemoteCluster(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/pools/default/remoteClusters/")
+	remote := s.RemoteClusters[name]
+	if r.Method != "DELETE" || remote == nil {
+		writeJSON(w, http.StatusNotFound, "unknown remote cluster")
+		return
+	}
+	for _, rep := range s.Replications {
+		if rep.ToCluster == name {
+			writeListError(w, http.StatusBadRequest, "Cannot delete remote cluster `"+name+"` since it is referenced by replications")
+			return
+		}
+	}
+	delete(s.RemoteClusters, name)
+	writeJSON(w, http.StatusOK, "ok")
+}
+
+func (s *Server) handleCreateReplication(w http.ResponseWriter, r *http.Request) {
+	from, toCluster, toBucket := r.FormValue("fromBucket"), r.FormValue("toCluster"), r.FormValue("toBucket")
+	remote := s.RemoteClusters[toCluster]
+	switch {
+	case s.Buckets[from] == nil:
+		writeFieldError(w, "fromBucket", "unknown bucket")
+		return
+	case remote == nil:
+		writeFieldError(w, "toCluster", "unknown remote cluster")
+		return
+	case toBucket == "":
+		writeFieldError(w, "toBucket", "target bucket is missing")
+		return
+	}
+	id := remote.UUID + "/" + from + "/" + toBucket
+	if s.Replications[id] != nil {
+		writeFieldError(w, "_", "Replication to the same remote cluster and bucket already exists")
+		return
+	}
+	s.Replications[id] = &FakeReplication{
+		ID:         id,
+		FromBucket: from,
+		ToCluster:  toCluster,
+		ToBucket:   toBucket,
+		Status:     "running",
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+func (s *Server) handleCancelReplication(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/controller/cancelXDCR/")
+	if r.Method != "DELETE" || s.Replications[id] == nil {
+		writeJSON(w, http.StatusNotFound, "unknown replication")
+		return
+	}
+	delete(s.Replications, id)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// BucketSettings are the parameters used to create a bucket.  Zero values are
+// left to the Couchbase defaults.
+type BucketSettings struct {
+	Name                   string
+	BucketType             string
+	RAMQuotaMB             int
+	ReplicaNumber          *int
+	EvictionPolicy         string
+	CompressionMode        string
+	MaxTTL                 int
+	ConflictResolutionType string
+	AuthType               string
+	SASLPassword           string
+	FlushEnabled           bool
+}
+
+// Bucket types.
+const (
+	BucketCouchbase = "couchbase"
+	BucketEphemeral = "ephemeral"
+	BucketMemcached = "memcached"
+)
+
+// A Bucket describes an existing bucket.
+type Bucket struct {
+	Name          string `json:"name"`
+	BucketType    string `json:"bucketType"`
+	AuthType      string `json:"authType"`
+	ReplicaNumber int    `json:"replicaNumber"`
+	// EvictionPolicy, CompressionMode, MaxTTL and ConflictResolutionType
+	// are only reported by Couchbase versions that support them.
+	EvictionPolicy         string `json:"evictionPolicy"`
+	CompressionMode        string `json:"compressionMode"`
+	MaxTTL                 int    `json:"maxTTL"`
+	ConflictResolutionType string `json:"conflictResolutionType"`
+	Quota                  struct {
+		RAM    int64 `json:"ram"`
+		RawRAM int64 `json:"rawRAM"`
+	} `json:"quota"`
+	BasicStats struct {
+		ItemCount int64   `json:"itemCount"`
+		DataUsed  int64   `json:"dataUsed"`
+		MemUsed   int64   `json:"memUsed"`
+		QuotaUsed float64 `json:"quotaPercentUsed"`
+	} `json:"basicStats"`
+}
+
+func (b BucketSettings) form() url.Values {
+	form := url.Values{}
+	form.Set("name", b.Name)
+	if b.BucketType != "" {
+		form.Set("bucketType", b.BucketType)
+	}
+	if b.RAMQuotaMB > 0 {
+		form.Set("ramQuotaMB", strconv.Itoa(b.RAMQuotaMB))
+	}
+	if b.ReplicaNumber != nil {
+		form.Set("replicaNumber", strconv.Itoa(*b.ReplicaNumber))
+	}
+	if b.EvictionPolicy != "" {
+		form.Set("evictionPolicy", b.EvictionPolicy)
+	}
+	if b.CompressionMode != "" {
+		form.Set("compressionMode", b.CompressionMode)
+	}
+	if b.MaxTTL > 0 {
+		form.Set("maxTTL", strconv.Itoa(b.MaxTTL))
+	}
+	if b.ConflictResolutionType != "" {
+		form.Set("conflictResolutionType", b.ConflictResolutionType)
+	}
+	if b.AuthType != "" {
+		form.Set("authType", b.AuthType)
+	}
+	if b.SASLPassword != "" {
+		form.Set("saslPassword", b.SASLPassword)
+	}
+	if b.FlushEnabled {
+		form.Set("flushEnabled", "1")
+	}
+	return form
+}
+
+// CreateBucket creates a bucket.
+func (c *Client) CreateBucket(settings BucketSettings) error {
+	return c.postForm("CreateBucket", "/pools/default/buckets", settings.form(), nil)
+}
+
+// UpdateBucket changes the settings of an existing bucket.
+func (c *Client) UpdateBucket(settings BucketSettings) error {
+	form := settings.form()
+	form.Del("name")
+	form.Del("bucketType")
+	form.Del("conflictResolutionType")
+	return c.postForm("UpdateBucket", "/pools/default/buckets/"+url.QueryEscape(settings.Name), form, nil)
+}
+
+// GetBucket returns the named bucket.
+func (c *Client) GetBucket(name string) (*Bucket, error) {
+	var bucket Bucket
+	err := c.getJSON("GetBucket", "/pools/default/buckets/"+url.QueryEscape(name), &bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &bucket, nil
+}
+
+// ListBuckets returns all buckets in the cluster.
+func (c *Client) ListBuckets() ([]Bucket, error) {
+	var buckets []Bucket
+	err := c.getJSON("ListBuckets", "/pools/default/buckets", &buckets)
+	return buckets, err
+}
+
+// DeleteBucket deletes the named bucket.
+func (c *Client) DeleteBucket(name string) error {
+	return c.delete("DeleteBucket", "/pools/default/buckets/"+url.QueryEscape(name))
+}
@@ -0,0 +1,189 @@
+// Package admin is a client for the Couchbase Server cluster administration
+// REST API (port 8091): node and cluster setup, buckets, RBAC and settings.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+const (
+	// DefaultPort is the Couchbase administration REST port.
+	DefaultPort = 8091
+	// DefaultTimeout bounds each HTTP request to Couchbase.
+	DefaultTimeout = 30 * time.Second
+	// DefaultRetries is how many times a request is retried when the node is
+	// unreachable or answers with a server error (e.g. while warming up).
+	// Requests that create something, e.g. a bucket, a node or a rebalance,
+	// are only retried when the node could not be reached: they may have
+	// taken effect before the error.
+	DefaultRetries = 3
+	// DefaultRetryDelay is the wait between retries.
+	DefaultRetryDelay = 2 * time.Second
+)
+
+// A Client talks to the administration REST API of one Couchbase node.
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// NewClient returns a Client for the node at baseURL (e.g. http://10.244.1.2:8091)
+// authenticating as username/password.
+func NewClient(baseURL, username, password string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		Retries:    DefaultRetries,
+		RetryDelay: DefaultRetryDelay,
+	}
+}
+
+// NodeURL returns the administration URL for a node address.
+func NodeURL(ipaddr string) string {
+	return fmt.Sprintf("http://%s:%d", ipaddr, DefaultPort)
+}
+
+// URL returns the base URL of the node this client talks to.
+func (c *Client) URL() string {
+	return c.baseURL
+}
+
+// WithCredentials returns a copy of the client that authenticates as username/password.
+func (c *Client) WithCredentials(username, password string) *Client {
+	copy := *c
+	copy.username = username
+	copy.password = password
+	return &copy
+}
+
+// SetHTTPClient replaces the HTTP client used for requests.
+func (c *Client) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+}
+
+// postForm POSTs form values to path and decodes a JSON response into result (if not nil).
+func (c *Client) postForm(operation, path string, form url.Values, result interface{}) error {
+	return c.do(operation, "POST", path, "application/x-www-form-urlencoded", form.Encode(), result, false)
+}
+
+// setForm POSTs settings form values to path, like postForm.  Setting the
+// same values twice is harmless, so it is retried after server errors.
+func (c *Client) setForm(operation, path string, form url.Values) error {
+	return c.do(operation, "POST", path, "application/x-www-form-urlencoded", form.Encode(), nil, true)
+}
+
+// putForm PUTs form values to path and decodes a JSON response into result (if not nil).
+func (c *Client) putForm(operation, path string, form url.Values, result interface{}) error {
+	return c.do(operation, "PUT", path, "application/x-www-form-urlencoded", form.Encode(), result, false)
+}
+
+// postJSON POSTs value as JSON to path and decodes a JSON response into result (if not nil).
+func (c *Client) postJSON(operation, path string, value interface{}, result interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.do(operation, "POST", path, "application/json", string(body), result, false)
+}
+
+// putJSON PUTs value as JSON to path and decodes a JSON response into result (if not nil).
+func (c *Client) putJSON(operation, path string, value interface{}, result interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.do(operation, "PUT", path, "application/json", string(body), result, false)
+}
+
+// getJSON GETs path and decodes the JSON response into result.
+func (c *Client) getJSON(operation, path string, result interface{}) error {
+	return c.do(operation, "GET", path, "", "", result, true)
+}
+
+// delete sends a DELETE for path.
+func (c *Client) delete(operation, path string) error {
+	return c.do(operation, "DELETE", path, "", "", nil, true)
+}
+
+// do performs a request, retrying it when the node is unreachable, and, if
+// it is idempotent, when the node answers with a server error.
+func (c *Client) do(operation, method, path, contentType, body string, result interface{}, idempotent bool) error {
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			utils.Logger.Printf("couchbase.admin.%s: retry %d after: %v\n", operation, attempt, err)
+			time.Sleep(c.RetryDelay)
+		}
+		var retry bool
+		retry, err = c.doOnce(operation, method, path, contentType, body, result, idempotent)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// doOnce performs a single request, reporting whether a failure is worth retrying.
+func (c *Client) doOnce(operation, method, path, contentType, body string, result interface{}, idempotent bool) (bool, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return false, err
+	}
+	request.SetBasicAuth(c.username, c.password)
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return idempotent || notSent(err), &Error{Operation: operation, URL: c.baseURL + path, Message: err.Error()}
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return idempotent, &Error{Operation: operation, URL: c.baseURL + path, Message: err.Error()}
+	}
+
+	if response.StatusCode >= 300 {
+		e := newError(operation, c.baseURL+path, response.StatusCode, data)
+		return idempotent && response.StatusCode >= 500, e
+	}
+	if result == nil || len(data) == 0 {
+		return false, nil
+	}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return false, &Error{Operation: operation, URL: c.baseURL + path, StatusCode: response.StatusCode,
+			Message: fmt.Sprintf("invalid JSON response: %v", err)}
+	}
+	return false, nil
+}
+
+// notSent reports whether a request failed before reaching the node: the
+// connection could not be made.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+)
+
+func newTestClient(url string) *Client {
+	c := NewClient(url, admintest.DefaultAdminUser, admintest.DefaultAdminPassword)
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+func TestInitializeNodeAndBucket(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	c := newTestClient(fake.URL)
+
+	if err := c.SetPoolSettings(PoolSettings{MemoryQuota: 768, IndexMemoryQuota: 256}); err != nil {
+		t.Fatalf("SetPoolSettings: %v", err)
+	}
+	if err := c.SetupServices(nil); err != nil {
+		t.Fatalf("SetupServices: %v", err)
+	}
+	if err := c.SetWebCredentials("admin", "s3cr3tpw", 0); err != nil {
+		t.Fatalf("SetWebCredentials: %v", err)
+	}
+
+	// the old credentials no longer work
+	err := c.CreateBucket(BucketSettings{Name: "cfdefault", RAMQuotaMB: 512})
+	if !IsUnauthorized(err) {
+		t.Errorf("expected unauthorized error with old credentials, got %v", err)
+	}
+
+	c = c.WithCredentials("admin", "s3cr3tpw")
+	if err = c.CreateBucket(BucketSettings{Name: "cfdefault", BucketType: "couchbase", RAMQuotaMB: 512}); err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+	bucket, err := c.GetBucket("cfdefault")
+	if err != nil {
+		t.Fatalf("GetBucket: %v", err)
+	}
+	if bucket.Name != "cfdefault" || bucket.Quota.RawRAM != 512*1024*1024 {
+		t.Errorf("unexpected bucket %+v", bucket)
+	}
+	if fake.MemoryQuota != 768 || fake.IndexMemoryQuota != 256 {
+		t.Errorf("quotas not applied: %v", fake)
+	}
+	if err = c.DeleteBucket("cfdefault"); err != nil {
+		t.Errorf("DeleteBucket: %v", err)
+	}
+	if _, err = c.GetBucket("cfdefault"); !IsNotFound(err) {
+		t.Errorf("expected not found after delete, got %v", err)
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	c := newTestClient(fake.URL)
+
+	err := c.SetPoolSettings(PoolSettings{MemoryQuota: 768})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.CreateBucket(BucketSettings{Name: "big", RAMQuotaMB: 4096})
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error, got %T %v", err, err)
+	}
+	if e.StatusCode != http.StatusBadRequest || e.Errors["ramQuotaMB"] == "" {
+		t.Errorf("expected a ramQuotaMB field error, got %+v", e)
+	}
+
+	c.SetupServices(nil)
+	err = c.SetupServices(nil)
+	e, ok = err.(*Error)
+	if !ok || e.Message == "" {
+		t.Errorf("expected a list error message, got %v", err)
+	}
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	failures := 2
+	fake.Handle("/settings/indexes", func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	c := newTestClient(fake.URL)
+
+	if err := c.SetIndexSettings("memory_optimized"); err != nil {
+		t.Errorf("expected success after retries, got %v", err)
+	}
+	if n := fake.CountRequests("POST /settings/indexes"); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+
+	// client errors are not retried
+	c.CreateBucket(BucketSettings{Name: "x"})
+	if n := fake.CountRequests("POST /pools/default/buckets"); n != 1 {
+		t.Errorf("expected 1 attempt for a 400, got %d", n)
+	}
+
+	// a creating call may have taken effect before a server error
+	fake.Handle("/controller/addNode", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if _, err := c.AddNode("10.244.1.3", "", "", nil); err == nil {
+		t.Errorf("expected AddNode to fail")
+	}
+	if n := fake.CountRequests("POST /controller/addNode"); n != 1 {
+		t.Errorf("expected 1 attempt of AddNode after a 500, got %d", n)
+	}
+
+	// but is retried when the node cannot be reached
+	c = newTestClient("http://127.0.0.1:1")
+	attempts := 0
+	c.SetHTTPClient(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		return http.DefaultTransport.RoundTrip(r)
+	})})
+	c.Rebalance([]string{"ns_1@10.244.1.2"}, nil)
+	if attempts != c.Retries+1 {
+		t.Errorf("expected %d attempts of Rebalance on an unreachable node, got %d", c.Retries+1, attempts)
+	}
+}
+
+func TestAddNodeAndRebalance(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	c := newTestClient(fake.URL)
+
+	otp, err := c.AddNode("10.244.1.6", "admin", "s3cr3tpw", []string{ServiceQuery, ServiceIndex})
+	if err != nil {
+		t.Fatalf("AddNode: %v", err)
+	}
+	if otp != OTPNodeName("10.244.1.6") {
+		t.Errorf("unexpected otpNode %v", otp)
+	}
+	if err = c.Rebalance([]string{OTPNodeName("127.0.0.1")}, nil); err == nil {
+		t.Errorf("rebalance with missing known node should fail")
+	}
+	if err = c.Rebalance([]string{OTPNodeName("127.0.0.1"), otp}, nil); err != nil {
+		t.Errorf("Rebalance: %v", err)
+	}
+	pool, err := c.GetPool()
+	if err != nil {
+		t.Fatalf("GetPool: %v", err)
+	}
+	if len(pool.Nodes) != 2 || pool.Nodes[1].ClusterMembership != "active" {
+		t.Errorf("unexpected nodes after rebalance: %+v", pool.Nodes)
+	}
+}
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
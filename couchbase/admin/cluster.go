@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/url"
+	"strings"
+)
+
+// AddNode adds the node at hostname to the cluster, running the given
+// services.  It returns the new node's otpNode name (e.g. ns_1@10.244.1.6).
+func (c *Client) AddNode(hostname, username, password string, services []string) (string, error) {
+	if len(services) == 0 {
+		services = DefaultServices
+	}
+	form := url.Values{}
+	form.Set("hostname", hostname)
+	form.Set("user", username)
+	form.Set("password", password)
+	form.Set("services", strings.Join(services, ","))
+
+	var result struct {
+		OTPNode string `json:"otpNode"`
+	}
+	err := c.postForm("AddNode", "/controller/addNode", form, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.OTPNode, nil
+}
+
+// Rebalance starts a rebalance over knownNodes, removing ejectedNodes (both
+// lists of otpNode names).  It returns as soon as the rebalance has started.
+func (c *Client) Rebalance(knownNodes, ejectedNodes []string) error {
+	form := url.Values{}
+	form.Set("knownNodes", strings.Join(knownNodes, ","))
+	form.Set("ejectedNodes", strings.Join(ejectedNodes, ","))
+	return c.postForm("Rebalance", "/controller/rebalance", form, nil)
+}
+
+// OTPNodeName returns the otpNode name Couchbase uses for a node address.
+func OTPNodeName(ipaddr string) string {
+	return "ns_1@" + ipaddr
+}
@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"net/url"
+)
+
+// CreateScope adds a scope to a bucket (Couchbase 7.0 and later).
+func (c *Client) CreateScope(bucket, scope string) error {
+	form := url.Values{}
+	form.Set("name", scope)
+	return c.postForm("CreateScope", "/pools/default/buckets/"+url.QueryEscape(bucket)+"/scopes", form, nil)
+}
+
+// CreateCollection adds a collection to a scope of a bucket (Couchbase 7.0
+// and later).
+func (c *Client) CreateCollection(bucket, scope, collection string) error {
+	form := url.Values{}
+	form.Set("name", collection)
+	return c.postForm("CreateCollection", "/pools/default/buckets/"+url.QueryEscape(bucket)+"/scopes/"+url.QueryEscape(scope)+"/collections", form, nil)
+}
@@ -0,0 +1,22 @@
+package admin
+
+import (
+	"net/url"
+)
+
+// SampleBuckets are the sample data sets that ship with Couchbase Server.
+var SampleBuckets = []string{"beer-sample", "gamesim-sample", "travel-sample"}
+
+// InstallSampleBuckets starts loading the named sample buckets.  Each needs
+// its own bucket, so the cluster needs spare RAM quota for them.  Loading
+// goes on in the background, as a loadingSampleBucket task.
+func (c *Client) InstallSampleBuckets(names []string) error {
+	return c.postJSON("InstallSampleBuckets", "/sampleBuckets/install", names, nil)
+}
+
+// SetDocument stores a JSON document under id in bucket.
+func (c *Client) SetDocument(bucket, id string, value []byte) error {
+	form := url.Values{}
+	form.Set("value", string(value))
+	return c.setForm("SetDocument", "/pools/default/buckets/"+url.QueryEscape(bucket)+"/docs/"+url.QueryEscape(id), form)
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// An Error describes a failed Couchbase REST call, including the error
+// messages Couchbase returned in its JSON response body.
+type Error struct {
+	Operation  string
+	URL        string
+	StatusCode int
+	// Errors holds the per-field errors from an {"errors": {...}} response.
+	Errors map[string]string
+	// Message is the error text when the response was not per-field.
+	Message string
+	// QueryErrors holds the errors reported by the query service.
+	QueryErrors []QueryError
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if len(e.Errors) > 0 {
+		keys := make([]string, 0, len(e.Errors))
+		for k := range e.Errors {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + ": " + e.Errors[k]
+		}
+		msg = strings.Join(parts, "; ")
+	}
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("couchbase %s failed: %s", e.Operation, msg)
+	}
+	return fmt.Sprintf("couchbase %s failed (%d): %s", e.Operation, e.StatusCode, msg)
+}
+
+// IsUnauthorized reports whether err is a Couchbase 401 response.
+func IsUnauthorized(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusUnauthorized
+}
+
+// IsNotFound reports whether err is a Couchbase 404 response.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// newError builds an Error from a response body.  Couchbase answers with
+// {"errors": {"field": "msg"}}, a JSON list of messages, a JSON string or plain
+// text; the query service with {"errors": [{"code": 12003, "msg": "..."}]}.
+func newError(operation, url string, statusCode int, body []byte) *Error {
+	e := &Error{Operation: operation, URL: url, StatusCode: statusCode}
+
+	var fields struct {
+		Errors map[string]string `json:"errors"`
+	}
+	var query struct {
+		Errors []QueryError `json:"errors"`
+	}
+	var list []string
+	var text string
+	switch {
+	case json.Unmarshal(body, &fields) == nil && len(fields.Errors) > 0:
+		e.Errors = fields.Errors
+	case json.Unmarshal(body, &query) == nil && len(query.Errors) > 0:
+		e.Message = joinQueryErrors(query.Errors)
+		e.QueryErrors = query.Errors
+	case json.Unmarshal(body, &list) == nil && len(list) > 0:
+		e.Message = strings.Join(list, "; ")
+	case json.Unmarshal(body, &text) == nil && text != "":
+		e.Message = text
+	default:
+		e.Message = strings.TrimSpace(string(body))
+	}
+	if e.Message == "" && len(e.Errors) == 0 {
+		e.Message = http.StatusText(statusCode)
+	}
+	return e
+}
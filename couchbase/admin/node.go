@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Service names accepted by SetupServices and AddNode.
+const (
+	ServiceData  = "kv"
+	ServiceIndex = "index"
+	ServiceQuery = "n1ql"
+	ServiceFTS   = "fts"
+)
+
+// DefaultServices are the services run by a node when none are specified.
+var DefaultServices = []string{ServiceData, ServiceIndex, ServiceQuery}
+
+// PoolSettings are the cluster-wide memory quotas (in MB).  Zero values are not sent.
+type PoolSettings struct {
+	MemoryQuota      int
+	IndexMemoryQuota int
+	FTSMemoryQuota   int
+}
+
+// Node status and cluster membership values.
+const (
+	NodeHealthy   = "healthy"
+	NodeUnhealthy = "unhealthy"
+	NodeWarmup    = "warmup"
+
+	MembershipActive = "active"
+	MembershipFailed = "inactiveFailed"
+)
+
+// A Node is one member of the cluster, as reported by /pools/default.
+type Node struct {
+	Hostname          string   `json:"hostname"`
+	OTPNode           string   `json:"otpNode"`
+	Status            string   `json:"status"`
+	ClusterMembership string   `json:"clusterMembership"`
+	Services          []string `json:"services"`
+	Version           string   `json:"version"`
+}
+
+// A Pool describes the cluster, as reported by /pools/default.
+type Pool struct {
+	Name             string        `json:"name"`
+	Nodes            []Node        `json:"nodes"`
+	MemoryQuota      int           `json:"memoryQuota"`
+	IndexMemoryQuota int           `json:"indexMemoryQuota"`
+	RebalanceStatus  string        `json:"rebalanceStatus"`
+	StorageTotals    StorageTotals `json:"storageTotals"`
+}
+
+// StorageTotals sums up the memory of the cluster, in bytes.
+type StorageTotals struct {
+	RAM struct {
+		Total      int64 `json:"total"`
+		QuotaTotal int64 `json:"quotaTotal"`
+		QuotaUsed  int64 `json:"quotaUsed"`
+		Used       int64 `json:"used"`
+		UsedByData int64 `json:"usedByData"`
+	} `json:"ram"`
+}
+
+// InitNode sets the data and index paths of an uninitialized node.
+func (c *Client) InitNode(dataPath, indexPath string) error {
+	form := url.Values{}
+	if dataPath != "" {
+		form.Set("path", dataPath)
+	}
+	if indexPath != "" {
+		form.Set("index_path", indexPath)
+	}
+	return c.setForm("InitNode", "/nodes/self/controller/settings", form)
+}
+
+// SetPoolSettings sets the cluster memory quotas.
+func (c *Client) SetPoolSettings(settings PoolSettings) error {
+	form := url.Values{}
+	if settings.MemoryQuota > 0 {
+		form.Set("memoryQuota", strconv.Itoa(settings.MemoryQuota))
+	}
+	if settings.IndexMemoryQuota > 0 {
+		form.Set("indexMemoryQuota", strconv.Itoa(settings.IndexMemoryQuota))
+	}
+	if settings.FTSMemoryQuota > 0 {
+		form.Set("ftsMemoryQuota", strconv.Itoa(settings.FTSMemoryQuota))
+	}
+	return c.setForm("SetPoolSettings", "/pools/default", form)
+}
+
+// SetupServices sets the services an uninitialized node will run.
+func (c *Client) SetupServices(services []string) error {
+	if len(services) == 0 {
+		services = DefaultServices
+	}
+	form := url.Values{}
+	form.Set("services", strings.Join(services, ","))
+	return c.postForm("SetupServices", "/node/controller/setupServices", form, nil)
+}
+
+// SetWebCredentials sets the administrator user and password (and REST port).
+// Subsequent calls must use a client WithCredentials(username, password).
+func (c *Client) SetWebCredentials(username, password string, port int) error {
+	if port == 0 {
+		port = DefaultPort
+	}
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("port", strconv.Itoa(port))
+	return c.postForm("SetWebCredentials", "/settings/web", form, nil)
+}
+
+// GetPool returns the cluster description from /pools/default.
+func (c *Client) GetPool() (*Pool, error) {
+	var pool Pool
+	err := c.getJSON("GetPool", "/pools/default", &pool)
+	if err != nil {
+		return nil, err
+	}
+	return &pool, nil
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// QueryPort is the port of the N1QL query service.
+const QueryPort = 8093
+
+// ErrKeyspaceNotFound is the query error code for an unknown bucket, which a
+// new bucket is until the query service has seen it.
+const ErrKeyspaceNotFound = 12003
+
+// A QueryError is an error reported by the query service.
+type QueryError struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+}
+
+// An IndexStatus describes a N1QL index, as listed in system:indexes.
+type IndexStatus struct {
+	Name       string `json:"name"`
+	KeyspaceID string `json:"keyspace_id"`
+	IsPrimary  bool   `json:"is_primary"`
+	// State is e.g. "pending", "building", "deferred" or "online".
+	State string `json:"state"`
+}
+
+// QueryURL returns the query service URL for a node address.  Clients for
+// that URL can run Query; the administration calls need NodeURL.
+func QueryURL(ipaddr string) string {
+	return fmt.Sprintf("http://%s:%d", ipaddr, QueryPort)
+}
+
+// Query runs a N1QL statement and decodes its results into results (if not nil).
+func (c *Client) Query(statement string, results interface{}) error {
+	form := url.Values{}
+	form.Set("statement", statement)
+
+	var response struct {
+		Status  string          `json:"status"`
+		Results json.RawMessage `json:"results"`
+		Errors  []QueryError    `json:"errors"`
+	}
+	err := c.postForm("Query", "/query/service", form, &response)
+	if err != nil {
+		return err
+	}
+	if response.Status != "success" {
+		return &Error{Operation: "Query", URL: c.baseURL + "/query/service",
+			Message:     fmt.Sprintf("%s: %s", response.Status, joinQueryErrors(response.Errors)),
+			QueryErrors: response.Errors}
+	}
+	if results == nil || len(response.Results) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Results, results)
+}
+
+// ListIndexes returns the indexes on a bucket.
+func (c *Client) ListIndexes(bucket string) ([]IndexStatus, error) {
+	var indexes []IndexStatus
+	statement := fmt.Sprintf("SELECT indexes.* FROM system:indexes WHERE keyspace_id = %s", QuoteString(bucket))
+	err := c.Query(statement, &indexes)
+	return indexes, err
+}
+
+// QuoteIdentifier escapes a N1QL identifier such as a bucket or index name.
+func QuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// QuoteString returns a N1QL string literal.
+func QuoteString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// IsKeyspaceNotFound reports whether err is a query failing because the
+// bucket does not exist (yet).
+func IsKeyspaceNotFound(err error) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	for _, q := range e.QueryErrors {
+		if q.Code == ErrKeyspaceNotFound {
+			return true
+		}
+	}
+	return false
+}
+
+func joinQueryErrors(errors []QueryError) string {
+	messages := make([]string, len(errors))
+	for i, e := range errors {
+		messages[i] = fmt.Sprintf("%s (%d)", e.Message, e.Code)
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+)
+
+func TestQueryIndexes(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.MemoryQuota = 1024
+	fake.IndexBuildSteps = 1
+	c := newTestClient(fake.URL)
+	c.Retries = 0
+
+	err := c.Query("CREATE PRIMARY INDEX ON `orders` USING GSI", nil)
+	if !IsKeyspaceNotFound(err) {
+		t.Errorf("expected keyspace not found, got %v", err)
+	}
+	if err = c.CreateBucket(BucketSettings{Name: "orders", RAMQuotaMB: 256}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Query("CREATE PRIMARY INDEX ON `orders` USING GSI", nil); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	indexes, err := c.ListIndexes("orders")
+	if err != nil {
+		t.Fatalf("ListIndexes: %v", err)
+	}
+	if len(indexes) != 1 || !indexes[0].IsPrimary || indexes[0].State != "building" {
+		t.Errorf("unexpected indexes %+v", indexes)
+	}
+	if indexes, _ = c.ListIndexes("orders"); indexes[0].State != "online" {
+		t.Errorf("index not online: %+v", indexes)
+	}
+}
@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"net/url"
+	"strings"
+)
+
+// A User is a locally defined RBAC user.
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Roles []struct {
+		Role       string `json:"role"`
+		BucketName string `json:"bucket_name"`
+	} `json:"roles"`
+}
+
+// SetUser creates or updates a local RBAC user with the given roles, e.g.
+// "bucket_full_access[cfdefault]".
+func (c *Client) SetUser(username, password string, roles []string) error {
+	form := url.Values{}
+	form.Set("password", password)
+	form.Set("roles", strings.Join(roles, ","))
+	return c.putForm("SetUser", "/settings/rbac/users/local/"+url.QueryEscape(username), form, nil)
+}
+
+// DeleteUser removes a local RBAC user.
+func (c *Client) DeleteUser(username string) error {
+	return c.delete("DeleteUser", "/settings/rbac/users/local/"+url.QueryEscape(username))
+}
+
+// ListUsers returns the RBAC users.
+func (c *Client) ListUsers() ([]User, error) {
+	var users []User
+	err := c.getJSON("ListUsers", "/settings/rbac/users", &users)
+	return users, err
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// SecurePort is the administration REST port over TLS.
+	SecurePort = 18091
+	// SecureDataPort is the key-value port over TLS, the default of
+	// couchbases:// connection strings.
+	SecureDataPort = 11207
+)
+
+// Cluster encryption levels (Couchbase 5.5 and later): control encrypts
+// only the cluster management traffic between nodes, all encrypts the data
+// too.
+const (
+	EncryptionControl = "control"
+	EncryptionAll     = "all"
+)
+
+// SecureNodeURL returns the TLS administration URL for a node address.
+func SecureNodeURL(ipaddr string) string {
+	return fmt.Sprintf("https://%s:%d", ipaddr, SecurePort)
+}
+
+// TrustCA makes the client accept only servers whose certificates are
+// signed by the PEM encoded CA certificate.
+func (c *Client) TrustCA(caPEM []byte) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return errors.New("couchbase.admin.TrustCA: no PEM certificate found")
+	}
+	c.httpClient = &http.Client{
+		Timeout: c.httpClient.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+	return nil
+}
+
+// UploadClusterCA sets the PEM encoded CA certificate that the node
+// certificates of the cluster are signed by.
+func (c *Client) UploadClusterCA(caPEM []byte) error {
+	return c.do("UploadClusterCA", "POST", "/controller/uploadClusterCA", "application/octet-stream", string(caPEM), nil, true)
+}
+
+// ReloadCertificate makes the node load the certificate chain and key from
+// its inbox directory (chain.pem and pkey.key).
+func (c *Client) ReloadCertificate() error {
+	return c.setForm("ReloadCertificate", "/node/controller/reloadCertificate", url.Values{})
+}
+
+// EnableNodeEncryption switches the node-to-node traffic of the node to TLS.
+// It must be called on every node before SetClusterEncryptionLevel.
+func (c *Client) EnableNodeEncryption() error {
+	form := url.Values{}
+	form.Set("nodeEncryption", "on")
+	err := c.postForm("EnableNodeEncryption", "/node/controller/enableExternalListener", form, nil)
+	if err != nil {
+		return err
+	}
+	err = c.postForm("EnableNodeEncryption", "/node/controller/setupNetConfig", form, nil)
+	if err != nil {
+		return err
+	}
+	form.Set("nodeEncryption", "off")
+	return c.postForm("EnableNodeEncryption", "/node/controller/disableExternalListener", form, nil)
+}
+
+// SetClusterEncryptionLevel sets what the nodes encrypt between themselves
+// (EncryptionControl or EncryptionAll).
+func (c *Client) SetClusterEncryptionLevel(level string) error {
+	form := url.Values{}
+	form.Set("clusterEncryptionLevel", level)
+	return c.setForm("SetClusterEncryptionLevel", "/settings/security", form)
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+)
+
+func TestTLS(t *testing.T) {
+	authority, err := ca.Generate("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := authority.Issue("node", []string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(cert.ChainPEM, cert.PrivateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"implementationVersion": "6.6.0-7909-enterprise"}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	server.StartTLS()
+	defer server.Close()
+
+	c := NewClient(server.URL, "user", "pw")
+	c.Retries = 0
+	if _, err = c.ServerVersion(); err == nil {
+		t.Errorf("expected the broker CA not to be trusted by default")
+	}
+	if err = c.TrustCA([]byte("junk")); err == nil {
+		t.Errorf("expected TrustCA to reject an invalid certificate")
+	}
+	if err = c.TrustCA(authority.CertificatePEM()); err != nil {
+		t.Fatalf("TrustCA: %v", err)
+	}
+	if _, err = c.ServerVersion(); err != nil {
+		t.Errorf("ServerVersion over TLS: %v", err)
+	}
+
+	fake := admintest.NewServer()
+	defer fake.Close()
+	c = NewClient(fake.URL, admintest.DefaultAdminUser, admintest.DefaultAdminPassword)
+	if err = c.ReloadCertificate(); err == nil {
+		t.Errorf("expected the node certificate to need a cluster CA")
+	}
+	if err = c.UploadClusterCA(authority.CertificatePEM()); err != nil {
+		t.Fatalf("UploadClusterCA: %v", err)
+	}
+	if err = c.ReloadCertificate(); err != nil || fake.CertificateReloads != 1 {
+		t.Errorf("ReloadCertificate: %v (%d reloads)", err, fake.CertificateReloads)
+	}
+	if err = c.SetClusterEncryptionLevel(EncryptionAll); err == nil {
+		t.Errorf("expected the encryption level to need node encryption")
+	}
+	if err = c.EnableNodeEncryption(); err != nil || !fake.NodeEncryption {
+		t.Fatalf("EnableNodeEncryption: %v", err)
+	}
+	if err = c.SetClusterEncryptionLevel(EncryptionAll); err != nil {
+		t.Errorf("SetClusterEncryptionLevel: %v", err)
+	}
+	if level := fake.Settings["security"]["clusterEncryptionLevel"]; level != EncryptionAll {
+		t.Errorf("unexpected encryption level %q", level)
+	}
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"net/url"
+)
+
+// A ServerGroup is a group of nodes, usually a rack or availability zone.
+// Couchbase keeps the replicas of a vBucket in different groups.
+type ServerGroup struct {
+	Name  string            `json:"name,omitempty"`
+	URI   string            `json:"uri"`
+	Nodes []ServerGroupNode `json:"nodes"`
+}
+
+// A ServerGroupNode is a member of a server group.
+type ServerGroupNode struct {
+	OTPNode  string `json:"otpNode"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// ServerGroups are the server groups of a cluster.  URI carries the revision
+// the groups were read at, and is where changes to them are sent.
+type ServerGroups struct {
+	Groups []ServerGroup `json:"groups"`
+	URI    string        `json:"uri"`
+}
+
+// ServerGroups returns the server groups of the cluster.
+func (c *Client) ServerGroups() (*ServerGroups, error) {
+	var groups ServerGroups
+	err := c.getJSON("ServerGroups", "/pools/default/serverGroups", &groups)
+	if err != nil {
+		return nil, err
+	}
+	return &groups, nil
+}
+
+// CreateServerGroup adds an empty server group.
+func (c *Client) CreateServerGroup(name string) error {
+	form := url.Values{}
+	form.Set("name", name)
+	return c.postForm("CreateServerGroup", "/pools/default/serverGroups", form, nil)
+}
+
+// MoveNodes places nodes into server groups: members maps the name of each
+// group to the otpNode names of the nodes it should hold.  Nodes that are not
+// listed stay where they are.  The groups must exist, and buckets only follow
+// the new layout after a rebalance.
+func (c *Client) MoveNodes(members map[string][]string) error {
+	groups, err := c.ServerGroups()
+	if err != nil {
+		return err
+	}
+	moved := make(map[string]bool)
+	for _, nodes := range members {
+		for _, node := range nodes {
+			moved[node] = true
+		}
+	}
+
+	known := make(map[string]bool)
+	update := ServerGroups{}
+	for _, group := range groups.Groups {
+		updated := ServerGroup{URI: group.URI, Nodes: []ServerGroupNode{}}
+		for _, node := range group.Nodes {
+			if !moved[node.OTPNode] {
+				updated.Nodes = append(updated.Nodes, ServerGroupNode{OTPNode: node.OTPNode})
+			}
+		}
+		known[group.Name] = true
+		for _, node := range members[group.Name] {
+			updated.Nodes = append(updated.Nodes, ServerGroupNode{OTPNode: node})
+		}
+		update.Groups = append(update.Groups, updated)
+	}
+	for name := range members {
+		if !known[name] {
+			return &Error{Operation: "MoveNodes", URL: c.baseURL, Message: "unknown server group " + name}
+		}
+	}
+	return c.putJSON("MoveNodes", groups.URI, update, nil)
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+)
+
+func TestServerGroups(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.Nodes = append(fake.Nodes, &admintest.FakeNode{OTPNode: "ns_1@10.244.1.6", Hostname: "10.244.1.6:8091"})
+	c := newTestClient(fake.URL)
+	c.Retries = 0
+
+	if err := c.CreateServerGroup("z2"); err != nil {
+		t.Fatalf("CreateServerGroup: %v", err)
+	}
+	if err := c.MoveNodes(map[string][]string{"z2": {"ns_1@10.244.1.6"}}); err != nil {
+		t.Fatalf("MoveNodes: %v", err)
+	}
+	groups, err := c.ServerGroups()
+	if err != nil {
+		t.Fatalf("ServerGroups: %v", err)
+	}
+	if len(groups.Groups) != 2 || len(groups.Groups[0].Nodes) != 1 ||
+		len(groups.Groups[1].Nodes) != 1 || groups.Groups[1].Nodes[0].OTPNode != "ns_1@10.244.1.6" {
+		t.Errorf("unexpected server groups %+v", groups)
+	}
+	if err = c.MoveNodes(map[string][]string{"z3": {"ns_1@10.244.1.6"}}); err == nil {
+		t.Errorf("expected moving a node to an unknown group to fail")
+	}
+}
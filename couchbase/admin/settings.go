@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// SetAutoFailover enables or disables automatic failover after timeout seconds.
+func (c *Client) SetAutoFailover(enabled bool, timeout int) error {
+	form := url.Values{}
+	form.Set("enabled", strconv.FormatBool(enabled))
+	if enabled {
+		form.Set("timeout", strconv.Itoa(timeout))
+	}
+	return c.setForm("SetAutoFailover", "/settings/autoFailover", form)
+}
+
+// SetIndexSettings sets the global secondary index storage mode
+// (forestdb, memory_optimized or plasma).
+func (c *Client) SetIndexSettings(storageMode string) error {
+	form := url.Values{}
+	form.Set("storageMode", storageMode)
+	return c.setForm("SetIndexSettings", "/settings/indexes", form)
+}
+
+// SetQuerySettings sets cluster-wide query service settings (e.g.
+// queryTmpSpaceSize, queryPipelineBatch).
+func (c *Client) SetQuerySettings(settings url.Values) error {
+	return c.setForm("SetQuerySettings", "/settings/querySettings", settings)
+}
+
+// SetReplicationSettings sets the default XDCR replication settings (e.g.
+// checkpointInterval, workerBatchSize).
+func (c *Client) SetReplicationSettings(settings url.Values) error {
+	return c.setForm("SetReplicationSettings", "/settings/replications", settings)
+}
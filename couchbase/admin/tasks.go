@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Task is a cluster task (rebalance, compaction, ...) as reported by
+// /pools/default/tasks.
+type Task struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	// Progress is the percentage done, while the task is running.
+	Progress float64 `json:"progress"`
+	// ErrorMessage is set on a rebalance task whose last run failed.
+	ErrorMessage string `json:"errorMessage"`
+	// Bucket is set on tasks for one bucket, e.g. loadingSampleBucket.
+	Bucket string `json:"bucket"`
+
+	// ID, Errors and ChangesLeft are set on xdcr tasks, one per replication.
+	ID          string   `json:"id"`
+	Errors      []string `json:"errors"`
+	ChangesLeft int64    `json:"changesLeft"`
+}
+
+// Task types.
+const (
+	TaskRebalance           = "rebalance"
+	TaskLoadingSampleBucket = "loadingSampleBucket"
+	TaskXDCR                = "xdcr"
+)
+
+// RebalanceStatus is the state of the last rebalance, as reported by
+// /pools/default/rebalanceProgress.
+type RebalanceStatus struct {
+	// Status is "running" while a rebalance is in progress, "none" otherwise.
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// Tasks returns the cluster tasks.
+func (c *Client) Tasks() ([]Task, error) {
+	var tasks []Task
+	err := c.getJSON("Tasks", "/pools/default/tasks", &tasks)
+	return tasks, err
+}
+
+// RebalanceProgress returns the state of the last rebalance.
+func (c *Client) RebalanceProgress() (*RebalanceStatus, error) {
+	var status RebalanceStatus
+	err := c.getJSON("RebalanceProgress", "/pools/default/rebalanceProgress", &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// WaitForRebalance polls the cluster every interval until the current
+// rebalance completes, passing the percentage done to progress (if not nil).
+// It returns an error carrying the Couchbase message if the rebalance fails,
+// or if it is still running after timeout.
+func (c *Client) WaitForRebalance(interval, timeout time.Duration, progress func(percent float64)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := c.RebalanceProgress()
+		if err != nil {
+			return err
+		}
+		if status.Status != "running" {
+			if status.ErrorMessage != "" {
+				return &Error{Operation: "Rebalance", URL: c.baseURL, Message: status.ErrorMessage}
+			}
+			return nil
+		}
+
+		tasks, err := c.Tasks()
+		if err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			if task.Type == TaskRebalance && task.Status == "running" && progress != nil {
+				progress(task.Progress)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return &Error{Operation: "Rebalance", URL: c.baseURL, Message: fmt.Sprintf("still running after %v", timeout)}
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+)
+
+func TestWaitForRebalance(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.RebalanceSteps = 3
+	c := newTestClient(fake.URL)
+
+	if _, err := c.AddNode("10.244.1.6", "user1", "password1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rebalance([]string{"ns_1@127.0.0.1", "ns_1@10.244.1.6"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var reported []float64
+	err := c.WaitForRebalance(time.Millisecond, time.Second, func(percent float64) {
+		reported = append(reported, percent)
+	})
+	if err != nil {
+		t.Fatalf("WaitForRebalance: %v", err)
+	}
+	if len(reported) != 3 || reported[2] != 100 {
+		t.Errorf("unexpected progress reports %v", reported)
+	}
+
+	fake.RebalanceFailure = "Rebalance failed. See logs for detailed reason. You can try again."
+	if err = c.Rebalance([]string{"ns_1@127.0.0.1", "ns_1@10.244.1.6"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	err = c.WaitForRebalance(time.Millisecond, time.Second, nil)
+	if err == nil || !strings.Contains(err.Error(), fake.RebalanceFailure) {
+		t.Errorf("expected the rebalance failure, got %v", err)
+	}
+
+	fake.RebalanceFailure = ""
+	fake.RebalanceSteps = 1000
+	if err = c.Rebalance([]string{"ns_1@127.0.0.1", "ns_1@10.244.1.6"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WaitForRebalance(time.Millisecond, 10*time.Millisecond, nil); err == nil {
+		t.Errorf("expected a timeout")
+	}
+}
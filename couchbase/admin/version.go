@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Version is a Couchbase Server release, e.g. 6.6.2.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses a version as reported by Couchbase
+// (e.g. "6.6.2-9588-enterprise") or written in a plan (e.g. "6.6").
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	release := strings.SplitN(s, "-", 2)[0]
+	parts := strings.Split(release, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid Couchbase version %q", s)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid Couchbase version %q", s)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+// String returns the version as major.minor.patch.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// IsZero reports whether the version is unknown.
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+// AtLeast reports whether v is the same release as min or a later one.
+func (v Version) AtLeast(min Version) bool {
+	if v.Major != min.Major {
+		return v.Major > min.Major
+	}
+	if v.Minor != min.Minor {
+		return v.Minor > min.Minor
+	}
+	return v.Patch >= min.Patch
+}
+
+// Releases that changed the REST API the broker uses.
+var (
+	// VersionRBAC replaced bucket SASL passwords with RBAC users.
+	VersionRBAC = Version{Major: 5}
+	// VersionNodeEncryption added TLS between the nodes of a cluster.
+	VersionNodeEncryption = Version{Major: 5, Minor: 5}
+	// VersionCollections added scopes and collections within buckets.
+	VersionCollections = Version{Major: 7}
+)
+
+// ServerVersion returns the version of the node, from /pools.
+func (c *Client) ServerVersion() (Version, error) {
+	var pools struct {
+		ImplementationVersion string `json:"implementationVersion"`
+	}
+	err := c.getJSON("ServerVersion", "/pools", &pools)
+	if err != nil {
+		return Version{}, err
+	}
+	return ParseVersion(pools.ImplementationVersion)
+}
@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+)
+
+func TestServerVersion(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.MemoryQuota = 1024
+	c := newTestClient(fake.URL)
+	c.Retries = 0
+
+	version, err := c.ServerVersion()
+	if err != nil {
+		t.Fatalf("ServerVersion: %v", err)
+	}
+	if version != (Version{4, 5, 1}) || version.AtLeast(VersionRBAC) {
+		t.Errorf("unexpected version %v", version)
+	}
+
+	fake.Version = "7.1.4-3601-enterprise"
+	if version, _ = c.ServerVersion(); !version.AtLeast(VersionCollections) || version.String() != "7.1.4" {
+		t.Errorf("unexpected version %v", version)
+	}
+	if err = c.CreateBucket(BucketSettings{Name: "orders", RAMQuotaMB: 256, AuthType: "sasl", SASLPassword: "pw"}); err == nil {
+		t.Errorf("expected SASL buckets to be refused by 7.x")
+	}
+	if err = c.CreateBucket(BucketSettings{Name: "orders", RAMQuotaMB: 256}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.CreateScope("orders", "shop"); err != nil {
+		t.Fatalf("CreateScope: %v", err)
+	}
+	if err = c.CreateCollection("orders", "shop", "carts"); err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	if collections := fake.Buckets["orders"].Scopes["shop"]; len(collections) != 1 || collections[0] != "carts" {
+		t.Errorf("unexpected collections %v", collections)
+	}
+
+	for _, s := range []string{"6.6", "7", "5.0.1-1234"} {
+		if _, err = ParseVersion(s); err != nil {
+			t.Errorf("ParseVersion(%q): %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "six", "1.2.3.4", "6.-1"} {
+		if _, err = ParseVersion(s); err == nil {
+			t.Errorf("expected ParseVersion(%q) to fail", s)
+		}
+	}
+	if !(Version{6, 6, 0}).AtLeast(Version{6, 5, 1}) || (Version{6, 5, 0}).AtLeast(Version{6, 5, 1}) {
+		t.Errorf("AtLeast is wrong")
+	}
+}
@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"net/url"
+)
+
+// A RemoteCluster is a cluster reference used as the target of XDCR
+// replications.
+type RemoteCluster struct {
+	Name     string `json:"name"`
+	UUID     string `json:"uuid"`
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	Deleted  bool   `json:"deleted"`
+}
+
+// CreateRemoteCluster adds a reference to the cluster at hostname (host:port
+// of one of its nodes), authenticating as username/password.
+func (c *Client) CreateRemoteCluster(name, hostname, username, password string) (*RemoteCluster, error) {
+	return c.createRemoteCluster(remoteClusterForm(name, hostname, username, password))
+}
+
+// CreateSecureRemoteCluster adds a reference to the cluster at hostname like
+// CreateRemoteCluster, but replications to it are encrypted, trusting the
+// PEM encoded CA certificate.
+func (c *Client) CreateSecureRemoteCluster(name, hostname, username, password string, caPEM []byte) (*RemoteCluster, error) {
+	form := remoteClusterForm(name, hostname, username, password)
+	form.Set("demandEncryption", "1")
+	form.Set("certificate", string(caPEM))
+	return c.createRemoteCluster(form)
+}
+
+func remoteClusterForm(name, hostname, username, password string) url.Values {
+	form := url.Values{}
+	form.Set("name", name)
+	form.Set("hostname", hostname)
+	form.Set("username", username)
+	form.Set("password", password)
+	return form
+}
+
+func (c *Client) createRemoteCluster(form url.Values) (*RemoteCluster, error) {
+	var remote RemoteCluster
+	err := c.postForm("CreateRemoteCluster", "/pools/default/remoteClusters", form, &remote)
+	if err != nil {
+		return nil, err
+	}
+	return &remote, nil
+}
+
+// ListRemoteClusters returns the remote cluster references.
+func (c *Client) ListRemoteClusters() ([]RemoteCluster, error) {
+	var remotes []RemoteCluster
+	err := c.getJSON("ListRemoteClusters", "/pools/default/remoteClusters", &remotes)
+	return remotes, err
+}
+
+// DeleteRemoteCluster removes a remote cluster reference.  Replications to
+// the cluster must be cancelled first.
+func (c *Client) DeleteRemoteCluster(name string) error {
+	return c.delete("DeleteRemoteCluster", "/pools/default/remoteClusters/"+url.QueryEscape(name))
+}
+
+// CreateReplication starts a continuous replication of fromBucket to toBucket
+// on the remote cluster named toCluster, and returns the replication ID.
+func (c *Client) CreateReplication(fromBucket, toCluster, toBucket string) (string, error) {
+	form := url.Values{}
+	form.Set("fromBucket", fromBucket)
+	form.Set("toCluster", toCluster)
+	form.Set("toBucket", toBucket)
+	form.Set("replicationType", "continuous")
+	var result struct {
+		ID string `json:"id"`
+	}
+	err := c.postForm("CreateReplication", "/controller/createReplication", form, &result)
+	return result.ID, err
+}
+
+// CancelReplication stops and removes a replication.
+func (c *Client) CancelReplication(id string) error {
+	return c.delete("CancelReplication", "/controller/cancelXDCR/"+url.QueryEscape(id))
+}
+
+// Replication returns the xdcr task of the replication with the given ID, or
+// nil if the cluster has no such replication.
+func (c *Client) Replication(id string) (*Task, error) {
+	tasks, err := c.Tasks()
+	if err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		if tasks[i].Type == TaskXDCR && tasks[i].ID == id {
+			return &tasks[i], nil
+		}
+	}
+	return nil, nil
+}
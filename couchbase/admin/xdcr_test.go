@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+)
+
+func TestReplication(t *testing.T) {
+	fake := admintest.NewServer()
+	defer fake.Close()
+	fake.MemoryQuota = 1024
+	c := newTestClient(fake.URL)
+	c.Retries = 0
+	if err := c.CreateBucket(BucketSettings{Name: "orders", RAMQuotaMB: 256}); err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := c.CreateRemoteCluster("dr", "10.244.2.2:8091", "admin2", "secret")
+	if err != nil {
+		t.Fatalf("CreateRemoteCluster: %v", err)
+	}
+	if remote.UUID == "" || fake.RemoteClusters["dr"].Password != "secret" {
+		t.Errorf("unexpected remote cluster %+v", remote)
+	}
+	id, err := c.CreateReplication("orders", "dr", "orders-copy")
+	if err != nil {
+		t.Fatalf("CreateReplication: %v", err)
+	}
+	task, err := c.Replication(id)
+	if err != nil || task == nil || task.Status != "running" {
+		t.Errorf("unexpected replication task %+v, %v", task, err)
+	}
+
+	if err = c.DeleteRemoteCluster("dr"); err == nil {
+		t.Errorf("expected deleting a referenced remote cluster to fail")
+	}
+	if err = c.CancelReplication(id); err != nil {
+		t.Fatalf("CancelReplication: %v", err)
+	}
+	if task, _ = c.Replication(id); task != nil {
+		t.Errorf("replication not cancelled: %+v", task)
+	}
+	if err = c.DeleteRemoteCluster("dr"); err != nil {
+		t.Fatalf("DeleteRemoteCluster: %v", err)
+	}
+	if remotes, _ := c.ListRemoteClusters(); len(remotes) != 0 {
+		t.Errorf("remote cluster not deleted: %+v", remotes)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	conf "github.com/ssdowd/couchbasebroker/config"
 	utils "github.com/ssdowd/couchbasebroker/utils"
@@ -50,11 +52,27 @@ func main() {
 		panic(fmt.Sprintf("Error creating server [%v]...", err))
 	}
 
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go reloadSecretsOnHangup(hangup)
+
 	server.Start()
 }
 
 // Private func
 
+// reloadSecretsOnHangup re-reads the secret config fields whenever the broker gets a SIGHUP.
+func reloadSecretsOnHangup(hangup <-chan os.Signal) {
+	for range hangup {
+		err := conf.ReloadSecrets()
+		if err != nil {
+			utils.Logger.Printf("WARNING: could not reload secrets: %v\n", err)
+			continue
+		}
+		utils.Logger.Printf("Reloaded secrets after SIGHUP\n")
+	}
+}
+
 func checkCloudName(name string) error {
 	switch name {
 	case utils.DOCKER, utils.AWS, utils.SOFTLAYER, utils.SL, utils.BOSH:
@@ -9,6 +9,9 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 )
 
@@ -94,3 +97,29 @@ func TestGetCatalog(t *testing.T) {
 		t.Errorf("Failed unmarshalling catalog json: %v", err)
 	}
 }
+
+func TestReloadSecretsOnHangup(t *testing.T) {
+	defer config.LoadConfig(opt.ConfigPath)
+	dir, err := ioutil.TempDir("", "cbbroker-main-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	password := filepath.Join(dir, "password")
+	configPath := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(password, []byte("old"), 0600)
+	ioutil.WriteFile(configPath, []byte(`{"restuser": "admin", "restpassword": "file:`+password+`"}`), 0600)
+	c, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(password, []byte("new"), 0600)
+	hangup := make(chan os.Signal, 1)
+	hangup <- syscall.SIGHUP
+	close(hangup)
+	reloadSecretsOnHangup(hangup)
+	if _, pw := c.RestCredentials(); pw != "new" {
+		t.Errorf("password after SIGHUP: %q", pw)
+	}
+}
@@ -0,0 +1,58 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An Error is a failure to read, merge or evaluate one value of a manifest.
+type Error struct {
+	// Source is the document the value comes from, e.g. stub.yml.
+	Source string
+	// Path is where the value is in the manifest, e.g.
+	// jobs.couchbase4.instances.  It is empty for errors about a whole
+	// document.
+	Path string
+	// Operator is the (( ... )) expression that failed, if any.
+	Operator string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	var parts []string
+	for _, part := range []string{e.Source, e.Path, e.Operator} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	parts = append(parts, e.Err.Error())
+	return strings.Join(parts, ": ")
+}
+
+// Errors holds every failure of a merge.  Merge returns all of them rather
+// than the first one, so that a broken template can be fixed in one go.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return "manifest: " + e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("manifest: %d errors:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// sorted returns the errors ordered by source and path, as the order they
+// are found in depends on map iteration.
+func (e Errors) sorted() Errors {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Source != e[j].Source {
+			return e[i].Source < e[j].Source
+		}
+		return e[i].Path < e[j].Path
+	})
+	return e
+}
@@ -0,0 +1,142 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An evaluator replaces the operators of a merged manifest by their values.
+type evaluator struct {
+	root map[interface{}]interface{}
+	errs Errors
+}
+
+// eval evaluates the operators within node, in place, and returns its value.
+func (e *evaluator) eval(node interface{}, path string) interface{} {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range n {
+			n[k] = e.eval(v, childPath(path, k))
+		}
+	case []interface{}:
+		for i, v := range n {
+			n[i] = e.eval(v, elementPath(path, i, v))
+		}
+	case *operator:
+		v, _ := e.resolve(n, path)
+		return v
+	}
+	return node
+}
+
+// resolve returns the value of op, found at path, evaluating it the first
+// time.  A failure is recorded once, where the operator is.
+func (e *evaluator) resolve(op *operator, path string) (interface{}, error) {
+	switch op.state {
+	case resolved:
+		return op.value, op.err
+	case resolving:
+		return nil, errors.New("the reference refers back to itself")
+	}
+	op.state = resolving
+	op.value, op.err = e.apply(op)
+	op.state = resolved
+	if op.err != nil {
+		e.errs = append(e.errs, &Error{Source: op.source, Path: path, Operator: op.text, Err: op.err})
+	}
+	return op.value, op.err
+}
+
+func (e *evaluator) apply(op *operator) (interface{}, error) {
+	err := errors.New("no later document provides this value")
+	for _, operand := range op.operands {
+		if operand.ref == "" {
+			return operand.literal, nil
+		}
+		var v interface{}
+		v, err = e.lookup(operand.ref)
+		if err == nil {
+			return copyValue(v), nil
+		}
+	}
+	return nil, err
+}
+
+// lookup returns the evaluated value at ref.  A segment of ref is a map key,
+// a list index such as [0], or the name of a list element.
+func (e *evaluator) lookup(ref string) (interface{}, error) {
+	var node interface{} = e.root
+	path := ""
+	for _, key := range strings.Split(ref, ".") {
+		if op, ok := node.(*operator); ok {
+			v, err := e.resolve(op, path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot grab %s: %s: %v", ref, path, err)
+			}
+			node = v
+		}
+		switch n := node.(type) {
+		case map[interface{}]interface{}:
+			v, ok := n[key]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", childPath(path, key))
+			}
+			node = v
+		case []interface{}:
+			v, ok := listElement(n, key)
+			if !ok {
+				return nil, fmt.Errorf("%s not found", childPath(path, key))
+			}
+			node = v
+		default:
+			return nil, fmt.Errorf("%s not found: %s is not a map or a list", childPath(path, key), path)
+		}
+		path = childPath(path, key)
+	}
+	if op, ok := node.(*operator); ok {
+		if _, err := e.resolve(op, path); err != nil {
+			return nil, fmt.Errorf("cannot grab %s: %s: %v", ref, path, err)
+		}
+	}
+	// operators within the value that fail are reported where they are
+	return e.eval(node, path), nil
+}
+
+// listElement returns the element of list at index [i], or named name.
+func listElement(list []interface{}, key string) (interface{}, bool) {
+	if strings.HasPrefix(key, "[") && strings.HasSuffix(key, "]") {
+		i, err := strconv.Atoi(key[1 : len(key)-1])
+		if err != nil || i < 0 || i >= len(list) {
+			return nil, false
+		}
+		return list[i], true
+	}
+	for _, v := range list {
+		if name, ok := elementName(v); ok && name == key {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// copyValue returns a deep copy of v, so that a grabbed value is not shared
+// with the value it was grabbed from.
+func copyValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(n))
+		for k, v := range n {
+			m[k] = copyValue(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(n))
+		for i, v := range n {
+			l[i] = copyValue(v)
+		}
+		return l
+	}
+	return v
+}
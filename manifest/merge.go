@@ -0,0 +1,318 @@
+// Package manifest builds BOSH deployment manifests from YAML templates, the
+// way spruce does: each document is merged over the ones before it, then the
+// (( ... )) operators left in the result are evaluated.
+//
+// Maps are merged key by key, the later document winning.  Lists of maps
+// that all have a name are merged by name, other lists index by index,
+// unless the list starts with one of these operators:
+//
+//	(( append ))   adds the elements after those of the earlier list
+//	(( prepend ))  adds them before
+//	(( replace ))  drops the earlier list
+//	(( merge ))    merges by name, all elements must have one
+//	(( inline ))   merges index by index
+//
+// In place of a value, a template may use:
+//
+//	(( grab path ))       the value at path, e.g. networks.[0].name or
+//	                      jobs.couchbase4.instances
+//	(( replace ))         a value a later document must provide
+//	(( append ))          a list a later document must provide
+//
+// Each of these can be followed by alternatives, tried in order when the
+// reference is not found or nothing overrides the placeholder:
+// (( replace || "cb-default" )), (( grab meta.size || 1 )).
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A Source is a YAML document to merge.
+type Source struct {
+	// Name identifies the document in errors, e.g. its file name.
+	Name string
+	YAML []byte
+}
+
+// ReadFiles returns the sources for the files at paths, named after the base
+// names of the files.
+func ReadFiles(paths ...string) ([]Source, error) {
+	sources := make([]Source, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, Source{Name: filepath.Base(path), YAML: data})
+	}
+	return sources, nil
+}
+
+// Merge merges the sources in order, evaluates the operators and removes the
+// prune paths (e.g. couchbase, or meta.sizes) from the result, which it
+// returns as YAML.  All the problems found are returned together as Errors.
+func Merge(sources []Source, prune ...string) ([]byte, error) {
+	m := &merger{}
+	root := map[interface{}]interface{}{}
+	for _, source := range sources {
+		doc := m.load(source)
+		if doc != nil {
+			m.source = source.Name
+			m.mergeMap(root, doc, "")
+		}
+	}
+	if len(m.errs) > 0 {
+		return nil, m.errs.sorted()
+	}
+
+	e := &evaluator{root: root}
+	e.eval(root, "")
+	if len(e.errs) > 0 {
+		return nil, e.errs.sorted()
+	}
+	for _, path := range prune {
+		prunePath(root, path)
+	}
+	return yaml.Marshal(root)
+}
+
+// A merger merges documents, collecting the errors.
+type merger struct {
+	// source is the name of the document being merged.
+	source string
+	errs   Errors
+}
+
+func (m *merger) fail(path string, op *operator, err error) {
+	e := &Error{Source: m.source, Path: path, Err: err}
+	if op != nil {
+		e.Operator = op.text
+	}
+	m.errs = append(m.errs, e)
+}
+
+// load parses a source and replaces its operators by *operator values.  It
+// returns nil for an empty document or when the source is invalid.
+func (m *merger) load(source Source) map[interface{}]interface{} {
+	m.source = source.Name
+	var doc interface{}
+	err := yaml.Unmarshal(source.YAML, &doc)
+	if err != nil {
+		m.fail("", nil, err)
+		return nil
+	}
+	if doc == nil {
+		return nil
+	}
+	root, ok := doc.(map[interface{}]interface{})
+	if !ok {
+		m.fail("", nil, errors.New("the document is not a map"))
+		return nil
+	}
+	m.parseOperators(root, "")
+	return root
+}
+
+// parseOperators replaces the operator strings within node, in place.
+func (m *merger) parseOperators(node interface{}, path string) interface{} {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range n {
+			n[k] = m.parseOperators(v, childPath(path, k))
+		}
+	case []interface{}:
+		for i, v := range n {
+			elemPath := elementPath(path, i, v)
+			s, ok := v.(string)
+			if !ok || !isOperator(s) {
+				n[i] = m.parseOperators(v, elemPath)
+				continue
+			}
+			op, err := parseOperator(m.source, s)
+			switch {
+			case err != nil:
+				m.fail(elemPath, nil, fmt.Errorf("%s: %v", s, err))
+			case i == 0 && listOperators[op.name]:
+				if len(op.operands) > 0 {
+					m.fail(elemPath, op, errors.New("list operators take no alternatives"))
+				}
+				n[i] = op
+			case listOperators[op.name] && op.name != opReplace && op.name != opAppend:
+				m.fail(elemPath, op, errors.New("list operators must come first in the list"))
+			default:
+				n[i] = op
+			}
+		}
+	case string:
+		if !isOperator(n) {
+			return n
+		}
+		op, err := parseOperator(m.source, n)
+		if err != nil {
+			m.fail(path, nil, fmt.Errorf("%s: %v", n, err))
+			return n
+		}
+		if !valueOperators[op.name] {
+			m.fail(path, op, fmt.Errorf("%s may only start a list", op.name))
+			return n
+		}
+		return op
+	}
+	return node
+}
+
+// merge returns overlay merged over base.  Maps and lists are merged into
+// base, other values replace it.
+func (m *merger) merge(base, overlay interface{}, path string) interface{} {
+	switch o := overlay.(type) {
+	case map[interface{}]interface{}:
+		b, ok := base.(map[interface{}]interface{})
+		if !ok {
+			b = map[interface{}]interface{}{}
+		}
+		m.mergeMap(b, o, path)
+		return b
+	case []interface{}:
+		return m.mergeList(base, o, path)
+	}
+	return overlay
+}
+
+func (m *merger) mergeMap(base, overlay map[interface{}]interface{}, path string) {
+	for k, v := range overlay {
+		base[k] = m.merge(base[k], v, childPath(path, k))
+	}
+}
+
+// mergeList merges the list overlay over base, as its leading operator, if
+// any, says.
+func (m *merger) mergeList(base interface{}, overlay []interface{}, path string) []interface{} {
+	how := ""
+	if len(overlay) > 0 {
+		if op, ok := overlay[0].(*operator); ok && listOperators[op.name] {
+			how = op.name
+			overlay = overlay[1:]
+		}
+	}
+	// a scalar placeholder, such as (( append )), counts as an empty list
+	b, _ := base.([]interface{})
+
+	switch how {
+	case opReplace:
+		return m.mergeInline(nil, overlay, path)
+	case opAppend:
+		return append(b, m.mergeInline(nil, overlay, path)...)
+	case opPrepend:
+		return append(m.mergeInline(nil, overlay, path), b...)
+	case opInline:
+		return m.mergeInline(b, overlay, path)
+	case opMerge:
+		for i, v := range overlay {
+			if _, ok := elementName(v); !ok {
+				m.fail(elementPath(path, i, v), nil, errors.New("(( merge )) needs every element to be a map with a name"))
+				return b
+			}
+		}
+		return m.mergeByName(b, overlay, path)
+	}
+	if len(overlay) > 0 && allNamed(b) && allNamed(overlay) {
+		return m.mergeByName(b, overlay, path)
+	}
+	return m.mergeInline(b, overlay, path)
+}
+
+// mergeInline merges the elements of overlay over those of base at the same
+// index.
+func (m *merger) mergeInline(base, overlay []interface{}, path string) []interface{} {
+	for i, v := range overlay {
+		if i < len(base) {
+			base[i] = m.merge(base[i], v, elementPath(path, i, v))
+		} else {
+			base = append(base, m.merge(nil, v, elementPath(path, i, v)))
+		}
+	}
+	return base
+}
+
+// mergeByName merges the elements of overlay over those of base with the same
+// name, appending the others.
+func (m *merger) mergeByName(base, overlay []interface{}, path string) []interface{} {
+	for _, v := range overlay {
+		name, _ := elementName(v)
+		elemPath := childPath(path, name)
+		found := false
+		for i, bv := range base {
+			if bn, _ := elementName(bv); bn == name {
+				base[i] = m.merge(bv, v, elemPath)
+				found = true
+				break
+			}
+		}
+		if !found {
+			base = append(base, m.merge(nil, v, elemPath))
+		}
+	}
+	return base
+}
+
+// elementName returns the name of a list element that is a map with a
+// literal name.
+func elementName(v interface{}) (string, bool) {
+	elem, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return "", false
+	}
+	switch name := elem["name"].(type) {
+	case nil, *operator, map[interface{}]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(name), true
+	}
+}
+
+func allNamed(list []interface{}) bool {
+	for _, v := range list {
+		if _, ok := elementName(v); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// childPath returns the path of key within the map at path.
+func childPath(path string, key interface{}) string {
+	if path == "" {
+		return fmt.Sprint(key)
+	}
+	return path + "." + fmt.Sprint(key)
+}
+
+// elementPath returns the path of the element v at index i of the list at
+// path: by name when it has one, as grab accepts both.
+func elementPath(path string, i int, v interface{}) string {
+	if name, ok := elementName(v); ok {
+		return childPath(path, name)
+	}
+	return childPath(path, fmt.Sprintf("[%d]", i))
+}
+
+// prunePath removes the value at path from root, if there is one.
+func prunePath(root map[interface{}]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	node := root
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := node[key].(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		node = next
+	}
+	delete(node, keys[len(keys)-1])
+}
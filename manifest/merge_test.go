@@ -0,0 +1,227 @@
+package manifest
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const templateDir = "../bosh-templates"
+
+// deploymentStub is what the broker merges over the templates for a
+// deployment.
+const deploymentStub = `name: cb-0123456789
+director_uuid: 1b5c4d1e-1d4a-4f43-9d3c-0f0d3c1a2b3c
+couchbase:
+  instances: 3
+  resource_pool: default
+`
+
+// awsVars are the vars of a template profile for AWS.
+const awsVars = `vars:
+  network_range: 10.0.16.0/24
+  network_gateway: 10.0.16.1
+  network_dns: [10.0.0.2]
+  network_reserved: [10.0.16.2 - 10.0.16.9]
+  network_static: [10.0.16.10 - 10.0.16.40]
+  network_cloud_properties: {subnet: subnet-0a1b2c3d}
+  stemcell_name: bosh-aws-xen-hvm-ubuntu-trusty-go_agent
+  stemcell_version: 3147
+  resource_pool_cloud_properties: {instance_type: m4.large, availability_zone: us-east-1a}
+  compilation_cloud_properties: {instance_type: c4.large, availability_zone: us-east-1a}
+`
+
+func templates(t *testing.T, names ...string) []Source {
+	var paths []string
+	for _, name := range names {
+		paths = append(paths, filepath.Join(templateDir, name))
+	}
+	sources, err := ReadFiles(paths...)
+	if err != nil {
+		t.Fatalf("ReadFiles: %v", err)
+	}
+	return sources
+}
+
+func TestGolden(t *testing.T) {
+	boshLite := []string{
+		"base-cb-deploy.yml",
+		"network-bosh-lite.yml",
+		"resources-bosh-lite.yml",
+		"couchbase-job-defaults.yml",
+		"stub.yml",
+	}
+	tests := []struct {
+		golden  string
+		sources []Source
+	}{
+		// the templates as they ship, with stub.yml's defaults
+		{"templates.golden", templates(t, boshLite...)},
+		// what the broker deploys
+		{"deployment.golden", append(templates(t, boshLite...), Source{Name: "deployment", YAML: []byte(deploymentStub)})},
+		// a job merged by name, and another appended
+		{"jobs.golden", append(templates(t, append(boshLite, "some-other-job-defaults.yml")...),
+			Source{Name: "deployment", YAML: []byte(deploymentStub + `jobs:
+- name: couchbase4
+  properties:
+    couchbase:
+      admin: true
+`)})},
+		// a profile for another IaaS, with its vars
+		{"profile.golden", append(templates(t, "base-cb-deploy.yml", "network-manual.yml", "resources-iaas.yml", "couchbase-job-defaults.yml"),
+			Source{Name: "vars", YAML: []byte(awsVars)},
+			Source{Name: "deployment", YAML: []byte(deploymentStub)})},
+		// a v2 manifest, for plans with a cloudConfig
+		{"v2.golden", append(templates(t, "base-cb-deploy-v2.yml"), Source{Name: "deployment", YAML: []byte(`name: cb-0123456789
+stemcells:
+- alias: default
+  os: ubuntu-trusty
+  version: latest
+instance_groups:
+- name: couchbase4
+  instances: 3
+  azs: [z1, z2]
+  vm_type: medium
+  persistent_disk_type: 10GB
+  stemcell: default
+  networks:
+  - name: services
+  jobs:
+  - name: couchbase4
+    release: couchbase
+    properties: {}
+`)})},
+	}
+	for _, test := range tests {
+		got, err := Merge(test.sources, "couchbase", "vars")
+		if err != nil {
+			t.Errorf("%v: %v", test.golden, err)
+			continue
+		}
+		path := filepath.Join("testdata", test.golden)
+		if *update {
+			if err := ioutil.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v (run go test -update to create it)", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: the merge differs from the golden file (run go test -update to rewrite it):\n%s", test.golden, got)
+		}
+	}
+}
+
+func TestListOperators(t *testing.T) {
+	const list = "list: [a, b]"
+	const named = "list: [{name: one, value: 1}, {name: two, value: 2}]"
+	tests := []struct {
+		base, overlay string
+		want          string
+	}{
+		{list, "list: [c]", "list:\n- c\n- b\n"},
+		{list, "list: [(( inline )), c]", "list:\n- c\n- b\n"},
+		{list, "list: [(( append )), c]", "list:\n- a\n- b\n- c\n"},
+		{list, "list: [(( prepend )), c]", "list:\n- c\n- a\n- b\n"},
+		{list, "list: [(( replace )), c]", "list:\n- c\n"},
+		{"list: (( append ))", "list: [(( append )), c]", "list:\n- c\n"},
+		{named, "list: [{name: two, value: 3}, {name: three}]",
+			"list:\n- name: one\n  value: 1\n- name: two\n  value: 3\n- name: three\n"},
+		{named, "list: [(( merge )), {name: one, other: true}]",
+			"list:\n- name: one\n  other: true\n  value: 1\n- name: two\n  value: 2\n"},
+		{named, "list: [(( replace )), {name: three}]", "list:\n- name: three\n"},
+	}
+	for _, test := range tests {
+		got, err := Merge([]Source{
+			{Name: "base", YAML: []byte(test.base)},
+			{Name: "overlay", YAML: []byte(test.overlay)},
+		})
+		if err != nil {
+			t.Errorf("%v: %v", test.overlay, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%v over %v: got\n%s\nwant\n%s", test.overlay, test.base, got, test.want)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	got, err := Merge([]Source{{Name: "doc", YAML: []byte(`
+name: (( replace || "default" ))
+size: (( grab meta.sizes.[1] ))
+network: (( grab networks.services.subnet ))
+fallback: (( grab meta.missing || meta.sizes.[0] ))
+literal: (( grab meta.missing || 4 ))
+copy: (( grab meta ))
+meta:
+  sizes: [1, (( grab meta.large ))]
+  large: 8
+networks:
+- name: services
+  subnet: 10.0.0.0/24
+`)}}, "meta", "networks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `copy:
+  large: 8
+  sizes:
+  - 1
+  - 8
+fallback: 1
+literal: 4
+name: default
+network: 10.0.0.0/24
+size: 8
+`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want []Error
+	}{
+		{"uuid: (( replace ))", []Error{{Source: "doc", Path: "uuid", Operator: "(( replace ))"}}},
+		{"size: (( grab meta.size ))", []Error{{Source: "doc", Path: "size", Operator: "(( grab meta.size ))"}}},
+		{"a: (( grab b ))\nb: (( grab a ))", []Error{
+			{Source: "doc", Path: "a", Operator: "(( grab b ))"},
+			{Source: "doc", Path: "b", Operator: "(( grab a ))"},
+		}},
+		{"a: (( concat b ))", []Error{{Source: "doc", Path: "a"}}},
+		{"a: (( grab ))", []Error{{Source: "doc", Path: "a"}}},
+		{"a: (( merge ))", []Error{{Source: "doc", Path: "a", Operator: "(( merge ))"}}},
+		{"a: [x, (( prepend ))]", []Error{{Source: "doc", Path: "a.[1]", Operator: "(( prepend ))"}}},
+		{"a: [(( merge )), x]", []Error{{Source: "doc", Path: "a.[0]"}}},
+		{"a: [b", []Error{{Source: "doc"}}},
+		{"- a", []Error{{Source: "doc"}}},
+	}
+	for _, test := range tests {
+		_, err := Merge([]Source{{Name: "doc", YAML: []byte(test.doc)}})
+		errs, ok := err.(Errors)
+		if !ok {
+			t.Errorf("%q: got %v, want Errors", test.doc, err)
+			continue
+		}
+		if len(errs) != len(test.want) {
+			t.Errorf("%q: got %v, want %d errors", test.doc, errs, len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			got := errs[i]
+			if got.Source != want.Source || got.Path != want.Path || got.Operator != want.Operator {
+				t.Errorf("%q: got %#v, want %#v", test.doc, got, want)
+			}
+		}
+	}
+}
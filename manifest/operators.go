@@ -0,0 +1,158 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The operators a template may use, as in spruce.  grab, and replace or
+// append as placeholders, stand for a value; the list operators come first
+// in a list to say how it is merged with the list it is merged over.
+const (
+	opGrab    = "grab"
+	opReplace = "replace"
+	opAppend  = "append"
+	opPrepend = "prepend"
+	opMerge   = "merge"
+	opInline  = "inline"
+)
+
+var (
+	valueOperators = map[string]bool{opGrab: true, opReplace: true, opAppend: true}
+	listOperators  = map[string]bool{opReplace: true, opAppend: true, opPrepend: true, opMerge: true, opInline: true}
+)
+
+var operatorPattern = regexp.MustCompile(`^\(\(\s*(.*?)\s*\)\)$`)
+
+// An operand is a reference to another value of the manifest, e.g.
+// networks.[0].name, or a literal such as "cb-default".
+type operand struct {
+	ref     string
+	literal interface{}
+}
+
+// The states of an operator during evaluation.
+const (
+	pending = iota
+	resolving
+	resolved
+)
+
+// An operator is a (( ... )) expression found in a document.
+type operator struct {
+	source string
+	text   string
+	name   string
+	// operands are the alternatives separated by ||, tried in order.  The
+	// first operand of grab is its reference; replace and append have only
+	// the defaults used when no later document overrides them.
+	operands []operand
+
+	state int
+	value interface{}
+	err   error
+}
+
+// isOperator reports whether s is a (( ... )) expression.
+func isOperator(s string) bool {
+	return operatorPattern.MatchString(s)
+}
+
+// parseOperator parses the expression s, found in source.
+func parseOperator(source, s string) (*operator, error) {
+	tokens, err := tokenize(operatorPattern.FindStringSubmatch(s)[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty operator")
+	}
+	op := &operator{source: source, text: s, name: tokens[0]}
+	if !valueOperators[op.name] && !listOperators[op.name] {
+		return nil, fmt.Errorf("unknown operator %q", op.name)
+	}
+
+	args := tokens[1:]
+	if op.name != opGrab {
+		if len(args) == 0 {
+			return op, nil
+		}
+		if args[0] != "||" {
+			return nil, fmt.Errorf("%s takes no arguments", op.name)
+		}
+		args = args[1:]
+	} else if len(args) == 0 {
+		return nil, errors.New("grab needs a reference")
+	}
+	for i, arg := range args {
+		if i%2 == 1 {
+			if arg != "||" {
+				return nil, fmt.Errorf("expected || before %s", arg)
+			}
+			continue
+		}
+		if arg == "||" {
+			return nil, errors.New("missing operand around ||")
+		}
+		op.operands = append(op.operands, parseOperand(arg))
+	}
+	if len(args)%2 == 0 {
+		return nil, errors.New("missing operand after ||")
+	}
+	return op, nil
+}
+
+// tokenize splits an expression on white space, keeping quoted strings
+// whole.
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for expr = strings.TrimSpace(expr); expr != ""; expr = strings.TrimSpace(expr) {
+		if expr[0] == '"' {
+			end := 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, expr[:end+1])
+			expr = expr[end+1:]
+			continue
+		}
+		end := strings.IndexAny(expr, " \t")
+		if end < 0 {
+			end = len(expr)
+		}
+		tokens = append(tokens, expr[:end])
+		expr = expr[end:]
+	}
+	return tokens, nil
+}
+
+// parseOperand returns the literal or reference written as token.
+func parseOperand(token string) operand {
+	if strings.HasPrefix(token, `"`) {
+		if s, err := strconv.Unquote(token); err == nil {
+			return operand{literal: s}
+		}
+		return operand{literal: strings.Trim(token, `"`)}
+	}
+	switch token {
+	case "nil", "~", "null":
+		return operand{}
+	case "true", "false":
+		return operand{literal: token == "true"}
+	}
+	if i, err := strconv.Atoi(token); err == nil {
+		return operand{literal: i}
+	}
+	if f, err := strconv.ParseFloat(token, 64); err == nil {
+		return operand{literal: f}
+	}
+	return operand{ref: token}
+}
@@ -4,3 +4,15 @@ package model
 type Catalog struct {
 	Services []Service `json:"services"`
 }
+
+// FindPlan returns the plan with the given ID, or nil if no service has it.
+func (c *Catalog) FindPlan(planID string) *ServicePlan {
+	for i := range c.Services {
+		for j := range c.Services[i].Plans {
+			if c.Services[i].Plans[j].ID == planID {
+				return &c.Services[i].Plans[j]
+			}
+		}
+	}
+	return nil
+}
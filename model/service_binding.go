@@ -7,6 +7,17 @@ type Credential struct {
 	Password     string `json:"password"`
 	SASLPassword string `json:"saslpassword"`
 	BucketName   string `json:"bucket"`
+
+	// Scope and Collections are set when the bucket has a scope of its own
+	// (Couchbase 7.0 and later).
+	Scope       string   `json:"scope,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+
+	// With TLS, URI is the https endpoint of the cluster, ConnectionString
+	// lists its nodes for the SDKs (couchbases://...) and CACertificate is
+	// the PEM encoded CA that signed the node certificates.
+	ConnectionString string `json:"connection_string,omitempty"`
+	CACertificate    string `json:"ca_certificate,omitempty"`
 }
 
 // A ServiceBinding holds information about a binding between an app and a service.
@@ -16,6 +27,7 @@ type ServiceBinding struct {
 	AppID             string `json:"app_id"`
 	ServicePlanID     string `json:"service_plan_id"`
 	ServiceInstanceID string `json:"service_instance_id"`
+	CredHubRef        string `json:"credhub_ref,omitempty"`
 	Credential
 }
 
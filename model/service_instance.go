@@ -1,5 +1,10 @@
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // A ServiceInstance contains information about a created service.
 type ServiceInstance struct {
 	ID               string `json:"id"`
@@ -12,12 +17,107 @@ type ServiceInstance struct {
 
 	LastOperation *LastOperation `json:"last_operation, omitempty"`
 
+	// Setup tracks the configuration of Couchbase once the deployment is up.
+	// It is nil for instances created before setup was tracked.
+	Setup *LastOperation `json:"setup,omitempty"`
+
 	Parameters interface{} `json:"parameters, omitempty"`
 
+	// Replication is set when the instance's bucket is a replica of another
+	// instance's (the replicate_from parameter).
+	Replication *Replication `json:"replication,omitempty"`
+
+	// Health is what the health monitor last saw of the instance's nodes.
+	Health *InstanceHealth `json:"health,omitempty"`
+
 	Credential Credential
 	// Credential interface{} `json:"credentials, omitempty"`
 }
 
+// An UpdateServiceInstanceRequest is the body of a PATCH to a service instance.
+type UpdateServiceInstanceRequest struct {
+	ServiceID  string      `json:"service_id"`
+	PlanID     string      `json:"plan_id"`
+	Parameters interface{} `json:"parameters"`
+}
+
+// ProvisionParameters are the parameters a user can pass when creating a
+// service instance.
+type ProvisionParameters struct {
+	Instances int               `json:"instances"`
+	Bucket    *BucketSettings   `json:"bucket"`
+	Indexes   []IndexDefinition `json:"indexes"`
+
+	// SampleBuckets and Seed need a plan that allows sample data.
+	SampleBuckets []string `json:"sample_buckets"`
+	Seed          string   `json:"seed"`
+
+	// ReplicateFrom is the ID of an instance whose bucket is replicated (by
+	// XDCR) into the new instance's bucket.
+	ReplicateFrom string `json:"replicate_from"`
+
+	// TLS turns TLS on or off, as far as the plan allows.
+	TLS *bool `json:"tls"`
+}
+
+// DecodeParameters decodes the user-passed parameters of a provision request.
+func DecodeParameters(parameters interface{}) (*ProvisionParameters, error) {
+	var params ProvisionParameters
+	if parameters == nil {
+		return &params, nil
+	}
+	bytes, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &params)
+	if err != nil {
+		return nil, err
+	}
+	return &params, nil
+}
+
+// A Replication is an XDCR replication from the bucket of a source instance.
+// It is configured on the source cluster, with a remote cluster reference to
+// the replica.
+type Replication struct {
+	SourceInstanceID string   `json:"source_instance_id"`
+	RemoteCluster    string   `json:"remote_cluster"`
+	ReplicationID    string   `json:"replication_id"`
+	Status           string   `json:"status"`
+	Errors           []string `json:"errors,omitempty"`
+	ChangesLeft      int64    `json:"changes_left"`
+}
+
+// Instance health states.
+const (
+	HealthHealthy     = "healthy"
+	HealthDegraded    = "degraded"
+	HealthUnreachable = "unreachable"
+)
+
+// InstanceHealth is the state of a provisioned instance's cluster.
+type InstanceHealth struct {
+	Status    string       `json:"status"`
+	Summary   string       `json:"summary,omitempty"`
+	CheckedAt time.Time    `json:"checked_at"`
+	Nodes     []NodeHealth `json:"nodes,omitempty"`
+	// MemoryUsedPercent is the data held in memory as a share of the
+	// cluster's RAM quota.
+	MemoryUsedPercent float64 `json:"memory_used_percent"`
+	// CertificateExpires is when the certificate the nodes present expires,
+	// for instances provisioned with TLS.
+	CertificateExpires *time.Time `json:"certificate_expires,omitempty"`
+}
+
+// NodeHealth is the state of one node: Status is Couchbase's (healthy,
+// unhealthy or warmup).
+type NodeHealth struct {
+	Hostname   string `json:"hostname"`
+	Status     string `json:"status"`
+	FailedOver bool   `json:"failed_over"`
+}
+
 // A LastOperation contains information about the state of a service instance.
 type LastOperation struct {
 	State                    string `json:"state"`
@@ -34,5 +134,6 @@ type CreateServiceInstanceResponse struct {
 
 // A Message is a generic message object to return over REST as JSON.
 type Message struct {
+	Error       string `json:"error,omitempty"`
 	Description string `json:"description"`
 }
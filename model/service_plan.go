@@ -1,5 +1,9 @@
 package model
 
+import (
+	"encoding/json"
+)
+
 // A ServicePlan contains information about a service plan (description, name, Metadata, price).
 type ServicePlan struct {
 	Name        string      `json:"name"`
@@ -8,3 +12,161 @@ type ServicePlan struct {
 	Metadata    interface{} `json:"metadata, omitempty"`
 	Free        bool        `json:"free, omitempty"`
 }
+
+// PlanSettings are the Couchbase sizing settings a plan declares in its
+// metadata.  Zero values mean "use the broker default".
+type PlanSettings struct {
+	RAMQuota       int      `json:"ramQuota"`
+	IndexRAMQuota  int      `json:"indexRamQuota"`
+	BucketRAMQuota int      `json:"bucketRamQuota"`
+	Instances      int      `json:"instances"`
+	ResourcePool   string   `json:"resourcePool"`
+	Services       []string `json:"services"`
+
+	// Topology, if given, replaces Instances and Services with groups of
+	// nodes that each run their own set of services.
+	Topology []NodeGroup `json:"topology"`
+
+	// Bucket holds the plan defaults for the service bucket.
+	Bucket *BucketSettings `json:"bucket"`
+
+	// Indexes are created on the service bucket once it exists.
+	Indexes []IndexDefinition `json:"indexes"`
+
+	// SampleData lets provision requests load sample buckets and seed data.
+	SampleData bool `json:"sampleData"`
+
+	// Cluster holds cluster-wide settings, applied once the nodes have joined.
+	Cluster *ClusterSettings `json:"cluster"`
+
+	// MinVersion is the oldest Couchbase release (e.g. "5.0") the plan
+	// supports.  Provisioning fails on older nodes.
+	MinVersion string `json:"minVersion"`
+
+	// TLS decides whether the instance's endpoints use certificates issued
+	// by the broker.
+	TLS *TLSSettings `json:"tls"`
+
+	// CloudConfig, if set, makes the broker deploy a BOSH v2 manifest whose
+	// instance groups use these names from the director's cloud-config,
+	// instead of the resource pools and networks of the v1 templates.
+	CloudConfig *CloudConfigSettings `json:"cloudConfig"`
+
+	// TemplateProfile names the template profile (see the template_profiles
+	// of the BOSH config) the manifests of the plan are built from, instead
+	// of the broker's.
+	TemplateProfile string `json:"templateProfile"`
+
+	// Errands are run, in order, once the deployment of a new instance is
+	// done.  Provisioning fails if one exits non-zero.
+	Errands []ErrandSettings `json:"errands"`
+}
+
+// ErrandSettings name a BOSH errand of the deployment.
+type ErrandSettings struct {
+	Name string `json:"name"`
+	// InitializesCluster tells the broker that the errand sets up the
+	// Couchbase cluster (node settings, admin credentials and the joining
+	// of the nodes), so that the broker no longer does it.
+	InitializesCluster bool `json:"initializesCluster"`
+}
+
+// CloudConfigSettings name the cloud-config entries the instance groups of a
+// v2 manifest use.  The director must define them all.
+type CloudConfigSettings struct {
+	VMType             string   `json:"vmType"`
+	Network            string   `json:"network"`
+	PersistentDiskType string   `json:"persistentDiskType"`
+	AZs                []string `json:"azs"`
+	// StemcellOS and StemcellVersion select the stemcell of the instance
+	// groups (default ubuntu-trusty, latest).
+	StemcellOS      string `json:"stemcellOS"`
+	StemcellVersion string `json:"stemcellVersion"`
+}
+
+// TLSSettings configure the certificates and encryption of an instance.
+type TLSSettings struct {
+	// Mode is "off" (the default), "optional" (the tls provision parameter
+	// turns TLS on) or "required".
+	Mode string `json:"mode"`
+	// EncryptionLevel, if set, encrypts the traffic between the nodes:
+	// "control" for cluster management only, "all" for data too.
+	EncryptionLevel string `json:"encryptionLevel"`
+}
+
+// An IndexDefinition is a N1QL index on the service bucket: either the
+// primary index, or a secondary index over Fields (N1QL expressions).
+type IndexDefinition struct {
+	Name    string   `json:"name"`
+	Primary bool     `json:"primary"`
+	Fields  []string `json:"fields"`
+	Where   string   `json:"where"`
+}
+
+// BucketSettings configure the service bucket.  Plans set defaults, and the
+// provision parameters can override them.  Nil and empty values are left to
+// the plan, then to Couchbase.
+type BucketSettings struct {
+	Name                   string `json:"name"`
+	BucketType             string `json:"bucketType"`
+	ReplicaNumber          *int   `json:"replicaNumber"`
+	EvictionPolicy         string `json:"evictionPolicy"`
+	CompressionMode        string `json:"compressionMode"`
+	MaxTTL                 *int   `json:"maxTTL"`
+	ConflictResolutionType string `json:"conflictResolutionType"`
+	FlushEnabled           *bool  `json:"flushEnabled"`
+
+	// Scope and Collections are created in the bucket (Couchbase 7.0 and
+	// later).
+	Scope       string   `json:"scope"`
+	Collections []string `json:"collections"`
+}
+
+// ClusterSettings configure the cluster as a whole.  Nil and empty values
+// are left to Couchbase.
+type ClusterSettings struct {
+	// AutoFailoverTimeout is how many seconds a node may be down before it
+	// is failed over automatically.  0 disables auto-failover.
+	AutoFailoverTimeout *int `json:"autoFailoverTimeout"`
+	// ServerGroups places the nodes in server groups for rack awareness:
+	// "az" makes a group per BOSH availability zone, "index" spreads the
+	// nodes over ServerGroupCount groups by VM index.
+	ServerGroups     string `json:"serverGroups"`
+	ServerGroupCount int    `json:"serverGroupCount"`
+	// IndexStorageMode is forestdb, memory_optimized or plasma.
+	IndexStorageMode string `json:"indexStorageMode"`
+	// Query and XDCR are passed on to Couchbase as the query service
+	// settings and the default replication settings.
+	Query map[string]interface{} `json:"query"`
+	XDCR  map[string]interface{} `json:"xdcr"`
+}
+
+// A NodeGroup is a set of identical nodes in a multi-dimensional scaling
+// topology, e.g. 3 nodes running only the data service.
+type NodeGroup struct {
+	Name      string   `json:"name"`
+	Services  []string `json:"services"`
+	Instances int      `json:"instances"`
+
+	// VMType and PersistentDiskType override those of the plan's
+	// cloudConfig for the group.
+	VMType             string `json:"vmType"`
+	PersistentDiskType string `json:"persistentDiskType"`
+}
+
+// Settings decodes the Couchbase settings from the plan metadata.
+func (p *ServicePlan) Settings() (*PlanSettings, error) {
+	var settings PlanSettings
+	if p.Metadata == nil {
+		return &settings, nil
+	}
+	bytes, err := json.Marshal(p.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &settings)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
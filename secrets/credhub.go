@@ -0,0 +1,200 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	model "github.com/ssdowd/couchbasebroker/model"
+	uaa "github.com/ssdowd/couchbasebroker/uaa"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// A CredHubStore is a SecretStore backed by the CredHub data API.
+type CredHubStore struct {
+	baseURL           string
+	uaaURL            string
+	clientCredentials func() (string, string)
+	httpClient        *http.Client
+
+	mutex sync.Mutex
+	// tokens gets the tokens of the client and secret it was created
+	// with; it is replaced when they are reloaded.
+	tokens       *uaa.TokenSource
+	clientID     string
+	clientSecret string
+}
+
+type credHubSetRequest struct {
+	Name  string           `json:"name"`
+	Type  string           `json:"type"`
+	Value model.Credential `json:"value"`
+}
+
+type credHubCredential struct {
+	ID    string           `json:"id"`
+	Name  string           `json:"name"`
+	Type  string           `json:"type"`
+	Value model.Credential `json:"value"`
+}
+
+type credHubDataResponse struct {
+	Data []credHubCredential `json:"data"`
+}
+
+// DefaultTimeout bounds each request to CredHub and its UAA.
+const DefaultTimeout = 30 * time.Second
+
+// NewHTTPClient returns a client for CredHub and its UAA, trusting the PEM
+// encoded CA caPEM if it is not empty, the system roots otherwise.
+func NewHTTPClient(caPEM []byte) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if len(caPEM) > 0 {
+		pool, err := ca.CertPool(caPEM)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: DefaultTimeout}, nil
+}
+
+// NewCredHubStore returns a CredHubStore talking to the CredHub server at
+// credHubURL, authenticating with a client credentials token from uaaURL.
+// clientCredentials returns the current UAA client and secret, so that a
+// reloaded secret is used for the next token.  httpClient defaults to one
+// trusting the system roots (see NewHTTPClient).
+func NewCredHubStore(credHubURL, uaaURL string, clientCredentials func() (string, string), httpClient *http.Client) *CredHubStore {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &CredHubStore{
+		baseURL:           strings.TrimRight(credHubURL, "/"),
+		uaaURL:            uaaURL,
+		clientCredentials: clientCredentials,
+		httpClient:        httpClient,
+	}
+}
+
+// tokenSource returns the source of the CredHub tokens, created for the
+// current client credentials.
+func (s *CredHubStore) tokenSource() *uaa.TokenSource {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	clientID, clientSecret := s.clientCredentials()
+	if s.tokens == nil || clientID != s.clientID || clientSecret != s.clientSecret {
+		s.tokens = uaa.NewTokenSource(s.uaaURL, clientID, clientSecret, s.httpClient)
+		s.clientID = clientID
+		s.clientSecret = clientSecret
+	}
+	return s.tokens
+}
+
+// Put stores the credential as a CredHub json credential and returns its name
+// for use as a credhub-ref.
+func (s *CredHubStore) Put(name string, credential *model.Credential) (string, error) {
+	body, err := json.Marshal(credHubSetRequest{
+		Name:  name,
+		Type:  "json",
+		Value: *credential,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var stored credHubCredential
+	err = s.do("PUT", "/api/v1/data", body, &stored)
+	if err != nil {
+		utils.Logger.Printf("secrets.credhub.Put: error storing %v: %v\n", name, err)
+		return "", err
+	}
+	return stored.Name, nil
+}
+
+// Get returns the current value of the named credential.
+func (s *CredHubStore) Get(name string) (*model.Credential, error) {
+	var data credHubDataResponse
+	err := s.do("GET", "/api/v1/data?current=true&name="+url.QueryEscape(name), nil, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Data) == 0 {
+		return nil, fmt.Errorf("credential %v not found in CredHub", name)
+	}
+	return &data.Data[0].Value, nil
+}
+
+// Delete removes all versions of the named credential.  Deleting a credential
+// that does not exist is not an error.
+func (s *CredHubStore) Delete(name string) error {
+	err := s.do("DELETE", "/api/v1/data?name="+url.QueryEscape(name), nil, nil)
+	if err, ok := err.(*CredHubError); ok && err.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// A CredHubError is returned when CredHub responds with an unexpected status.
+type CredHubError struct {
+	StatusCode  int
+	Description string
+}
+
+func (e *CredHubError) Error() string {
+	return fmt.Sprintf("CredHub error %d: %s", e.StatusCode, e.Description)
+}
+
+func (s *CredHubStore) do(method, path string, body []byte, result interface{}) error {
+	tokens := s.tokenSource()
+	token, err := tokens.Token()
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(method, s.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusUnauthorized:
+		tokens.Invalidate()
+		fallthrough
+	case response.StatusCode >= 300:
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &e)
+		if e.Error == "" {
+			e.Error = response.Status
+		}
+		return &CredHubError{StatusCode: response.StatusCode, Description: e.Error}
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
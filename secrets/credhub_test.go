@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+// fakeCredHub is a minimal in-memory CredHub (and UAA token endpoint).
+type fakeCredHub struct {
+	mutex       sync.Mutex
+	store       map[string]model.Credential
+	tokenIssues int
+}
+
+func (f *fakeCredHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.URL.Path == "/oauth/token" {
+		user, pass, _ := r.BasicAuth()
+		if user != "broker" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.tokenIssues++
+		w.Write([]byte(`{"access_token":"tok","token_type":"bearer","expires_in":3600}`))
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	switch r.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		var req credHubSetRequest
+		json.Unmarshal(body, &req)
+		f.store[req.Name] = req.Value
+		json.NewEncoder(w).Encode(credHubCredential{ID: "1", Name: req.Name, Type: req.Type, Value: req.Value})
+	case "GET":
+		cred, ok := f.store[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"The request could not be completed because the credential does not exist"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(credHubDataResponse{Data: []credHubCredential{{Name: name, Type: "json", Value: cred}}})
+	case "DELETE":
+		if _, ok := f.store[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.store, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestCredHubStoreRoundTrip(t *testing.T) {
+	fake := &fakeCredHub{store: make(map[string]model.Credential)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := NewCredHubStore(server.URL, server.URL, clientCredentials("broker", "secret"), nil)
+	name := BindingSecretName("couchbasebroker", "svc-1", "binding-1")
+	cred := &model.Credential{
+		URI:        "http://10.244.1.2:8091",
+		UserName:   "user",
+		Password:   "pass",
+		BucketName: "cfdefault",
+	}
+
+	ref, err := store.Put(name, cred)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if ref != name {
+		t.Errorf("Put returned ref %q, expected %q", ref, name)
+	}
+
+	got, err := store.Get(name)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, cred) {
+		t.Errorf("Get returned %v, expected %v", *got, *cred)
+	}
+
+	err = store.Delete(name)
+	if err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err = store.Get(name); err == nil {
+		t.Errorf("Get after Delete should fail")
+	}
+	// deleting again is not an error
+	if err = store.Delete(name); err != nil {
+		t.Errorf("second Delete failed: %v", err)
+	}
+	if fake.tokenIssues != 1 {
+		t.Errorf("expected the UAA token to be cached, fetched %d times", fake.tokenIssues)
+	}
+}
+
+func TestCredHubStoreBadClientCredentials(t *testing.T) {
+	server := httptest.NewServer(&fakeCredHub{store: make(map[string]model.Credential)})
+	defer server.Close()
+
+	store := NewCredHubStore(server.URL, server.URL, clientCredentials("broker", "wrong"), nil)
+	_, err := store.Put("/c/x", &model.Credential{})
+	if err == nil {
+		t.Errorf("Put with bad client credentials should fail")
+	}
+}
+
+func TestCredHubStoreCA(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeCredHub{store: make(map[string]model.Credential)})
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	httpClient, err := NewHTTPClient(caPEM)
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	if httpClient.Timeout != DefaultTimeout {
+		t.Errorf("requests time out after %v", httpClient.Timeout)
+	}
+	store := NewCredHubStore(server.URL, server.URL, clientCredentials("broker", "secret"), httpClient)
+	if _, err = store.Put("/c/x", &model.Credential{}); err != nil {
+		t.Errorf("Put to a CredHub signed by the configured CA failed: %v", err)
+	}
+
+	// the system roots do not know the CA of the test server
+	httpClient, _ = NewHTTPClient(nil)
+	store = NewCredHubStore(server.URL, server.URL, clientCredentials("broker", "secret"), httpClient)
+	if _, err = store.Put("/c/x", &model.Credential{}); err == nil {
+		t.Errorf("Put to a CredHub signed by an unknown CA should fail")
+	}
+
+	if _, err = NewHTTPClient([]byte("not a certificate")); err == nil {
+		t.Errorf("NewHTTPClient of an invalid CA should fail")
+	}
+}
+
+func clientCredentials(clientID, clientSecret string) func() (string, string) {
+	return func() (string, string) {
+		return clientID, clientSecret
+	}
+}
+
+func TestCredHubStoreSecretRotation(t *testing.T) {
+	fake := &fakeCredHub{store: make(map[string]model.Credential)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var mutex sync.Mutex
+	secret := "old"
+	store := NewCredHubStore(server.URL, server.URL, func() (string, string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return "broker", secret
+	}, nil)
+	if _, err := store.Put("/c/x", &model.Credential{}); err == nil {
+		t.Fatalf("Put with the old secret should fail")
+	}
+
+	// the secret is rotated, e.g. by a SIGHUP reload
+	mutex.Lock()
+	secret = "secret"
+	mutex.Unlock()
+	if _, err := store.Put("/c/x", &model.Credential{}); err != nil {
+		t.Fatalf("Put after the secret was rotated: %v", err)
+	}
+	if _, err := store.Get("/c/x"); err != nil || fake.tokenIssues != 1 {
+		t.Errorf("Get: %v, %d tokens", err, fake.tokenIssues)
+	}
+}
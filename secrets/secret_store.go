@@ -0,0 +1,21 @@
+package secrets
+
+import (
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+// A SecretStore keeps binding credentials outside of the broker's own data
+// files.  The platform resolves the returned reference to the raw secret.
+type SecretStore interface {
+	// Put stores the credential under name and returns the reference to hand out.
+	Put(name string, credential *model.Credential) (string, error)
+	// Get returns the credential currently stored under name.
+	Get(name string) (*model.Credential, error)
+	// Delete removes the credential stored under name.
+	Delete(name string) error
+}
+
+// BindingSecretName returns the name under which the credentials for a binding are stored.
+func BindingSecretName(brokerName, serviceID, bindingID string) string {
+	return "/c/" + brokerName + "/" + serviceID + "/" + bindingID + "/credentials"
+}
@@ -0,0 +1,105 @@
+package uaa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// refreshMargin is how long before expiry a cached token is considered stale.
+const refreshMargin = 30 * time.Second
+
+// A TokenSource fetches OAuth2 access tokens from a UAA server using the
+// client credentials grant, caching each token until shortly before it expires.
+type TokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// NewTokenSource returns a TokenSource for the UAA at uaaURL.  If httpClient is
+// nil, http.DefaultClient is used.
+func NewTokenSource(uaaURL, clientID, clientSecret string, httpClient *http.Client) *TokenSource {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &TokenSource{
+		tokenURL:     strings.TrimRight(uaaURL, "/") + "/oauth/token",
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		httpClient:   httpClient,
+	}
+}
+
+// Token returns a valid access token, requesting a new one if the cached token
+// is missing or about to expire.
+func (ts *TokenSource) Token() (string, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if ts.token != "" && time.Now().Add(refreshMargin).Before(ts.expires) {
+		return ts.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	request, err := http.NewRequest("POST", ts.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.SetBasicAuth(ts.clientID, ts.clientSecret)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := ts.httpClient.Do(request)
+	if err != nil {
+		utils.Logger.Printf("uaa.Token: error requesting token from %v: %v\n", ts.tokenURL, err)
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("UAA token request failed: %s: %s", response.Status, body)
+	}
+
+	var tr tokenResponse
+	err = json.Unmarshal(body, &tr)
+	if err != nil {
+		return "", err
+	}
+	if tr.AccessToken == "" {
+		return "", fmt.Errorf("UAA token response from %v has no access_token", ts.tokenURL)
+	}
+
+	ts.token = tr.AccessToken
+	ts.expires = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// Invalidate discards the cached token so the next call to Token fetches a new one.
+func (ts *TokenSource) Invalidate() {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.token = ""
+}
@@ -0,0 +1,122 @@
+package web_server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ssdowd/couchbasebroker/ca"
+	client "github.com/ssdowd/couchbasebroker/client"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// RotateCertificate implements POST /admin/service_instances/:id/certificate,
+// giving a TLS instance a new certificate now rather than when the old one
+// nears its expiry.
+func (c *Controller) RotateCertificate(w http.ResponseWriter, r *http.Request) {
+	instanceID := utils.ExtractVarsFromRequest(r, "service_instance_guid")
+	instance := c.instance(instanceID)
+	if instance == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.mutex.RLock()
+	tls := instance.Credential.CACertificate != ""
+	c.mutex.RUnlock()
+	if !tls {
+		utils.WriteResponse(w, http.StatusBadRequest, model.Message{Description: "the instance does not use TLS"})
+		return
+	}
+	setup, err := c.startCertificateRotation(instance)
+	if err != nil {
+		utils.Logger.Printf("controller.RotateCertificate: error saving instance map: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if setup == nil {
+		utils.WriteResponse(w, http.StatusUnprocessableEntity, model.Message{Description: "the instance is being set up or updated"})
+		return
+	}
+	utils.WriteResponse(w, http.StatusAccepted, model.Message{Description: setup.Description})
+}
+
+// rotateExpiringCertificate starts the rotation of the certificate of a TLS
+// instance once the health monitor sees it close to expiry.  A failed
+// rotation is not retried: its error stays in the setup state for operators.
+func (c *Controller) rotateExpiringCertificate(instance *model.ServiceInstance, health *model.InstanceHealth) {
+	expires := health.CertificateExpires
+	if expires == nil || !ca.NeedsRotation(*expires, time.Now(), client.CertificateRenewal()) {
+		return
+	}
+	if setup := c.setupState(instance); setup != nil && setup.State == "failed" {
+		utils.Logger.Printf("controller.rotateExpiringCertificate: %v: the certificate expires %v, but the instance failed: %v\n",
+			instance.ID, expires, setup.Description)
+		return
+	}
+	utils.Logger.Printf("controller.rotateExpiringCertificate: %v: the certificate expires %v\n", instance.ID, expires)
+	_, err := c.startCertificateRotation(instance)
+	if err != nil {
+		utils.Logger.Printf("controller.rotateExpiringCertificate: error saving instance map: %v\n", err)
+	}
+}
+
+// startCertificateRotation marks the instance as being updated, unless an
+// operation is already in progress, and rotates its certificate in the
+// background.  It returns the new setup state, or nil if the instance was
+// busy.  The rotation is only started once the new state is saved.
+func (c *Controller) startCertificateRotation(instance *model.ServiceInstance) (*model.LastOperation, error) {
+	setup := &model.LastOperation{
+		State:       "in progress",
+		Description: "rotating the TLS certificate...",
+	}
+	var previous *model.LastOperation
+	busy := false
+	err := c.recordInstances(func() {
+		if instance.Setup != nil && instance.Setup.State == "in progress" {
+			busy = true
+			return
+		}
+		previous = instance.Setup
+		instance.Setup = setup
+	})
+	if busy {
+		return nil, nil
+	}
+	if err != nil {
+		c.mutex.Lock()
+		if instance.Setup == setup {
+			instance.Setup = previous
+		}
+		c.mutex.Unlock()
+		return nil, err
+	}
+	go c.rotateCertificate(instance)
+	return setup, nil
+}
+
+func (c *Controller) rotateCertificate(instance *model.ServiceInstance) {
+	plan := c.cloudClient.GetCatalog().FindPlan(instance.PlanID)
+	c.mutex.RLock()
+	parameters := instance.Parameters
+	c.mutex.RUnlock()
+	err := c.cloudClient.RotateCertificate(instance.InternalID, plan, parameters, &instance.Credential, c.setupProgress(instance))
+	setup := &model.LastOperation{
+		State:       "succeeded",
+		Description: "rotated the TLS certificate",
+	}
+	if err != nil {
+		utils.Logger.Printf("controller.rotateCertificate: %v: %v\n", instance.InternalID, err)
+		setup = &model.LastOperation{
+			State:       "failed",
+			Description: fmt.Sprintf("failed to rotate the TLS certificate: %v", err),
+		}
+	}
+
+	err = c.recordInstances(func() {
+		instance.Setup = setup
+	})
+	if err != nil {
+		utils.Logger.Printf("controller.rotateCertificate: error saving instance map: %v\n", err)
+	}
+}
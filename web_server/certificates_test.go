@@ -0,0 +1,94 @@
+package web_server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/ssdowd/couchbasebroker/client"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+// A rotatingClient hands the parameters of a rotation to rotated, waiting
+// for them to be taken.
+type rotatingClient struct {
+	client.Client
+	rotated chan interface{}
+}
+
+func (rotatingClient) GetCatalog() *model.Catalog {
+	return &model.Catalog{}
+}
+
+func (f rotatingClient) RotateCertificate(instanceID string, plan *model.ServicePlan, parameters interface{}, credential *model.Credential, progress client.ProgressFunc) error {
+	f.rotated <- parameters
+	return nil
+}
+
+func TestRotateCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dataPath, fileName string) {
+		conf.DataPath, conf.ServiceInstancesFileName = dataPath, fileName
+	}(conf.DataPath, conf.ServiceInstancesFileName)
+	// the instance map cannot be saved under a file
+	conf.DataPath, conf.ServiceInstancesFileName = filepath.Join(dir, "file", "data"), "instances.json"
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &model.ServiceInstance{
+		ID:         "instance-1",
+		Parameters: map[string]interface{}{"tls": map[string]interface{}{}},
+		Credential: model.Credential{URI: "https://10.244.1.2:18091", CACertificate: "CA"},
+		Setup:      &model.LastOperation{State: "in progress", Description: "updating service instance..."},
+	}
+	cloudClient := rotatingClient{rotated: make(chan interface{})}
+	c := &Controller{
+		cloudClient: cloudClient,
+		instanceMap: map[string]*model.ServiceInstance{instance.ID: instance},
+		bindingMap:  make(map[string]*model.ServiceBinding),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/service_instances/{service_instance_guid}/certificate", c.RotateCertificate)
+	rotate := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/service_instances/instance-1/certificate", nil))
+		return w.Code
+	}
+
+	if code := rotate(); code != http.StatusUnprocessableEntity || instance.Setup.Description != "updating service instance..." {
+		t.Errorf("rotation of a busy instance: %d, setup %+v", code, instance.Setup)
+	}
+
+	instance.Setup = &model.LastOperation{State: "succeeded"}
+	if code := rotate(); code != http.StatusInternalServerError || instance.Setup.State != "succeeded" {
+		t.Errorf("rotation that could not be saved: %d, setup %+v", code, instance.Setup)
+	}
+
+	conf.DataPath = dir
+	if code := rotate(); code != http.StatusAccepted {
+		t.Errorf("rotation: %d", code)
+	}
+	saved, err := ioutil.ReadFile(filepath.Join(dir, "instances.json"))
+	if err != nil || !strings.Contains(string(saved), "rotating the TLS certificate") {
+		t.Errorf("rotation not saved: %s %v", saved, err)
+	}
+	parameters := <-cloudClient.rotated
+	if _, ok := parameters.(map[string]interface{})["tls"]; !ok {
+		t.Errorf("rotation without the parameters of the instance: %v", parameters)
+	}
+	for c.setupState(instance).State == "in progress" {
+		time.Sleep(time.Millisecond)
+	}
+}
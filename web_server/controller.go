@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httputil"
+	"strings"
+	"sync"
 	"time"
 
 	client "github.com/ssdowd/couchbasebroker/client"
 	model "github.com/ssdowd/couchbasebroker/model"
+	secrets "github.com/ssdowd/couchbasebroker/secrets"
 	utils "github.com/ssdowd/couchbasebroker/utils"
 )
 
@@ -16,11 +19,34 @@ const (
 	defaultPollingIntervalSeconds = 10
 )
 
+// setupInstance polls GetCredentials, first after setupPollInterval, then
+// at intervals that double until they pass ten times that.  It gives up
+// after maxSetupWait of deploying and configuring the instance, not counting
+// the time a deploy waits in the queue of the director, up to maxQueueWait.
+var (
+	setupPollInterval = time.Second
+	maxSetupWait      = time.Hour
+	maxQueueWait      = 24 * time.Hour
+)
+
+// defaultBrokerName prefixes the names of the secrets of the bindings unless
+// broker_name is configured.
+const defaultBrokerName = "couchbasebroker"
+
 // A Controller holds the instance and binding maps for a given cloud and its client.
 type Controller struct {
 	cloudName   string
 	cloudClient client.Client
-
+	secretStore secrets.SecretStore
+	// brokerName prefixes the names of the secrets of the bindings.
+	brokerName string
+
+	// mutex guards the maps and the state the controller keeps in the
+	// instances (setup, last operation, health, replication): they are
+	// changed and saved holding it.  The credentials the cloud client updates
+	// in place are not guarded; the health monitor skips the instances being
+	// set up or updated.
+	mutex       sync.RWMutex
 	instanceMap map[string]*model.ServiceInstance
 	bindingMap  map[string]*model.ServiceBinding
 }
@@ -32,9 +58,20 @@ func CreateController(cloudName string, cloudOptionsFile string, instanceMap map
 		return nil, fmt.Errorf("controller.CreateController: Could not create cloud: %s client, message: %s", cloudName, err.Error())
 	}
 
+	secretStore, err := createSecretStore()
+	if err != nil {
+		return nil, fmt.Errorf("controller.CreateController: Could not create the CredHub client: %v", err)
+	}
+	brokerName := conf.BrokerName
+	if brokerName == "" {
+		brokerName = defaultBrokerName
+	}
+
 	controller := &Controller{
 		cloudName:   cloudName,
 		cloudClient: cloudClient,
+		secretStore: secretStore,
+		brokerName:  brokerName,
 
 		instanceMap: instanceMap,
 		bindingMap:  bindingMap,
@@ -81,7 +118,11 @@ func (c *Controller) loadCatalog() error {
 		return err
 	}
 
-	c.cloudClient.SetCatalog(&catalog)
+	err = c.cloudClient.SetCatalog(&catalog)
+	if err != nil {
+		utils.Logger.Printf("controller.Catalog: invalid catalog %v/%v: %v\n", conf.CatalogPath, catalogFileName, err)
+		return err
+	}
 	return nil
 }
 
@@ -107,12 +148,27 @@ func (c *Controller) CreateServiceInstance(w http.ResponseWriter, r *http.Reques
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	plan := c.cloudClient.GetCatalog().FindPlan(instance.PlanID)
 
-	// TODO: need to pass the plan here as well??  instance.Parameters are user-passed parms
-	instanceID, err := c.cloudClient.CreateInstance(instance.Parameters)
+	status := http.StatusBadRequest
+	params, err := model.DecodeParameters(instance.Parameters)
+	if err == nil {
+		err = c.cloudClient.ValidateParameters(plan, instance.Parameters)
+	}
+	if err == nil && params.ReplicateFrom != "" {
+		status, err = c.checkReplicationSource(&instance, params.ReplicateFrom)
+	}
+	if err != nil {
+		utils.Logger.Printf("controller.CreateServiceInstance %v - invalid parameters: %v\n", instanceGUID, err)
+		utils.WriteResponse(w, status, model.Message{Description: err.Error()})
+		return
+	}
+
+	// instance.Parameters are user-passed parms
+	instanceID, err := c.cloudClient.CreateInstance(plan, instance.Parameters, instance.OrganizationGUID)
 	if err != nil {
 		utils.Logger.Printf("controller.CreateServiceInstance: cloudClient.CreateInstance returned: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		utils.WriteResponse(w, http.StatusInternalServerError, model.Message{Description: err.Error()})
 		return
 	}
 	// Here we have the ID of the Docker container in instanceID
@@ -125,9 +181,14 @@ func (c *Controller) CreateServiceInstance(w http.ResponseWriter, r *http.Reques
 		Description:              "creating service instance...",
 		AsyncPollIntervalSeconds: defaultPollingIntervalSeconds,
 	}
+	instance.Setup = &model.LastOperation{
+		State:       "in progress",
+		Description: "waiting for the service instance to start...",
+	}
 
-	c.instanceMap[instance.ID] = &instance
-	err = utils.MarshalAndRecord(c.instanceMap, conf.DataPath, conf.ServiceInstancesFileName)
+	err = c.recordInstances(func() {
+		c.instanceMap[instance.ID] = &instance
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		utils.Logger.Printf("controller.CreateServiceInstance: error saving instance map: %v\n", err)
@@ -137,7 +198,7 @@ func (c *Controller) CreateServiceInstance(w http.ResponseWriter, r *http.Reques
 	//=============================================================================================
 	// Now set it up for client access - asynch
 	// TODO: uncomment this...
-	go c.setupInstance(instance.ID, instance.InternalID)
+	go c.setupInstance(instance.ID, instance.InternalID, plan, instance.Parameters)
 	//=============================================================================================
 
 	response := model.CreateServiceInstanceResponse{
@@ -156,7 +217,7 @@ func (c *Controller) GetServiceInstance(w http.ResponseWriter, r *http.Request)
 	instanceID := utils.ExtractVarsFromRequest(r, "service_instance_guid")
 	utils.Logger.Printf("controller.GetServiceInstance %v\n", instanceID)
 	utils.Logger.Printf("controller.GetServiceInstance REQUEST:\n%s\n\n", dumpRequest(r))
-	instance := c.instanceMap[instanceID]
+	instance := c.instance(instanceID)
 	if instance == nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -169,32 +230,67 @@ func (c *Controller) GetServiceInstance(w http.ResponseWriter, r *http.Request)
 	}
 	utils.Logger.Printf("controller.GetServiceInstance: state: %v\n", state)
 
+	// once the instance is up, it is ready when its setup is done
+	c.mutex.RLock()
+	setup := instance.Setup
+	operation := *instance.LastOperation
+	replication, health, dashboardURL := instance.Replication, instance.Health, instance.DashboardURL
+	c.mutex.RUnlock()
+	if setup != nil && (state == "running" || state == "succeeded") {
+		state = setup.State
+	}
+	if setup != nil && setup.State == "failed" {
+		state = "failed"
+	}
+
 	switch state {
 	case "pending":
-		instance.LastOperation.State = "in progress"
-		instance.LastOperation.Description = "creating service instance..."
+		operation.State = "in progress"
+		operation.Description = "creating service instance..."
+		if description := c.cloudClient.GetInstanceDescription(instance.InternalID); description != "" {
+			operation.Description = "creating service instance: " + description
+		}
 	case "running":
-		instance.LastOperation.State = "in progress"
-		instance.LastOperation.Description = "creating service instance..."
+		operation.State = "in progress"
+		operation.Description = "creating service instance..."
+	case "in progress":
+		operation.State = "in progress"
+		operation.Description = setup.Description
 	case "succeeded":
-		instance.LastOperation.State = "succeeded"
-		instance.LastOperation.Description = "successfully created service instance"
-		instance.LastOperation.DashboardURL = instance.DashboardURL
-		instance.LastOperation.AsyncPollIntervalSeconds = 0
+		operation.State = "succeeded"
+		operation.Description = "successfully created service instance"
+		if setup != nil {
+			operation.Description = setup.Description
+		}
+		if replication != nil {
+			operation.Description += replicationDescription(replication)
+		}
+		if health != nil && health.Status != model.HealthHealthy {
+			operation.Description += fmt.Sprintf("; cluster %v: %v", health.Status, health.Summary)
+		}
+		operation.DashboardURL = dashboardURL
+		operation.AsyncPollIntervalSeconds = 0
 	case "failed":
-		instance.LastOperation.State = "failed"
-		instance.LastOperation.Description = "failed to create service instance"
+		operation.State = "failed"
+		operation.Description = "failed to create service instance"
+		if setup != nil && setup.State == "failed" {
+			operation.Description = setup.Description
+		} else if description := c.cloudClient.GetInstanceDescription(instance.InternalID); description != "" {
+			operation.Description = "failed to create service instance: " + description
+		}
 	default:
-		instance.LastOperation.State = "failed"
-		instance.LastOperation.Description = "unknown state"
+		operation.State = "failed"
+		operation.Description = "unknown state"
 	}
 
 	// response := model.CreateServiceInstanceResponse{
 	//   DashboardUrl:  instance.DashboardUrl,
 	//   LastOperation: instance.LastOperation,
 	// }
-	response := instance.LastOperation
-	err = utils.MarshalAndRecord(c.instanceMap, conf.DataPath, conf.ServiceInstancesFileName)
+	response := &operation
+	err = c.recordInstances(func() {
+		instance.LastOperation = response
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		utils.Logger.Printf("controller.CreateServiceInstance: error saving instance map: %v\n", err)
@@ -202,27 +298,98 @@ func (c *Controller) GetServiceInstance(w http.ResponseWriter, r *http.Request)
 	utils.WriteResponse(w, http.StatusOK, response)
 }
 
+// UpdateServiceInstance implements PATCH /v2/service_instances/:id endpoint,
+// changing the parameters (for now, the number of nodes) of a service instance.
+func (c *Controller) UpdateServiceInstance(w http.ResponseWriter, r *http.Request) {
+	instanceGUID := utils.ExtractVarsFromRequest(r, "service_instance_guid")
+	utils.Logger.Printf("controller.UpdateServiceInstance %v\n", instanceGUID)
+	utils.Logger.Printf("controller.UpdateServiceInstance REQUEST:\n%s\n\n", dumpRequest(r))
+
+	instance := c.instance(instanceGUID)
+	if instance == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var request model.UpdateServiceInstanceRequest
+	err := utils.ProvisionDataFromRequest(r, &request)
+	if err != nil {
+		utils.Logger.Printf("controller.UpdateServiceInstance %v - error: %v\n", instanceGUID, err)
+		utils.WriteResponse(w, http.StatusBadRequest, model.Message{Description: err.Error()})
+		return
+	}
+	if request.PlanID != "" && request.PlanID != instance.PlanID {
+		utils.WriteResponse(w, http.StatusUnprocessableEntity, model.Message{
+			Description: "changing the plan of a service instance is not supported",
+		})
+		return
+	}
+	if setup := c.setupState(instance); setup != nil && setup.State == "in progress" {
+		utils.WriteResponse(w, http.StatusUnprocessableEntity, model.Message{
+			Error:       "ConcurrencyError",
+			Description: "another operation for this service instance is in progress",
+		})
+		return
+	}
+	plan := c.cloudClient.GetCatalog().FindPlan(instance.PlanID)
+	err = c.cloudClient.ValidateUpdate(plan, request.Parameters)
+	if err != nil {
+		utils.Logger.Printf("controller.UpdateServiceInstance %v - invalid parameters: %v\n", instanceGUID, err)
+		utils.WriteResponse(w, http.StatusBadRequest, model.Message{Description: err.Error()})
+		return
+	}
+
+	operation := &model.LastOperation{
+		State:                    "in progress",
+		Description:              "updating service instance...",
+		AsyncPollIntervalSeconds: defaultPollingIntervalSeconds,
+	}
+	err = c.recordInstances(func() {
+		instance.LastOperation = operation
+		instance.Setup = &model.LastOperation{
+			State:       "in progress",
+			Description: "updating service instance...",
+		}
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		utils.Logger.Printf("controller.UpdateServiceInstance: error saving instance map: %v\n", err)
+		return
+	}
+
+	go c.updateInstance(instance, plan, request.Parameters)
+
+	response := model.CreateServiceInstanceResponse{
+		DashboardURL:  instance.DashboardURL,
+		LastOperation: operation,
+	}
+	utils.Logger.Printf("controller.UpdateServiceInstance OK\n")
+	utils.WriteResponse(w, http.StatusAccepted, response)
+}
+
 // RemoveServiceInstance implements DELETE /v2/service_instances/:id endpoint, create a service instance from the given request.
 func (c *Controller) RemoveServiceInstance(w http.ResponseWriter, r *http.Request) {
 	utils.Logger.Println("controller.RemoveServiceInstance...")
 	utils.Logger.Printf("controller.RemoveServiceInstance REQUEST:\n%s\n\n", dumpRequest(r))
 
 	instanceID := utils.ExtractVarsFromRequest(r, "service_instance_guid")
-	instance := c.instanceMap[instanceID]
+	instance := c.instance(instanceID)
 	if instance == nil {
 		w.WriteHeader(http.StatusGone)
 		return
 	}
 
-	err := c.cloudClient.DeleteInstance(instance.InternalID)
+	c.removeReplications(instance)
+	err := c.cloudClient.DeleteInstance(instance.InternalID, instance.OrganizationGUID)
 	if err != nil {
 		utils.Logger.Printf("controller.RemoveServiceInstance: %v error: %v\n", instanceID, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	delete(c.instanceMap, instanceID)
-	utils.MarshalAndRecord(c.instanceMap, conf.DataPath, conf.ServiceInstancesFileName)
+	err = c.recordInstances(func() {
+		delete(c.instanceMap, instanceID)
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -247,73 +414,63 @@ func (c *Controller) Bind(w http.ResponseWriter, r *http.Request) {
 	utils.Logger.Printf("controller.Bind instanceID: %v, bindingID: %v\n", instanceID, bindingID)
 	utils.Logger.Printf("controller.Bind REQUEST:\n%s\n\n", dumpRequest(r))
 
-	instance := c.instanceMap[instanceID]
+	instance := c.instance(instanceID)
 	if instance == nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	utils.Logger.Printf("controller.Bind instance: %v\n", instance)
 
-	binding := c.bindingMap[bindingID]
+	binding := c.binding(bindingID)
 	response := model.CreateServiceBindingResponse{}
 	if binding != nil {
 		// then just return what was stored on the binding
 		utils.Logger.Printf("controller.Bind: %v found in binding map\n", bindingID)
 		response = model.CreateServiceBindingResponse{
-			Credentials: binding.Credential,
+			Credentials: bindingCredentials(binding),
 		}
 	} else {
-		if instance != nil {
-			response = model.CreateServiceBindingResponse{
-				Credentials: instance.Credential,
-			}
-			// put into the binding table too...
-			c.bindingMap[bindingID] = &model.ServiceBinding{
-				ID:                bindingID,
-				ServiceID:         instance.ServiceID,
-				ServicePlanID:     instance.PlanID,
-				ServiceInstanceID: instance.ID,
-				Credential:        instance.Credential,
-				// Credential: model.Credential{
-				//   URI:          instance.Credential.URI,
-				//   UserName:     instance.Credential.UserName,
-				//   Password:     instance.Credential.Password,
-				//   SASLPassword: instance.Credential.SASLPassword,
-				//   BucketName:   instance.Credential.BucketName,
-				// },
-			}
-			err := utils.MarshalAndRecord(c.bindingMap, conf.DataPath, conf.ServiceBindingsFileName)
+		binding = &model.ServiceBinding{
+			ID:                bindingID,
+			ServiceID:         instance.ServiceID,
+			ServicePlanID:     instance.PlanID,
+			ServiceInstanceID: instance.ID,
+		}
+		if c.secretStore != nil {
+			// keep the raw credentials out of the binding table, hand out a reference instead
+			name := secrets.BindingSecretName(c.brokerName, instance.ServiceID, bindingID)
+			ref, err := c.secretStore.Put(name, &instance.Credential)
 			if err != nil {
+				utils.Logger.Printf("controller.Bind: error storing credentials for %v: %v\n", bindingID, err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-
+			binding.CredHubRef = ref
 		} else {
-			utils.Logger.Printf("controller.Bind: %v NOT found in binding map, checking instance %v\n", bindingID, instanceID)
-
-			credential, err := c.cloudClient.GetCredentials(instance.InternalID)
-			if err != nil {
-				utils.Logger.Printf("controller.Bind: error in GetCredentials: %v\n", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			response = model.CreateServiceBindingResponse{
-				Credentials: *credential,
-			}
+			binding.Credential = instance.Credential
+		}
+		response = model.CreateServiceBindingResponse{
+			Credentials: bindingCredentials(binding),
+		}
 
-			c.bindingMap[bindingID] = &model.ServiceBinding{
-				ID:                bindingID,
-				ServiceID:         instance.ServiceID,
-				ServicePlanID:     instance.PlanID,
-				ServiceInstanceID: instance.ID,
-				Credential:        *credential,
-			}
-			err = utils.MarshalAndRecord(c.bindingMap, conf.DataPath, conf.ServiceBindingsFileName)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+		// put into the binding table too...
+		err := c.recordBindings(func() {
+			c.bindingMap[bindingID] = binding
+		})
+		if err != nil {
+			utils.Logger.Printf("controller.Bind: error saving binding map: %v\n", err)
+			// forget the binding and its secret, so that the bind can be retried
+			c.mutex.Lock()
+			delete(c.bindingMap, bindingID)
+			c.mutex.Unlock()
+			if binding.CredHubRef != "" {
+				err = c.secretStore.Delete(binding.CredHubRef)
+				if err != nil {
+					utils.Logger.Printf("controller.Bind: error deleting secret %v: %v\n", binding.CredHubRef, err)
+				}
 			}
-
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 	}
 
@@ -327,7 +484,7 @@ func (c *Controller) UnBind(w http.ResponseWriter, r *http.Request) {
 	bindingID := utils.ExtractVarsFromRequest(r, "service_binding_guid")
 	instanceID := utils.ExtractVarsFromRequest(r, "service_instance_guid")
 	utils.Logger.Printf("controller.UnBind bindingID: '%v', instanceID: '%v'\n", bindingID, instanceID)
-	instance := c.instanceMap[instanceID]
+	instance := c.instance(instanceID)
 	if instance == nil {
 		utils.Logger.Printf("controller.UnBind instance not found\n")
 		//		w.WriteHeader(http.StatusGone)
@@ -342,8 +499,19 @@ func (c *Controller) UnBind(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	delete(c.bindingMap, bindingID)
-	err = utils.MarshalAndRecord(c.bindingMap, conf.DataPath, conf.ServiceBindingsFileName)
+	binding := c.binding(bindingID)
+	if binding != nil && binding.CredHubRef != "" && c.secretStore != nil {
+		err = c.secretStore.Delete(binding.CredHubRef)
+		if err != nil {
+			utils.Logger.Printf("controller.UnBind error deleting secret %v: %v\n", binding.CredHubRef, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = c.recordBindings(func() {
+		delete(c.bindingMap, bindingID)
+	})
 	if err != nil {
 		utils.Logger.Printf("controller.UnBind error deleting bindingID\n")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -356,14 +524,70 @@ func (c *Controller) UnBind(w http.ResponseWriter, r *http.Request) {
 
 // Private instance methods
 
+// instance returns the service instance with the given ID, or nil.
+func (c *Controller) instance(instanceID string) *model.ServiceInstance {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.instanceMap[instanceID]
+}
+
+// setupState returns the setup operation of instance, or nil.
+func (c *Controller) setupState(instance *model.ServiceInstance) *model.LastOperation {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return instance.Setup
+}
+
+// recordInstances applies change (if not nil) to the instances and saves
+// them, holding the mutex.
+func (c *Controller) recordInstances(change func()) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if change != nil {
+		change()
+	}
+	return utils.MarshalAndRecord(c.instanceMap, conf.DataPath, conf.ServiceInstancesFileName)
+}
+
+// binding returns the binding with the given ID, or nil.
+func (c *Controller) binding(bindingID string) *model.ServiceBinding {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.bindingMap[bindingID]
+}
+
+// recordBindings applies change to the bindings and saves them, holding the
+// mutex.
+func (c *Controller) recordBindings(change func()) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	change()
+	return utils.MarshalAndRecord(c.bindingMap, conf.DataPath, conf.ServiceBindingsFileName)
+}
+
 func (c *Controller) deleteAssociatedBindings(instanceID string) error {
-	for id, binding := range c.bindingMap {
+	var bindings []*model.ServiceBinding
+	c.mutex.RLock()
+	for _, binding := range c.bindingMap {
 		if binding.ServiceInstanceID == instanceID {
-			delete(c.bindingMap, id)
+			bindings = append(bindings, binding)
 		}
 	}
+	c.mutex.RUnlock()
 
-	return utils.MarshalAndRecord(c.bindingMap, conf.DataPath, conf.ServiceBindingsFileName)
+	for _, binding := range bindings {
+		if binding.CredHubRef != "" && c.secretStore != nil {
+			err := c.secretStore.Delete(binding.CredHubRef)
+			if err != nil {
+				utils.Logger.Printf("controller.deleteAssociatedBindings: error deleting secret %v: %v\n", binding.CredHubRef, err)
+			}
+		}
+	}
+	return c.recordBindings(func() {
+		for _, binding := range bindings {
+			delete(c.bindingMap, binding.ID)
+		}
+	})
 }
 
 // Private methods
@@ -373,47 +597,100 @@ func createCloudClient(cloudName string, cloudOptionsFile string) (client.Client
 	case utils.DOCKER:
 		return client.NewDockerClient(), nil
 	case utils.BOSH:
-		return client.NewBoshClient(cloudOptionsFile), nil
+		return client.NewBoshClient(cloudOptionsFile)
 	}
 
 	return nil, fmt.Errorf("Invalid cloud name: %s", cloudName)
 }
 
-func (c *Controller) setupInstance(instanceGUID string, instanceID string) {
+// createSecretStore returns the configured store for binding credentials, or
+// nil if credentials should be returned directly.
+func createSecretStore() (secrets.SecretStore, error) {
+	if conf.CredHubURL == "" {
+		return nil, nil
+	}
+	caPEM := []byte(conf.CredHubCACert)
+	if conf.CredHubCACert != "" && !strings.Contains(conf.CredHubCACert, "-----BEGIN") {
+		var err error
+		caPEM, err = utils.ReadFile(conf.CredHubCACert)
+		if err != nil {
+			return nil, fmt.Errorf("credhub_ca_cert: %v", err)
+		}
+	}
+	httpClient, err := secrets.NewHTTPClient(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("credhub_ca_cert: %v", err)
+	}
+	return secrets.NewCredHubStore(conf.CredHubURL, conf.CredHubUAAURL, conf.CredHubClientCredentials, httpClient), nil
+}
+
+// bindingCredentials returns what is handed to the platform for a binding:
+// either a reference into the secret store or the credentials themselves.
+func bindingCredentials(binding *model.ServiceBinding) interface{} {
+	if binding.CredHubRef != "" {
+		return map[string]string{"credhub-ref": binding.CredHubRef}
+	}
+	return binding.Credential
+}
+
+func (c *Controller) setupInstance(instanceGUID string, instanceID string, plan *model.ServicePlan, parameters interface{}) {
 	time.Sleep(100 * time.Millisecond)
-	instance := c.instanceMap[instanceGUID]
+	instance := c.instance(instanceGUID)
 	if instance == nil {
 		utils.Logger.Printf("controller.setupInstance: count not find instance: %v\n", instanceGUID)
 		return
 	}
 
-	totalWait := 0
-	interval := 1
-	maxWait := 300
+	var setupWait, queueWait time.Duration
+	interval := setupPollInterval
 	var err error
-	for totalWait < maxWait {
-		credential, err := c.cloudClient.GetCredentials(instanceID)
+	progress := c.setupProgress(instance)
+	for {
+		if setupWait >= maxSetupWait {
+			err = fmt.Errorf("not set up after %v: %v", maxSetupWait, err)
+			break
+		}
+		if queueWait >= maxQueueWait {
+			err = fmt.Errorf("still queued after %v", maxQueueWait)
+			break
+		}
+		var credential *model.Credential
+		credential, err = c.cloudClient.GetCredentials(instanceID, plan, parameters, progress)
 		if err != nil {
 			utils.Logger.Printf("controller.setupInstance: %v: %v\n", instanceID, err)
+			if client.IsFatal(err) {
+				break
+			}
 		} else {
 			utils.Logger.Printf("controller.setupInstance: %v appears to be ready: %v\n", instanceID, credential)
+			c.mutex.Lock()
 			instance.DashboardURL = credential.URI
 			instance.Credential = *credential
-			instance.LastOperation = &model.LastOperation{
-				State:                    "running",
-				Description:              "service instance ready...",
-				AsyncPollIntervalSeconds: defaultPollingIntervalSeconds,
+			c.mutex.Unlock()
+			// the instance is configured, so a replication failure is final
+			err = c.setupReplication(instance, parameters, progress)
+			if err != nil {
+				break
 			}
-			err = utils.MarshalAndRecord(c.instanceMap, conf.DataPath, conf.ServiceInstancesFileName)
+			err = c.recordInstances(func() {
+				instance.Setup = &model.LastOperation{
+					State:       "succeeded",
+					Description: "successfully created service instance",
+				}
+			})
 			if err != nil {
 				utils.Logger.Printf("controller.setupInstance: error saving instance map: %v\n", err)
 			}
 			return
 		}
-		time.Sleep(time.Duration(interval) * time.Second)
+		time.Sleep(interval)
 		// decaying interval...
-		totalWait += interval
-		if interval < 10 {
+		if client.IsQueued(err) {
+			queueWait += interval
+		} else {
+			setupWait += interval
+		}
+		if interval < 10*setupPollInterval {
 			interval = interval * 2
 		}
 	}
@@ -421,13 +698,13 @@ func (c *Controller) setupInstance(instanceGUID string, instanceID string) {
 	if err == nil {
 		err = errors.New("Unknown error")
 	}
-	instance.LastOperation = &model.LastOperation{
-		State:                    "failed",
-		Description:              fmt.Sprintf("failed to configure service instance: %v", err),
-		AsyncPollIntervalSeconds: defaultPollingIntervalSeconds,
+	setup := &model.LastOperation{
+		State:       "failed",
+		Description: fmt.Sprintf("failed to configure service instance: %v", err),
 	}
-
-	err = utils.MarshalAndRecord(c.instanceMap, conf.DataPath, conf.ServiceInstancesFileName)
+	err = c.recordInstances(func() {
+		instance.Setup = setup
+	})
 	if err != nil {
 		utils.Logger.Printf("controller.setupInstance: error saving instance map: %v\n", err)
 		return
@@ -435,6 +712,176 @@ func (c *Controller) setupInstance(instanceGUID string, instanceID string) {
 
 }
 
+func (c *Controller) updateInstance(instance *model.ServiceInstance, plan *model.ServicePlan, parameters interface{}) {
+	err := c.cloudClient.UpdateInstance(instance.InternalID, plan, parameters, &instance.Credential, c.setupProgress(instance))
+	setup := &model.LastOperation{
+		State:       "succeeded",
+		Description: "successfully updated service instance",
+	}
+	if err != nil {
+		utils.Logger.Printf("controller.updateInstance: %v: %v\n", instance.InternalID, err)
+		setup = &model.LastOperation{
+			State:       "failed",
+			Description: fmt.Sprintf("failed to update service instance: %v", err),
+		}
+	}
+	updated := err == nil
+
+	err = c.recordInstances(func() {
+		if updated {
+			instance.Parameters = mergeParameters(instance.Parameters, parameters)
+		}
+		instance.Setup = setup
+	})
+	if err != nil {
+		utils.Logger.Printf("controller.updateInstance: error saving instance map: %v\n", err)
+	}
+}
+
+// checkReplicationSource returns an error, and the status to answer it
+// with, unless sourceID names an instance whose bucket can be replicated
+// into instance.  The source must be in the same org and space, so that
+// nobody can copy the bucket of another tenant.
+func (c *Controller) checkReplicationSource(instance *model.ServiceInstance, sourceID string) (int, error) {
+	source := c.instance(sourceID)
+	if source == nil {
+		return http.StatusBadRequest, fmt.Errorf("replicate_from: unknown service instance %v", sourceID)
+	}
+	if source.OrganizationGUID != instance.OrganizationGUID || source.SpaceGUID != instance.SpaceGUID {
+		return http.StatusForbidden, fmt.Errorf("replicate_from: service instance %v is not in the space of the new instance", sourceID)
+	}
+	if setup := c.setupState(source); (setup != nil && setup.State != "succeeded") || source.Credential.URI == "" {
+		return http.StatusBadRequest, fmt.Errorf("replicate_from: service instance %v is not ready", sourceID)
+	}
+	return http.StatusOK, nil
+}
+
+// setupReplication configures XDCR into the bucket of instance, if its
+// parameters ask for it.
+func (c *Controller) setupReplication(instance *model.ServiceInstance, parameters interface{}, progress client.ProgressFunc) error {
+	params, err := model.DecodeParameters(parameters)
+	if err != nil || params.ReplicateFrom == "" {
+		return err
+	}
+	source := c.instance(params.ReplicateFrom)
+	if source == nil {
+		return fmt.Errorf("replication source %v no longer exists", params.ReplicateFrom)
+	}
+	progress(fmt.Sprintf("configuring replication from service instance %v...", source.ID))
+	replication, err := client.CreateReplication(client.ReplicationClusterName(instance.ID), &source.Credential, &instance.Credential)
+	if err != nil {
+		return fmt.Errorf("replication from %v: %v", source.ID, err)
+	}
+	replication.SourceInstanceID = source.ID
+	c.mutex.Lock()
+	instance.Replication = replication
+	c.mutex.Unlock()
+	return nil
+}
+
+// replicationDescription describes the status of a replication, as last
+// refreshed by the health monitor, for last_operation.
+func replicationDescription(replication *model.Replication) string {
+	description := fmt.Sprintf("; replication from %v: %v", replication.SourceInstanceID, replication.Status)
+	if len(replication.Errors) > 0 {
+		description += fmt.Sprintf(" (%v)", strings.Join(replication.Errors, "; "))
+	}
+	return description
+}
+
+// refreshReplication polls the source cluster for the status of the
+// replication into instance, if any.  The last known status is kept when
+// the source cannot be reached.
+func (c *Controller) refreshReplication(instance *model.ServiceInstance) {
+	c.mutex.RLock()
+	current := instance.Replication
+	c.mutex.RUnlock()
+	if current == nil {
+		return
+	}
+	source := c.instance(current.SourceInstanceID)
+	if source == nil {
+		return
+	}
+	c.mutex.RLock()
+	credential := source.Credential
+	c.mutex.RUnlock()
+	replication := *current
+	err := client.UpdateReplicationStatus(&credential, &replication)
+	if err != nil {
+		utils.Logger.Printf("controller.refreshReplication: %v: %v\n", instance.ID, err)
+		return
+	}
+	c.mutex.Lock()
+	// removeReplications may have dropped it meanwhile
+	if instance.Replication == current {
+		instance.Replication = &replication
+	}
+	c.mutex.Unlock()
+}
+
+// removeReplications tears down the replications into and out of instance,
+// before it is deleted.  Failures are logged: they must not keep the
+// instance from being deprovisioned.
+func (c *Controller) removeReplications(instance *model.ServiceInstance) {
+	for _, target := range c.instances() {
+		c.mutex.RLock()
+		replication := target.Replication
+		c.mutex.RUnlock()
+		if replication == nil || (target != instance && replication.SourceInstanceID != instance.ID) {
+			continue
+		}
+		source := c.instance(replication.SourceInstanceID)
+		if source != nil {
+			c.mutex.RLock()
+			credential := source.Credential
+			c.mutex.RUnlock()
+			err := client.RemoveReplication(&credential, replication)
+			if err != nil {
+				utils.Logger.Printf("controller.removeReplications: %v to %v: %v\n", replication.SourceInstanceID, target.ID, err)
+			}
+		}
+		c.mutex.Lock()
+		target.Replication = nil
+		c.mutex.Unlock()
+	}
+}
+
+// setupProgress returns a ProgressFunc that records the progress of setting
+// up or updating instance, for last_operation to report.
+func (c *Controller) setupProgress(instance *model.ServiceInstance) client.ProgressFunc {
+	return func(description string) {
+		err := c.recordInstances(func() {
+			instance.Setup = &model.LastOperation{
+				State:       "in progress",
+				Description: description,
+			}
+		})
+		if err != nil {
+			utils.Logger.Printf("controller.setupProgress: error saving instance map: %v\n", err)
+		}
+	}
+}
+
+// mergeParameters returns the instance parameters with those of an update
+// request laid over them.
+func mergeParameters(current, update interface{}) interface{} {
+	updateMap, ok := update.(map[string]interface{})
+	if !ok {
+		return current
+	}
+	merged := make(map[string]interface{})
+	if currentMap, ok := current.(map[string]interface{}); ok {
+		for k, v := range currentMap {
+			merged[k] = v
+		}
+	}
+	for k, v := range updateMap {
+		merged[k] = v
+	}
+	return merged
+}
+
 func dumpRequest(request *http.Request) string {
 	data, err := httputil.DumpRequest(request, true)
 	if err != nil {
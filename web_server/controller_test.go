@@ -0,0 +1,263 @@
+package web_server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/ssdowd/couchbasebroker/client"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+// A fakeClient answers GetCredentials as a BOSH deploy queued behind others
+// would; the rest of client.Client is not implemented.
+type fakeClient struct {
+	client.Client
+
+	queued int // the calls to answer while queued
+	err    error
+	calls  int
+}
+
+func (f *fakeClient) GetCredentials(instanceID string, plan *model.ServicePlan, parameters interface{}, progress client.ProgressFunc) (*model.Credential, error) {
+	f.calls++
+	if f.calls <= f.queued {
+		return nil, &client.QueuedError{Err: errors.New("deploy queued")}
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &model.Credential{URI: "http://10.244.1.2:8091"}, nil
+}
+
+func TestSetupQueuedInstance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dataPath, fileName string) {
+		conf.DataPath, conf.ServiceInstancesFileName = dataPath, fileName
+	}(conf.DataPath, conf.ServiceInstancesFileName)
+	conf.DataPath, conf.ServiceInstancesFileName = dir, "instances.json"
+	defer func(interval, setupWait, queueWait time.Duration) {
+		setupPollInterval, maxSetupWait, maxQueueWait = interval, setupWait, queueWait
+	}(setupPollInterval, maxSetupWait, maxQueueWait)
+	setupPollInterval = time.Millisecond
+
+	setup := func(cloudClient *fakeClient) *model.LastOperation {
+		instance := &model.ServiceInstance{ID: "instance-1"}
+		c := &Controller{
+			cloudClient: cloudClient,
+			instanceMap: map[string]*model.ServiceInstance{instance.ID: instance},
+			bindingMap:  make(map[string]*model.ServiceBinding),
+		}
+		c.setupInstance(instance.ID, "cb-1", &model.ServicePlan{}, nil)
+		return instance.Setup
+	}
+
+	// the time a deploy is queued does not count as setting up
+	maxSetupWait, maxQueueWait = 50*setupPollInterval, 1000*setupPollInterval
+	cloudClient := &fakeClient{queued: 30}
+	if got := setup(cloudClient); got == nil || got.State != "succeeded" || cloudClient.calls != 31 {
+		t.Errorf("setup of a queued instance: %+v after %d calls", got, cloudClient.calls)
+	}
+
+	// a failure once dequeued ends the setup at once
+	cloudClient = &fakeClient{queued: 3, err: &client.FatalError{Err: errors.New("error POSTing deployment: 500 Internal Server Error")}}
+	got := setup(cloudClient)
+	if got == nil || got.State != "failed" || got.Description != "failed to configure service instance: error POSTing deployment: 500 Internal Server Error" || cloudClient.calls != 4 {
+		t.Errorf("setup of a failed deploy: %+v after %d calls", got, cloudClient.calls)
+	}
+
+	// maxQueueWait bounds the wait in the queue
+	cloudClient = &fakeClient{queued: 10000}
+	got = setup(cloudClient)
+	if got == nil || got.State != "failed" || got.Description != "failed to configure service instance: still queued after 1s" || cloudClient.calls >= 10000 {
+		t.Errorf("setup of an instance queued for ever: %+v after %d calls", got, cloudClient.calls)
+	}
+
+	// and maxSetupWait the rest, e.g. a cluster that keeps refusing the broker
+	cloudClient = &fakeClient{err: errors.New("401 Unauthorized")}
+	got = setup(cloudClient)
+	if got == nil || got.State != "failed" || got.Description != "failed to configure service instance: not set up after 50ms: 401 Unauthorized" || cloudClient.calls > 50 {
+		t.Errorf("setup of an instance that is never ready: %+v after %d calls", got, cloudClient.calls)
+	}
+}
+
+// A fakeSecretStore keeps the secrets in memory.
+type fakeSecretStore struct {
+	secrets map[string]*model.Credential
+}
+
+func (s *fakeSecretStore) Put(name string, credential *model.Credential) (string, error) {
+	s.secrets[name] = credential
+	return name, nil
+}
+
+func (s *fakeSecretStore) Get(name string) (*model.Credential, error) {
+	return s.secrets[name], nil
+}
+
+func (s *fakeSecretStore) Delete(name string) error {
+	delete(s.secrets, name)
+	return nil
+}
+
+func TestBindSaveFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dataPath, fileName string) {
+		conf.DataPath, conf.ServiceBindingsFileName = dataPath, fileName
+	}(conf.DataPath, conf.ServiceBindingsFileName)
+	// the binding map cannot be saved under a file
+	conf.DataPath, conf.ServiceBindingsFileName = filepath.Join(dir, "file", "data"), "bindings.json"
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &model.ServiceInstance{ID: "instance-1", ServiceID: "couchbase"}
+	store := &fakeSecretStore{secrets: make(map[string]*model.Credential)}
+	c := &Controller{
+		secretStore: store,
+		brokerName:  defaultBrokerName,
+		instanceMap: map[string]*model.ServiceInstance{instance.ID: instance},
+		bindingMap:  make(map[string]*model.ServiceBinding),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{service_instance_guid}/service_bindings/{service_binding_guid}", c.Bind)
+	bind := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1", nil))
+		return w.Code
+	}
+
+	if code := bind(); code != http.StatusInternalServerError || len(store.secrets) != 0 || len(c.bindingMap) != 0 {
+		t.Errorf("bind that could not be saved: %d, secrets %v, bindings %v", code, store.secrets, c.bindingMap)
+	}
+
+	// once the binding map can be saved, the bind is retried
+	conf.DataPath = dir
+	if code := bind(); code != http.StatusCreated || len(store.secrets) != 1 || c.bindingMap["binding-1"] == nil {
+		t.Errorf("retried bind: %d, secrets %v, bindings %v", code, store.secrets, c.bindingMap)
+	}
+}
+
+// A replicaClient accepts any plan, and fails to create instances, which
+// ends a provision that passed the checks of the controller.
+type replicaClient struct {
+	client.Client
+}
+
+func (replicaClient) IsValidPlan(planID string) bool {
+	return true
+}
+
+func (replicaClient) GetCatalog() *model.Catalog {
+	return &model.Catalog{}
+}
+
+func (replicaClient) CreateInstance(plan *model.ServicePlan, parameters interface{}, orgID string) (string, error) {
+	return "", errors.New("not created")
+}
+
+func (replicaClient) ValidateParameters(plan *model.ServicePlan, parameters interface{}) error {
+	return nil
+}
+
+func TestReplicateFromAnotherSpace(t *testing.T) {
+	source := &model.ServiceInstance{
+		ID:               "source",
+		OrganizationGUID: "org-1",
+		SpaceGUID:        "space-1",
+		Credential:       model.Credential{URI: "http://10.244.1.2:8091"},
+		Setup:            &model.LastOperation{State: "succeeded"},
+	}
+	c := &Controller{
+		cloudClient: replicaClient{},
+		instanceMap: map[string]*model.ServiceInstance{source.ID: source},
+		bindingMap:  make(map[string]*model.ServiceBinding),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{service_instance_guid}", c.CreateServiceInstance)
+	provision := func(org, space string) int {
+		body := `{"plan_id": "plan-1", "organization_guid": "` + org + `", "space_guid": "` + space + `",
+			"parameters": {"replicate_from": "source"}}`
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/v2/service_instances/replica", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := provision("org-1", "space-2"); code != http.StatusForbidden {
+		t.Errorf("replica in another space: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := provision("org-2", "space-1"); code != http.StatusForbidden {
+		t.Errorf("replica in another org: got %d, want %d", code, http.StatusForbidden)
+	}
+	// the same space passes the checks, and fails to create
+	if code := provision("org-1", "space-1"); code != http.StatusInternalServerError {
+		t.Errorf("replica in the same space: got %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+// An invalidClient refuses every parameter.
+type invalidClient struct {
+	replicaClient
+}
+
+func (invalidClient) ValidateParameters(plan *model.ServicePlan, parameters interface{}) error {
+	return errors.New("bucket_ram_mb must be at least 100")
+}
+
+func (invalidClient) ValidateUpdate(plan *model.ServicePlan, parameters interface{}) error {
+	return errors.New("TLS can only be chosen when the instance is created")
+}
+
+func TestInvalidParameters(t *testing.T) {
+	instance := &model.ServiceInstance{
+		ID:            "instance-1",
+		PlanID:        "plan-1",
+		LastOperation: &model.LastOperation{State: "succeeded"},
+		Setup:         &model.LastOperation{State: "succeeded"},
+	}
+	c := &Controller{
+		cloudClient: invalidClient{},
+		instanceMap: map[string]*model.ServiceInstance{instance.ID: instance},
+		bindingMap:  make(map[string]*model.ServiceBinding),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{service_instance_guid}", c.CreateServiceInstance).Methods("PUT")
+	router.HandleFunc("/v2/service_instances/{service_instance_guid}", c.UpdateServiceInstance).Methods("PATCH")
+	send := func(method, id, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/v2/service_instances/"+id, strings.NewReader(body)))
+		return w
+	}
+
+	w := send("PUT", "instance-2", `{"plan_id": "plan-1", "parameters": {"bucket_ram_mb": 1}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "bucket_ram_mb must be at least 100") {
+		t.Errorf("provision with invalid parameters: %d %s", w.Code, w.Body)
+	}
+	if c.instanceMap["instance-2"] != nil {
+		t.Errorf("provision with invalid parameters recorded %+v", c.instanceMap["instance-2"])
+	}
+
+	w = send("PATCH", "instance-1", `{"parameters": {"tls": {}}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "TLS can only be chosen") {
+		t.Errorf("update with invalid parameters: %d %s", w.Code, w.Body)
+	}
+	if instance.Setup.State != "succeeded" {
+		t.Errorf("update with invalid parameters started: %+v", instance.Setup)
+	}
+}
@@ -0,0 +1,111 @@
+package web_server
+
+import (
+	"net/http"
+	"time"
+
+	client "github.com/ssdowd/couchbasebroker/client"
+	model "github.com/ssdowd/couchbasebroker/model"
+	utils "github.com/ssdowd/couchbasebroker/utils"
+)
+
+// defaultHealthCheckInterval is how often the health monitor polls the
+// instances unless configured otherwise.
+const defaultHealthCheckInterval = 60 * time.Second
+
+// healthCheckInterval returns how often to poll the health of the
+// instances, or 0 if the health monitor is disabled.
+func healthCheckInterval() time.Duration {
+	switch {
+	case conf.HealthCheckIntervalSeconds < 0:
+		return 0
+	case conf.HealthCheckIntervalSeconds == 0:
+		return defaultHealthCheckInterval
+	}
+	return time.Duration(conf.HealthCheckIntervalSeconds) * time.Second
+}
+
+// GetHealth implements GET /admin/health, returning the last known health
+// of every instance, by instance ID.
+func (c *Controller) GetHealth(w http.ResponseWriter, r *http.Request) {
+	health := make(map[string]*model.InstanceHealth)
+	c.mutex.RLock()
+	for _, instance := range c.instanceMap {
+		health[instance.ID] = instance.Health
+	}
+	c.mutex.RUnlock()
+	utils.WriteResponse(w, http.StatusOK, health)
+}
+
+// GetInstanceHealth implements GET /admin/service_instances/:id/health,
+// returning the last known health of an instance.
+func (c *Controller) GetInstanceHealth(w http.ResponseWriter, r *http.Request) {
+	instanceID := utils.ExtractVarsFromRequest(r, "service_instance_guid")
+	instance := c.instance(instanceID)
+	if instance == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.mutex.RLock()
+	health := instance.Health
+	c.mutex.RUnlock()
+	if health == nil {
+		utils.WriteResponse(w, http.StatusNotFound, model.Message{Description: "the health of this instance has not been checked yet"})
+		return
+	}
+	utils.WriteResponse(w, http.StatusOK, health)
+}
+
+// monitorHealth checks the health of the provisioned instances every
+// interval, forever.
+func (c *Controller) monitorHealth(interval time.Duration) {
+	utils.Logger.Printf("controller.monitorHealth: checking instances every %v\n", interval)
+	for {
+		c.checkHealth()
+		time.Sleep(interval)
+	}
+}
+
+// checkHealth polls the cluster of each provisioned instance and records
+// its health and the status of its replication, rotating certificates that
+// are about to expire.  Instances
+// being set up or updated are skipped.
+func (c *Controller) checkHealth() {
+	checked := 0
+	for _, instance := range c.instances() {
+		c.mutex.RLock()
+		setup, previous, credential := instance.Setup, instance.Health, instance.Credential
+		c.mutex.RUnlock()
+		if credential.URI == "" || (setup != nil && setup.State == "in progress") {
+			continue
+		}
+		health := client.CheckHealth(&credential)
+		if health.Status != model.HealthHealthy && (previous == nil || previous.Summary != health.Summary) {
+			utils.Logger.Printf("controller.checkHealth: %v is %v: %v\n", instance.ID, health.Status, health.Summary)
+		}
+		c.mutex.Lock()
+		instance.Health = health
+		c.mutex.Unlock()
+		c.refreshReplication(instance)
+		c.rotateExpiringCertificate(instance, health)
+		checked++
+	}
+	if checked == 0 {
+		return
+	}
+	err := c.recordInstances(nil)
+	if err != nil {
+		utils.Logger.Printf("controller.checkHealth: error saving instance map: %v\n", err)
+	}
+}
+
+// instances returns the service instances.
+func (c *Controller) instances() []*model.ServiceInstance {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	instances := make([]*model.ServiceInstance, 0, len(c.instanceMap))
+	for _, instance := range c.instanceMap {
+		instances = append(instances, instance)
+	}
+	return instances
+}
@@ -0,0 +1,87 @@
+package web_server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	client "github.com/ssdowd/couchbasebroker/client"
+	"github.com/ssdowd/couchbasebroker/couchbase/admin/admintest"
+	model "github.com/ssdowd/couchbasebroker/model"
+)
+
+// A runningClient reports every instance as running.
+type runningClient struct {
+	client.Client
+}
+
+func (runningClient) GetInstanceState(instanceID string) (string, error) {
+	return "running", nil
+}
+
+func TestReplicationStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dataPath, fileName string) {
+		conf.DataPath, conf.ServiceInstancesFileName = dataPath, fileName
+	}(conf.DataPath, conf.ServiceInstancesFileName)
+	conf.DataPath, conf.ServiceInstancesFileName = dir, "instances.json"
+
+	source := admintest.NewServer()
+	defer source.Close()
+	source.Replications["rep-1"] = &admintest.FakeReplication{ID: "rep-1", FromBucket: "orders", Status: "running"}
+	replica := admintest.NewServer()
+	defer replica.Close()
+
+	sourceInstance := &model.ServiceInstance{
+		ID:            "source",
+		Credential:    model.Credential{URI: source.URL, UserName: source.AdminUser, Password: source.AdminPassword},
+		LastOperation: &model.LastOperation{State: "succeeded"},
+		Setup:         &model.LastOperation{State: "succeeded"},
+	}
+	replicaInstance := &model.ServiceInstance{
+		ID:            "replica",
+		Credential:    model.Credential{URI: replica.URL, UserName: replica.AdminUser, Password: replica.AdminPassword},
+		LastOperation: &model.LastOperation{State: "succeeded"},
+		Setup:         &model.LastOperation{State: "succeeded", Description: "successfully created service instance"},
+		Replication:   &model.Replication{SourceInstanceID: "source", ReplicationID: "rep-1", Status: "running"},
+	}
+	c := &Controller{
+		cloudClient: runningClient{},
+		instanceMap: map[string]*model.ServiceInstance{sourceInstance.ID: sourceInstance, replicaInstance.ID: replicaInstance},
+		bindingMap:  make(map[string]*model.ServiceBinding),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{service_instance_guid}/last_operation", c.GetServiceInstance)
+	poll := func() string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/v2/service_instances/replica/last_operation", nil))
+		return w.Body.String()
+	}
+
+	source.Replications["rep-1"].Errors = []string{"target unreachable"}
+	if body := poll(); !strings.Contains(body, "replication from source: running") || strings.Contains(body, "target unreachable") {
+		t.Errorf("last_operation before a health check: %s", body)
+	}
+	if n := source.CountRequests("GET /pools/default/tasks"); n != 0 {
+		t.Errorf("last_operation polled the source %d times", n)
+	}
+
+	c.checkHealth()
+	if body := poll(); !strings.Contains(body, "replication from source: running (target unreachable)") {
+		t.Errorf("last_operation after a health check: %s", body)
+	}
+
+	// the last known status is kept while the source is unreachable
+	source.Close()
+	c.checkHealth()
+	if body := poll(); !strings.Contains(body, "replication from source: running (target unreachable)") {
+		t.Errorf("last_operation with the source unreachable: %s", body)
+	}
+}
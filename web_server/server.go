@@ -65,13 +65,22 @@ func (s *Server) Start() {
 	router.HandleFunc("/v2/catalog", s.controller.Catalog).Methods("GET")
 	router.HandleFunc("/v2/service_instances/{service_instance_guid}", s.controller.GetServiceInstance).Methods("GET")
 	router.HandleFunc("/v2/service_instances/{service_instance_guid}", s.controller.CreateServiceInstance).Methods("PUT")
+	router.HandleFunc("/v2/service_instances/{service_instance_guid}", s.controller.UpdateServiceInstance).Methods("PATCH")
 	router.HandleFunc("/v2/service_instances/{service_instance_guid}", s.controller.RemoveServiceInstance).Methods("DELETE")
 	router.HandleFunc("/v2/service_instances/{service_instance_guid}/last_operation", s.controller.GetServiceInstance).Methods("GET")
 	router.HandleFunc("/v2/service_instances/{service_instance_guid}/service_bindings/{service_binding_guid}", s.controller.Bind).Methods("PUT")
 	router.HandleFunc("/v2/service_instances/{service_instance_guid}/service_bindings/{service_binding_guid}", s.controller.UnBind).Methods("DELETE")
 
+	router.HandleFunc("/admin/health", s.controller.GetHealth).Methods("GET")
+	router.HandleFunc("/admin/service_instances/{service_instance_guid}/health", s.controller.GetInstanceHealth).Methods("GET")
+	router.HandleFunc("/admin/service_instances/{service_instance_guid}/certificate", s.controller.RotateCertificate).Methods("POST")
+
 	http.Handle("/", middleware(router))
 
+	if interval := healthCheckInterval(); interval > 0 {
+		go s.controller.monitorHealth(interval)
+	}
+
 	cfPort := os.Getenv("PORT")
 	if cfPort != "" {
 		conf.Port = cfPort
@@ -119,7 +128,8 @@ func loadServiceBindings() (map[string]*model.ServiceBinding, error) {
 
 func checkAuth(r *http.Request) bool {
 	user, pass, _ := r.BasicAuth()
-	if user == "" || user != conf.RestUser || pass != conf.RestPassword {
+	restUser, restPassword := conf.RestCredentials()
+	if user == "" || user != restUser || pass != restPassword {
 		return false
 	}
 	return true